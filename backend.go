@@ -0,0 +1,189 @@
+package incrmntr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var (
+	// ErrKeyNotFound returned by the backends when the key doesn't exist
+	ErrKeyNotFound = errors.New("key not found")
+
+	// ErrConflict returned by the backends when the key was modified
+	// concurrently, AddSafe retries the add on it
+	ErrConflict = errors.New("concurrent modification of the key")
+)
+
+// AddOp is the increment applied by Backend.Add
+type AddOp struct {
+	Delta    uint64
+	Rollover uint64
+	Initial  int64
+	Cycle    bool
+	Expiry   time.Duration
+
+	// Step is the increment of one value when the Delta is a batch of
+	// them (coalesced adds), zero for a single add
+	Step uint64
+}
+
+// CrossingError returned by Backend.Add when the batch would cross the
+// rollover, the add isn't applied and the batch is retried with the
+// values fitting below the rollover
+type CrossingError struct {
+	Current int64
+}
+
+func (e *CrossingError) Error() string {
+	return fmt.Sprintf("batch crosses the rollover at the value %d", e.Current)
+}
+
+// Crossing returns a CrossingError if the op is a batch which would cross
+// the rollover from the current value, the backends check it before Apply
+func (op AddOp) Crossing(current int64) error {
+	if op.Step == 0 || op.Delta <= op.Step || !op.Cycle || op.Rollover > math.MaxInt64 {
+		return nil
+	}
+	if current > int64(op.Rollover)-int64(op.Delta) {
+		return &CrossingError{Current: current}
+	}
+
+	return nil
+}
+
+// Apply returns the incremented value and whether it cycled back
+// to the initial value, the backends use it to calculate the new value
+func (op AddOp) Apply(current int64) (int64, bool) {
+	value := current + int64(op.Delta)
+	if op.Cycle && op.Rollover <= math.MaxInt64 && value > int64(op.Rollover) {
+		return op.Initial, true
+	}
+
+	return value, false
+}
+
+// Backend is a storage of the counters other than the Couchbase
+// bucket, every method has to be safe for concurrent use
+type Backend interface {
+	// Name is the name of the backend in the spans, e.g. redis
+	Name() string
+
+	// Get returns the value of the key or ErrKeyNotFound
+	Get(ctx context.Context, key string) (int64, error)
+
+	// Init creates the key with the value if it doesn't exist
+	// and reports whether it was created
+	Init(ctx context.Context, key string, value int64, expiry time.Duration) (bool, error)
+
+	// Add increments the existing key by the op atomically, it returns
+	// the new value, whether it cycled back, or ErrConflict
+	Add(ctx context.Context, key string, op AddOp) (int64, bool, error)
+
+	// Set overwrites the value of the key
+	Set(ctx context.Context, key string, value int64, expiry time.Duration) error
+
+	// Close releases the connections of the backend
+	Close() error
+}
+
+// NewWithBackend creates a new handler which implements the Incrmntr on the backend,
+// the features built on the Couchbase bucket (Txn, Reserver, IDGenerator) aren't available
+func NewWithBackend(backend Backend, rollover uint64, initial int64, inc uint64, cycle bool) (Incrmntr, error) {
+	if backend == nil {
+		return nil, errors.New("error backend is nil")
+	}
+
+	return &Incrementer{
+		backend:  backend,
+		rollover: rollover,
+		initial:  initial,
+		inc:      inc,
+		cycle:    cycle,
+		timeout:  5000 * time.Millisecond,
+	}, nil
+}
+
+// call runs the backend operation in a storage span, the
+// context of the operation bound by the timeout
+func (i *Incrementer) call(ctx context.Context, operation string, key string, fn func(ctx context.Context) error) error {
+	return i.storage(ctx, operation, key, func(timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return fn(ctx)
+	})
+}
+
+// backendAdd initializes the key if needed and increments it on the backend
+// by the steps, it returns the values given like addSteps
+func (i *Incrementer) backendAdd(ctx context.Context, key string, rollover uint64, steps uint64) (NullInt64, uint64, error) {
+	// ---- the first add creates the key with the initial value
+	var created bool
+	err := i.call(ctx, "Init", key, func(ctx context.Context) error {
+		var err error
+		created, err = i.backend.Init(ctx, key, i.initial, i.expiry)
+		return err
+	})
+	if err != nil {
+		return nullInt64(), 0, err
+	}
+	if created {
+		i.emit(Event{Kind: EventKeyCreated, Key: key})
+		return nullInt64From(i.initial), 1, nil
+	}
+
+	op := AddOp{
+		Delta:    i.inc * steps,
+		Rollover: rollover,
+		Initial:  i.initial,
+		Cycle:    i.cycle,
+		Expiry:   i.expiry,
+		Step:     i.inc,
+	}
+	var value int64
+	var rolled bool
+	for {
+		err = i.call(ctx, "Add", key, func(ctx context.Context) error {
+			var err error
+			value, rolled, err = i.backend.Add(ctx, key, op)
+			return err
+		})
+		var crossing *CrossingError
+		if !errors.As(err, &crossing) {
+			break
+		}
+
+		// ---- the batch crosses the rollover, give the values up to it,
+		// or roll with a single one if none fits
+		if err := ctx.Err(); err != nil {
+			return nullInt64(), 0, err
+		}
+		steps = fitSteps(crossing.Current, rollover, i.inc)
+		if steps == 0 {
+			steps = 1
+		}
+		op.Delta = i.inc * steps
+	}
+	if err != nil {
+		return nullInt64(), 0, err
+	}
+	if rolled {
+		i.emit(Event{Kind: EventRollover, Key: key})
+		spanFromContext(ctx).SetAttributes(Attribute{Key: AttrRollover, Value: true})
+		steps = 1
+	}
+
+	return nullInt64From(value), steps, nil
+}
+
+// fitSteps returns how many increments fit from the current value up to
+// the rollover
+func fitSteps(current int64, rollover uint64, inc uint64) uint64 {
+	if inc == 0 || rollover > math.MaxInt64 || current >= int64(rollover) {
+		return 0
+	}
+
+	return uint64(int64(rollover)-current) / inc
+}
@@ -0,0 +1,156 @@
+package incrmntr
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+func TestAddOpApply(t *testing.T) {
+	var cases = []struct {
+		op      AddOp
+		current int64
+		value   int64
+		rolled  bool
+	}{
+		{AddOp{Delta: 1, Rollover: 10, Initial: 1, Cycle: true}, 5, 6, false},
+		{AddOp{Delta: 1, Rollover: 10, Initial: 1, Cycle: true}, 10, 1, true},
+		{AddOp{Delta: 10, Rollover: 10, Initial: 0, Cycle: false}, 5, 15, false},
+		{AddOp{Delta: 1, Rollover: math.MaxUint64, Initial: 1, Cycle: true}, 5, 6, false},
+	}
+	for _, c := range cases {
+		value, rolled := c.op.Apply(c.current)
+		if value != c.value || rolled != c.rolled {
+			t.Errorf("Apply(%d) of %+v should be %d %v, instead of %d %v", c.current, c.op, c.value, c.rolled, value, rolled)
+		}
+	}
+}
+
+func TestAddOpCrossing(t *testing.T) {
+	var cases = []struct {
+		op       AddOp
+		current  int64
+		crossing bool
+	}{
+		{AddOp{Delta: 4, Step: 2, Rollover: 10, Cycle: true}, 6, false},
+		{AddOp{Delta: 4, Step: 2, Rollover: 10, Cycle: true}, 7, true},
+		{AddOp{Delta: 2, Step: 2, Rollover: 10, Cycle: true}, 9, false},
+		{AddOp{Delta: 4, Rollover: 10, Cycle: true}, 9, false},
+		{AddOp{Delta: 4, Step: 2, Rollover: 10, Cycle: false}, 9, false},
+		{AddOp{Delta: 4, Step: 2, Rollover: math.MaxUint64, Cycle: true}, 9, false},
+	}
+	for _, c := range cases {
+		err := c.op.Crossing(c.current)
+		var crossing *CrossingError
+		if errors.As(err, &crossing) != c.crossing || (c.crossing && crossing.Current != c.current) {
+			t.Errorf("Crossing(%d) of %+v should cross %v, instead of %v", c.current, c.op, c.crossing, err)
+		}
+	}
+}
+
+func TestBackendAddCrossing(t *testing.T) {
+	backend := newBatchBackend()
+	_ = backend.Set(context.Background(), "key", 7, 0)
+	inc, _ := NewWithBackend(backend, 10, 1, 1, true)
+	i := inc.(*Incrementer)
+
+	// ---- the batch of 5 crossing the rollover gives the 3 values up to it
+	value, given, err := i.backendAdd(context.Background(), "key", 10, 5)
+	if err != nil || value.Value != 10 || given != 3 {
+		t.Fatalf("batch should give 3 values up to 10, instead of %d %d (%v)", given, value.Value, err)
+	}
+
+	// ---- the next batch starts at the rollover and cycles back
+	value, given, err = i.backendAdd(context.Background(), "key", 10, 2)
+	if err != nil || value.Value != 1 || given != 1 {
+		t.Fatalf("batch should give the initial value, instead of %d %d (%v)", given, value.Value, err)
+	}
+}
+
+// lockExpiryBackend rejects the adds with gocb.ErrDocumentLocked until
+// the lock expires, then an other writer gets in before the first add
+// after the expiry, which fails with gocb.ErrCasMismatch
+type lockExpiryBackend struct {
+	*batchBackend
+
+	mu         sync.Mutex
+	until      time.Time
+	overtaken  bool
+	locked     int
+	mismatched int
+}
+
+func (b *lockExpiryBackend) Add(ctx context.Context, key string, op AddOp) (int64, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if time.Now().Before(b.until) {
+		b.locked++
+		return 0, false, gocb.ErrDocumentLocked
+	}
+	if !b.overtaken {
+		b.overtaken = true
+		b.mismatched++
+		_, _, _ = b.batchBackend.Add(ctx, key, op)
+		return 0, false, gocb.ErrCasMismatch
+	}
+	return b.batchBackend.Add(ctx, key, op)
+}
+
+func TestAddSafeLockExpiry(t *testing.T) {
+	backend := &lockExpiryBackend{batchBackend: newBatchBackend()}
+	inc, _ := NewWithBackend(backend, 99, 1, 1, true)
+	if _, err := inc.AddSafe("key"); err != nil {
+		t.Fatal(err)
+	}
+
+	// ---- the errors of the expired lock wrote nothing, AddSafe retries
+	// them and gets the value after the one of the other writer
+	backend.until = time.Now().Add(20 * time.Millisecond)
+	v, err := inc.AddSafe("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Value != 3 {
+		t.Errorf("value should be 3 after the other writer, instead of %d", v.Value)
+	}
+	if backend.locked == 0 || backend.mismatched != 1 {
+		t.Errorf("add should be locked and then mismatched, instead of %d %d", backend.locked, backend.mismatched)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for _, test := range []struct {
+		err       error
+		retryable bool
+	}{
+		{ErrConflict, true},
+		{gocb.ErrCasMismatch, true},
+		{gocb.ErrDocumentLocked, true},
+		{gocb.ErrTemporaryFailure, true},
+		{gocb.ErrAmbiguousTimeout, false},
+		{gocb.ErrDocumentNotFound, false},
+		{context.DeadlineExceeded, false},
+	} {
+		if retryable(test.err) != test.retryable {
+			t.Errorf("error %v should be retryable %v", test.err, test.retryable)
+		}
+	}
+}
+
+func TestFirstAddInitial(t *testing.T) {
+	inc, _ := NewWithBackend(newBatchBackend(), 99, 5, 1, true)
+
+	// ---- the first add creates the key with the initial value and returns it
+	for _, expected := range []int64{5, 6} {
+		value, err := inc.AddSafe("key")
+		if err != nil || !value.Valid || value.Value != expected {
+			t.Fatalf("value should be %d, instead of %d (%v)", expected, value.Value, err)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+// Package cache puts a read-through cache in front of the Get of an
+// Incrmntr. The cache is a bounded LRU, its entries are filled by the
+// reads and updated by the values returned from the adds and the sets
+// of the same process, and they expire after the staleness bound. The
+// writes of the other processes are seen after the bound at the latest,
+// GetFresh bypasses the cache.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+)
+
+// Defaults of the cache if the size or the staleness bound isn't set
+const (
+	DefaultSize = 1024
+	DefaultTTL  = time.Second
+)
+
+// Stats are the counts of the cache
+type Stats struct {
+	Hits      int
+	Misses    int
+	Evictions int
+}
+
+// Cache is the incrementer with the cached Get
+type Cache struct {
+	incrmntr.Base
+
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	flights map[string]*flight
+	stats   Stats
+}
+
+// entry is a cached value, it's served until expires
+type entry struct {
+	key     string
+	value   int64
+	expires time.Time
+}
+
+// flight is the calls of a key in progress, a result is cached only if
+// no write of the key overlapped the call, otherwise the order of the
+// results isn't known and the key is invalidated
+type flight struct {
+	calls  int
+	writes int
+	epoch  uint64
+}
+
+// New creates the cache of the next incrementer with the maximum number
+// of the keys and the staleness bound of the values
+func New(next incrmntr.Incrmntr, size int, ttl time.Duration) *Cache {
+	if size < 1 {
+		size = DefaultSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &Cache{
+		Base:    incrmntr.NewBase(next),
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		flights: make(map[string]*flight),
+	}
+}
+
+// Middleware returns New as a middleware
+func Middleware(size int, ttl time.Duration) incrmntr.Middleware {
+	return func(next incrmntr.Incrmntr) incrmntr.Incrmntr {
+		return New(next, size, ttl)
+	}
+}
+
+// Get returns the cached value of the key, it's read from
+// the next incrementer if it's missing or expired
+func (c *Cache) Get(key string) (int64, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+// GetContext is Get bound by the context
+func (c *Cache) GetContext(ctx context.Context, key string) (int64, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		en := e.Value.(*entry)
+		if c.now().Before(en.expires) {
+			c.lru.MoveToFront(e)
+			c.stats.Hits++
+			c.mu.Unlock()
+			return en.value, nil
+		}
+		c.remove(e)
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	return c.getFresh(ctx, key)
+}
+
+// GetFresh reads the value of the key from the next incrementer
+// bypassing the cache, the cache is updated with the value
+func (c *Cache) GetFresh(key string) (int64, error) {
+	return c.getFresh(context.Background(), key)
+}
+
+func (c *Cache) getFresh(ctx context.Context, key string) (int64, error) {
+	epoch := c.start(key, false)
+	value, err := c.Base.GetContext(ctx, key)
+	c.complete(key, false, epoch, value, err == nil)
+
+	return value, err
+}
+
+// Add adds to the key and caches the returned value, a failed add invalidates the key
+func (c *Cache) Add(key string) (incrmntr.NullInt64, error) {
+	return c.AddContext(context.Background(), key)
+}
+
+// AddContext is Add bound by the context
+func (c *Cache) AddContext(ctx context.Context, key string) (incrmntr.NullInt64, error) {
+	return c.add(key, func() (incrmntr.NullInt64, error) {
+		return c.Base.AddContext(ctx, key)
+	})
+}
+
+// AddSafe adds to the key and caches the returned value, a failed add invalidates the key
+func (c *Cache) AddSafe(key string) (incrmntr.NullInt64, error) {
+	return c.AddSafeContext(context.Background(), key)
+}
+
+// AddSafeContext is AddSafe bound by the context
+func (c *Cache) AddSafeContext(ctx context.Context, key string) (incrmntr.NullInt64, error) {
+	return c.add(key, func() (incrmntr.NullInt64, error) {
+		return c.Base.AddSafeContext(ctx, key)
+	})
+}
+
+// AddWithRollover adds to the key and caches the returned value, a failed add invalidates the key
+func (c *Cache) AddWithRollover(key string, rollover uint64) (incrmntr.NullInt64, error) {
+	return c.AddWithRolloverContext(context.Background(), key, rollover)
+}
+
+// AddWithRolloverContext is AddWithRollover bound by the context
+func (c *Cache) AddWithRolloverContext(ctx context.Context, key string, rollover uint64) (incrmntr.NullInt64, error) {
+	return c.add(key, func() (incrmntr.NullInt64, error) {
+		return c.Base.AddWithRolloverContext(ctx, key, rollover)
+	})
+}
+
+// AddSafeWithRollover adds to the key and caches the returned value, a failed add invalidates the key
+func (c *Cache) AddSafeWithRollover(key string, rollover uint64) (incrmntr.NullInt64, error) {
+	return c.AddSafeWithRolloverContext(context.Background(), key, rollover)
+}
+
+// AddSafeWithRolloverContext is AddSafeWithRollover bound by the context
+func (c *Cache) AddSafeWithRolloverContext(ctx context.Context, key string, rollover uint64) (incrmntr.NullInt64, error) {
+	return c.add(key, func() (incrmntr.NullInt64, error) {
+		return c.Base.AddSafeWithRolloverContext(ctx, key, rollover)
+	})
+}
+
+// Set sets the key and caches the value, a failed set invalidates the key
+func (c *Cache) Set(key string, value int64) error {
+	return c.SetContext(context.Background(), key, value)
+}
+
+// SetContext is Set bound by the context
+func (c *Cache) SetContext(ctx context.Context, key string, value int64) error {
+	epoch := c.start(key, true)
+	err := c.Base.SetContext(ctx, key, value)
+	c.complete(key, true, epoch, value, err == nil)
+
+	return err
+}
+
+// Reset invalidates the key, the initial value isn't known by the cache
+func (c *Cache) Reset(key string) error {
+	return c.ResetContext(context.Background(), key)
+}
+
+// ResetContext is Reset bound by the context
+func (c *Cache) ResetContext(ctx context.Context, key string) error {
+	epoch := c.start(key, true)
+	err := c.Base.ResetContext(ctx, key)
+	c.complete(key, true, epoch, 0, false)
+
+	return err
+}
+
+// Invalidate removes the key from the cache, e.g. after
+// a write done without the cache
+func (c *Cache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+}
+
+// Stats returns the counts of the cache
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
+
+// Len returns the number of the cached keys
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// add caches the value returned by the add, the failed add may be done,
+// so the key is invalidated
+func (c *Cache) add(key string, fn func() (incrmntr.NullInt64, error)) (incrmntr.NullInt64, error) {
+	epoch := c.start(key, true)
+	value, err := fn()
+	c.complete(key, true, epoch, value.Value, err == nil && value.Valid)
+
+	return value, err
+}
+
+// start registers the call of the key and returns the epoch
+// of the key at its start
+func (c *Cache) start(key string, write bool) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, ok := c.flights[key]
+	if !ok {
+		f = &flight{}
+		c.flights[key] = f
+	}
+	f.calls++
+	if write {
+		f.writes++
+		f.epoch++
+	}
+
+	return f.epoch
+}
+
+// complete unregisters the call and caches its value if it's valid and
+// the call didn't overlap with an other write, otherwise the key is
+// invalidated if the call was a write
+func (c *Cache) complete(key string, write bool, epoch uint64, value int64, valid bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f := c.flights[key]
+	others := f.writes
+	if write {
+		others--
+	}
+	switch {
+	case valid && f.epoch == epoch && others == 0:
+		c.store(key, value)
+	case write:
+		if e, ok := c.entries[key]; ok {
+			c.remove(e)
+		}
+	}
+
+	f.calls--
+	if write {
+		f.writes--
+		f.epoch++
+	}
+	if f.calls == 0 {
+		delete(c.flights, key)
+	}
+}
+
+// store caches the value and evicts the least recently used key
+// if the cache is full
+func (c *Cache) store(key string, value int64) {
+	en := &entry{key: key, value: value, expires: c.now().Add(c.ttl)}
+	if e, ok := c.entries[key]; ok {
+		e.Value = en
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(en)
+
+	if c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove removes the element from the cache
+func (c *Cache) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(*entry).key)
+}
+
+// GetFresh reads the key bypassing the cache found in the middleware
+// chain of the incrementer, or with Get if there is no cache
+func GetFresh(inc incrmntr.Incrmntr, key string) (int64, error) {
+	if c, ok := incrmntr.Lookup(inc, isCache); ok {
+		return c.(*Cache).GetFresh(key)
+	}
+
+	return inc.Get(key)
+}
+
+func isCache(inc incrmntr.Incrmntr) bool {
+	_, ok := inc.(*Cache)
+	return ok
+}
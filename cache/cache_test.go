@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+	"github.com/PumpkinSeed/incrmntr/v2/fault"
+	"github.com/PumpkinSeed/incrmntr/v2/incrmntrtest"
+)
+
+// newTestCache creates the cache of the incrementer on the backend,
+// the returned time is the manual clock of the cache
+func newTestCache(t *testing.T, backend incrmntr.Backend, size int) (*Cache, *time.Time) {
+	inc, err := incrmntr.NewWithBackend(backend, 0, 1, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(0, 0)
+	c := New(inc, size, time.Second)
+	c.now = func() time.Time { return now }
+
+	return c, &now
+}
+
+func expectGet(t *testing.T, c *Cache, key string, expected int64) {
+	t.Helper()
+	if v, err := c.Get(key); err != nil || v != expected {
+		t.Fatalf("value of %s should be %d, instead of %d (%v)", key, expected, v, err)
+	}
+}
+
+func expectAdd(t *testing.T, c *Cache, expected int64) {
+	t.Helper()
+	if v, err := c.AddSafe("key"); err != nil || v.Value != expected {
+		t.Fatalf("add should be %d, instead of %d (%v)", expected, v.Value, err)
+	}
+}
+
+func TestReadThrough(t *testing.T) {
+	memory := incrmntrtest.NewMemory()
+	c, now := newTestCache(t, memory, 0)
+	_ = memory.Set(context.Background(), "key", 5, 0)
+
+	// ---- the first read misses, the next ones hit
+	expectGet(t, c, "key", 5)
+	_ = memory.Set(context.Background(), "key", 6, 0)
+	expectGet(t, c, "key", 5)
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("stats should be 1 hit and 1 miss, instead of %+v", stats)
+	}
+
+	// ---- GetFresh bypasses and updates the cache
+	if v, err := GetFresh(c, "key"); err != nil || v != 6 {
+		t.Fatalf("fresh value should be 6, instead of %d (%v)", v, err)
+	}
+	_ = memory.Set(context.Background(), "key", 7, 0)
+	expectGet(t, c, "key", 6)
+
+	// ---- the value is stale after the bound
+	*now = now.Add(time.Second)
+	expectGet(t, c, "key", 7)
+
+	// ---- the missing key isn't cached
+	if _, err := c.Get("missing"); err == nil {
+		t.Error("missing key should fail")
+	}
+	if c.Len() != 1 {
+		t.Errorf("one key should be cached, instead of %d", c.Len())
+	}
+}
+
+func TestUpdatedByWrites(t *testing.T) {
+	memory := incrmntrtest.NewMemory()
+	c, _ := newTestCache(t, memory, 0)
+
+	// ---- the adds and the sets of the process update the value
+	for k := int64(1); k <= 3; k++ {
+		if v, err := c.AddSafe("key"); err != nil || v.Value != k {
+			t.Fatalf("add should be %d, instead of %d (%v)", k, v.Value, err)
+		}
+		expectGet(t, c, "key", k)
+	}
+	if err := c.Set("key", 10); err != nil {
+		t.Fatal(err)
+	}
+	expectGet(t, c, "key", 10)
+	if stats := c.Stats(); stats.Misses != 0 {
+		t.Errorf("reads should hit, instead of %+v", stats)
+	}
+
+	// ---- the reset and the explicit invalidation remove the key
+	if err := c.Reset("key"); err != nil {
+		t.Fatal(err)
+	}
+	expectGet(t, c, "key", 1)
+	_ = memory.Set(context.Background(), "key", 20, 0)
+	c.Invalidate("key")
+	expectGet(t, c, "key", 20)
+}
+
+func TestEviction(t *testing.T) {
+	c, _ := newTestCache(t, incrmntrtest.NewMemory(), 2)
+
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := c.AddSafe(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.Len() != 2 || c.Stats().Evictions != 1 {
+		t.Fatalf("least recently used key should be evicted, instead of %d keys %+v", c.Len(), c.Stats())
+	}
+	expectGet(t, c, "a", 1)
+	if stats := c.Stats(); stats.Misses != 1 {
+		t.Errorf("evicted key should miss, instead of %+v", stats)
+	}
+}
+
+func TestFailedWriteInvalidates(t *testing.T) {
+	memory := incrmntrtest.NewMemory()
+	backend := fault.Wrap(memory, 1)
+	c, _ := newTestCache(t, backend, 0)
+
+	expectAdd(t, c, 1)
+	backend.Inject(fault.WithLostReplies(fault.OpAdd, 1))
+	if _, err := c.AddSafe("key"); err == nil {
+		t.Fatal("add should fail")
+	}
+	backend.Clear()
+	expectGet(t, c, "key", 2)
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	memory := incrmntrtest.NewMemory()
+	backend := fault.Wrap(memory, 1, fault.WithLatency(fault.OpAll, 0, time.Millisecond))
+	c, _ := newTestCache(t, backend, 0)
+
+	// ---- the overlapping results aren't ordered, the cached
+	// value is never older than the last completed add
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 20; k++ {
+				if _, err := c.AddSafe("key"); err != nil {
+					t.Error(err)
+				}
+				_, _ = c.Get("key")
+			}
+		}()
+	}
+	wg.Wait()
+	expectGet(t, c, "key", 160)
+}
+
+func TestMiddleware(t *testing.T) {
+	inc, err := incrmntr.NewWithBackend(incrmntrtest.NewMemory(), 0, 1, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped := incrmntr.Chain(func(next incrmntr.Incrmntr) incrmntr.Incrmntr {
+		return incrmntr.NewBase(next)
+	}, Middleware(10, time.Minute))(inc)
+
+	if _, err := wrapped.AddSafe("key"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := GetFresh(wrapped, "key"); err != nil || v != 1 {
+		t.Errorf("fresh value should be 1, instead of %d (%v)", v, err)
+	}
+	if v, err := GetFresh(inc, "key"); err != nil || v != 1 {
+		t.Errorf("value without cache should be 1, instead of %d (%v)", v, err)
+	}
+}
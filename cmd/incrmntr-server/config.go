@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"time"
+)
+
+// config is the config file of the server, the counter part
+// passed as is to the framework.Counter
+type config struct {
+	Listen          string          `json:"listen"`
+	ShutdownTimeout duration        `json:"shutdown_timeout"`
+	ReadyTimeout    duration        `json:"ready_timeout"`
+	Counter         json.RawMessage `json:"counter"`
+	Metrics         metricsConfig   `json:"metrics"`
+}
+
+// metricsConfig enables the Prometheus endpoint of the server
+type metricsConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Path      string `json:"path"`
+	Namespace string `json:"namespace"`
+}
+
+// duration is a time.Duration read from strings like "10s"
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+
+	return nil
+}
+
+// loadConfig reads the config file and fills the defaults
+func loadConfig(path string) (config, error) {
+	cfg := config{
+		Listen:          ":8080",
+		ShutdownTimeout: duration{10 * time.Second},
+		ReadyTimeout:    duration{2 * time.Second},
+		Metrics: metricsConfig{
+			Path:      "/metrics",
+			Namespace: "incrmntr",
+		},
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, err
+	}
+	if len(cfg.Counter) == 0 {
+		return cfg, errors.New("error counter config is missing")
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2/framework"
+)
+
+// statusResponse is the response of the liveness endpoint
+type statusResponse struct {
+	Status string `json:"status"`
+}
+
+// healthz reports the process is alive, it doesn't touch the storage
+// so a slow bucket doesn't get the server restarted
+func healthz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, statusResponse{Status: "ok"})
+	})
+}
+
+// readyz pings the storage of the counter and returns the health report,
+// with 503 status if the storage isn't usable
+func readyz(counter framework.Counter, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		report, err := counter.Ping(ctx)
+		if err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, report)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	})
+}
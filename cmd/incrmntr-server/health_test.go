@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+)
+
+func TestHealthz(t *testing.T) {
+	counter := newMemoryCounter()
+	counter.pingErr = errors.New("bucket unreachable")
+
+	var resp statusResponse
+	if code := do(t, healthz(), "GET", "/healthz", "", &resp); code != http.StatusOK || resp.Status != "ok" {
+		t.Errorf("Liveness should be ok regardless of the storage, instead of %d %v", code, resp)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	counter := newMemoryCounter()
+	h := readyz(counter, time.Second)
+
+	var report incrmntr.HealthReport
+	if code := do(t, h, "GET", "/readyz", "", &report); code != http.StatusOK || !report.Healthy {
+		t.Errorf("Readiness should be ok, instead of %d %+v", code, report)
+	}
+
+	counter.pingErr = errors.New("bucket unreachable")
+	report = incrmntr.HealthReport{}
+	if code := do(t, h, "GET", "/readyz", "", &report); code != http.StatusServiceUnavailable || report.Healthy || report.Error != "bucket unreachable" {
+		t.Errorf("Readiness should be unavailable, instead of %d %+v", code, report)
+	}
+}
@@ -0,0 +1,78 @@
+// Command incrmntr-server exposes the counters of framework.Counter over HTTP.
+//
+//	POST /counters/{key}/next              next value of the key
+//	POST /counters/{key}/next?count=10     next 10 values of the key
+//	POST /counters/{key}/next?rollover=99  next value with custom rollover
+//	GET  /counters/{key}                   current value of the key
+//	PUT  /counters/{key}                   set the value, {"value": 10}
+//	PUT  /counters/{key}/reset             reset the key to the initial value
+//	GET  /healthz                          liveness of the server
+//	GET  /readyz                           health report of the storage
+//	GET  /metrics                          Prometheus metrics, if enabled
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/PumpkinSeed/incrmntr/v2/framework"
+	"github.com/PumpkinSeed/incrmntr/v2/metrics"
+)
+
+func main() {
+	var configPath string
+	flag.StringVar(&configPath, "config", "incrmntr-server.json", "Path of the config file")
+	flag.Parse()
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var opts []framework.Option
+	mux := http.NewServeMux()
+	if cfg.Metrics.Enabled {
+		m := metrics.New(cfg.Metrics.Namespace)
+		opts = append(opts, framework.WithWrapper(m.Wrap))
+		mux.Handle(cfg.Metrics.Path, m.Handler())
+	}
+
+	counter := framework.NewCouchbase(opts...)
+	if err := counter.Init(cfg.Counter); err != nil {
+		log.Fatal(err)
+	}
+	mux.Handle("/counters/", newServer(counter))
+	mux.Handle("/healthz", healthz())
+	mux.Handle("/readyz", readyz(counter, cfg.ReadyTimeout.Duration))
+
+	srv := &http.Server{
+		Addr:    cfg.Listen,
+		Handler: mux,
+	}
+
+	go func() {
+		log.Printf("listening on %s", cfg.Listen)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// ---- wait for the signal, then let the in-flight requests finish
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %s", err)
+	}
+	if err := counter.Stop(); err != nil {
+		log.Printf("stop counter: %s", err)
+	}
+}
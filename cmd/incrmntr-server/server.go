@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+	"github.com/PumpkinSeed/incrmntr/v2/framework"
+	"github.com/couchbase/gocb/v2"
+)
+
+// maxBatch is the highest count of a batch next request
+const maxBatch = 1000
+
+// server is the HTTP handler of the counter endpoints
+type server struct {
+	counter framework.Counter
+}
+
+func newServer(counter framework.Counter) http.Handler {
+	return &server{counter: counter}
+}
+
+// valueResponse is the response of the single value endpoints
+type valueResponse struct {
+	Key   string `json:"key"`
+	Value int64  `json:"value"`
+}
+
+// valuesResponse is the response of the batch next endpoint
+type valuesResponse struct {
+	Key    string  `json:"key"`
+	Values []int64 `json:"values"`
+}
+
+// errorResponse is the structured error of every endpoint, a failed
+// batch next returns the values given before the failure too
+type errorResponse struct {
+	Error  errorBody `json:"error"`
+	Key    string    `json:"key,omitempty"`
+	Values []int64   `json:"values,omitempty"`
+}
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// setRequest is the body of the PUT endpoint
+type setRequest struct {
+	Value *int64 `json:"value"`
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// ---- routes are /counters/{key}, /counters/{key}/next and /counters/{key}/reset
+	path := strings.TrimPrefix(r.URL.Path, "/counters/")
+	if path == r.URL.Path || path == "" {
+		writeError(w, http.StatusNotFound, "not_found", "unknown endpoint")
+		return
+	}
+	key, action := path, ""
+	if idx := strings.LastIndexByte(path, '/'); idx >= 0 {
+		key, action = path[:idx], path[idx+1:]
+	}
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "invalid_key", "key is empty")
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		s.get(w, key)
+	case action == "" && r.Method == http.MethodPut:
+		s.set(w, r, key)
+	case action == "next" && r.Method == http.MethodPost:
+		s.next(w, r, key)
+	case action == "reset" && r.Method == http.MethodPut:
+		s.reset(w, key)
+	case action == "" || action == "next" || action == "reset":
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not allowed")
+	default:
+		writeError(w, http.StatusNotFound, "not_found", "unknown endpoint")
+	}
+}
+
+// get returns the current value of the key
+func (s *server) get(w http.ResponseWriter, key string) {
+	value, err := s.counter.Get(key)
+	if err != nil {
+		writeCounterError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, valueResponse{Key: key, Value: value})
+}
+
+// set overwrites the value of the key
+func (s *server) set(w http.ResponseWriter, r *http.Request, key string) {
+	var req setRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Value == nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", `body should be {"value": <int>}`)
+		return
+	}
+	if err := s.counter.Set(key, *req.Value); err != nil {
+		writeCounterError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, valueResponse{Key: key, Value: *req.Value})
+}
+
+// reset puts back the key to the initial value
+func (s *server) reset(w http.ResponseWriter, key string) {
+	if err := s.counter.Reset(key); err != nil {
+		writeCounterError(w, err)
+		return
+	}
+	value, err := s.counter.Get(key)
+	if err != nil {
+		writeCounterError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, valueResponse{Key: key, Value: value})
+}
+
+// next increments the key, count and rollover are optional query parameters
+func (s *server) next(w http.ResponseWriter, r *http.Request, key string) {
+	query := r.URL.Query()
+
+	count := 1
+	if v := query.Get("count"); v != "" {
+		var err error
+		count, err = strconv.Atoi(v)
+		if err != nil || count < 1 || count > maxBatch {
+			writeError(w, http.StatusBadRequest, "invalid_count", "count should be between 1 and "+strconv.Itoa(maxBatch))
+			return
+		}
+	}
+
+	var rollover uint64
+	var withRollover bool
+	if v := query.Get("rollover"); v != "" {
+		var err error
+		rollover, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_rollover", "rollover should be a positive integer")
+			return
+		}
+		withRollover = true
+	}
+
+	values := make([]int64, 0, count)
+	for i := 0; i < count; i++ {
+		var value int64
+		var err error
+		if withRollover {
+			value, err = s.counter.NextValWithRollover(key, rollover)
+		} else {
+			value, err = s.counter.NextVal(key)
+		}
+		if err != nil {
+			// ---- the given values are used up, they are returned with the error
+			status, code := counterError(err)
+			writeJSON(w, status, errorResponse{
+				Error:  errorBody{Code: code, Message: err.Error()},
+				Key:    key,
+				Values: values,
+			})
+			return
+		}
+		values = append(values, value)
+	}
+
+	if query.Get("count") == "" {
+		writeJSON(w, http.StatusOK, valueResponse{Key: key, Value: values[0]})
+		return
+	}
+	writeJSON(w, http.StatusOK, valuesResponse{Key: key, Values: values})
+}
+
+// writeCounterError writes the error of the counter
+func writeCounterError(w http.ResponseWriter, err error) {
+	status, code := counterError(err)
+	writeError(w, status, code, err.Error())
+}
+
+// counterError maps the errors of the counter to status codes
+func counterError(err error) (int, string) {
+	switch {
+	case errors.Is(err, gocb.ErrDocumentNotFound), errors.Is(err, incrmntr.ErrKeyNotFound):
+		return http.StatusNotFound, "key_not_found"
+	case errors.Is(err, gocb.ErrDocumentLocked), errors.Is(err, gocb.ErrTemporaryFailure), errors.Is(err, incrmntr.ErrConflict):
+		return http.StatusServiceUnavailable, "key_locked"
+	case errors.Is(err, gocb.ErrTimeout):
+		return http.StatusGatewayTimeout, "timeout"
+	case errors.Is(err, incrmntr.ErrClosed):
+		return http.StatusServiceUnavailable, "closed"
+	default:
+		return http.StatusInternalServerError, "internal"
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeJSON(w, status, errorResponse{Error: errorBody{Code: code, Message: message}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
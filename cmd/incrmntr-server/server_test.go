@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+	"github.com/couchbase/gocb/v2"
+)
+
+// memoryCounter is an in-memory framework.Counter for the handler tests
+type memoryCounter struct {
+	sync.Mutex
+	rollover uint64
+	initial  int64
+	values   map[string]int64
+	pingErr  error
+
+	// failAfter fails the nexts after the given number of nexts if it's set
+	failAfter int
+	nexts     int
+}
+
+func newMemoryCounter() *memoryCounter {
+	return &memoryCounter{rollover: 999, initial: 1, values: map[string]int64{}}
+}
+
+func (c *memoryCounter) Init(config []byte) error { return nil }
+
+func (c *memoryCounter) NextVal(key string) (int64, error) {
+	return c.NextValWithRollover(key, c.rollover)
+}
+
+func (c *memoryCounter) NextValWithRollover(key string, rollover uint64) (int64, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.failAfter > 0 && c.nexts >= c.failAfter {
+		return 0, gocb.ErrTimeout
+	}
+	c.nexts++
+	v, ok := c.values[key]
+	if !ok {
+		v = c.initial - 1
+	}
+	v++
+	if v > int64(rollover) {
+		v = c.initial
+	}
+	c.values[key] = v
+	return v, nil
+}
+
+func (c *memoryCounter) Get(key string) (int64, error) {
+	c.Lock()
+	defer c.Unlock()
+	v, ok := c.values[key]
+	if !ok {
+		return 0, gocb.ErrDocumentNotFound
+	}
+	return v, nil
+}
+
+func (c *memoryCounter) Set(key string, value int64) error {
+	c.Lock()
+	defer c.Unlock()
+	c.values[key] = value
+	return nil
+}
+
+func (c *memoryCounter) Reset(key string) error {
+	return c.Set(key, c.initial)
+}
+
+func (c *memoryCounter) Ping(ctx context.Context) (incrmntr.HealthReport, error) {
+	report := incrmntr.HealthReport{Healthy: c.pingErr == nil, CheckedAt: time.Now()}
+	if c.pingErr != nil {
+		report.Error = c.pingErr.Error()
+	}
+	return report, c.pingErr
+}
+
+func (c *memoryCounter) Health() incrmntr.HealthReport {
+	report, _ := c.Ping(context.Background())
+	return report
+}
+
+func (c *memoryCounter) Stop() error { return nil }
+
+func do(t *testing.T, h http.Handler, method string, target string, body string, out interface{}) int {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if out != nil {
+		if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: invalid json response: %s", method, target, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestServerNext(t *testing.T) {
+	h := newServer(newMemoryCounter())
+
+	var value valueResponse
+	for i := 0; i < 3; i++ {
+		if code := do(t, h, http.MethodPost, "/counters/orders/next", "", &value); code != http.StatusOK {
+			t.Fatalf("Status should be 200, instead of %d", code)
+		}
+	}
+	if value.Key != "orders" || value.Value != 3 {
+		t.Errorf("Response should be orders=3, instead of %s=%d", value.Key, value.Value)
+	}
+
+	var values valuesResponse
+	if code := do(t, h, http.MethodPost, "/counters/orders/next?count=3&rollover=4", "", &values); code != http.StatusOK {
+		t.Fatalf("Status should be 200, instead of %d", code)
+	}
+	if len(values.Values) != 3 || values.Values[0] != 4 || values.Values[1] != 1 || values.Values[2] != 2 {
+		t.Errorf("Values should be [4 1 2], instead of %v", values.Values)
+	}
+
+	if code := do(t, h, http.MethodGet, "/counters/orders", "", &value); code != http.StatusOK || value.Value != 2 {
+		t.Errorf("Get should return 200 and 2, instead of %d and %d", code, value.Value)
+	}
+}
+
+func TestServerNextPartial(t *testing.T) {
+	counter := newMemoryCounter()
+	counter.failAfter = 2
+	h := newServer(counter)
+
+	// ---- the values given before the failure are returned with the error
+	var resp errorResponse
+	if code := do(t, h, http.MethodPost, "/counters/orders/next?count=5", "", &resp); code != http.StatusGatewayTimeout {
+		t.Fatalf("Status should be 504, instead of %d", code)
+	}
+	if resp.Error.Code != "timeout" || resp.Key != "orders" {
+		t.Errorf("Error should be timeout of orders, instead of %s of %s", resp.Error.Code, resp.Key)
+	}
+	if len(resp.Values) != 2 || resp.Values[0] != 1 || resp.Values[1] != 2 {
+		t.Errorf("Values should be [1 2], instead of %v", resp.Values)
+	}
+}
+
+func TestServerSetReset(t *testing.T) {
+	h := newServer(newMemoryCounter())
+
+	var value valueResponse
+	if code := do(t, h, http.MethodPut, "/counters/tickets", `{"value": 42}`, &value); code != http.StatusOK || value.Value != 42 {
+		t.Errorf("Set should return 200 and 42, instead of %d and %d", code, value.Value)
+	}
+	if code := do(t, h, http.MethodPost, "/counters/tickets/next", "", &value); code != http.StatusOK || value.Value != 43 {
+		t.Errorf("Next should return 200 and 43, instead of %d and %d", code, value.Value)
+	}
+	if code := do(t, h, http.MethodPut, "/counters/tickets/reset", "", &value); code != http.StatusOK || value.Value != 1 {
+		t.Errorf("Reset should return 200 and 1, instead of %d and %d", code, value.Value)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	h := newServer(newMemoryCounter())
+
+	var cases = []struct {
+		method string
+		target string
+		body   string
+		status int
+		code   string
+	}{
+		{http.MethodGet, "/counters/missing", "", http.StatusNotFound, "key_not_found"},
+		{http.MethodPut, "/counters/key", `{}`, http.StatusBadRequest, "invalid_body"},
+		{http.MethodPost, "/counters/key/next?count=0", "", http.StatusBadRequest, "invalid_count"},
+		{http.MethodPost, "/counters/key/next?rollover=-1", "", http.StatusBadRequest, "invalid_rollover"},
+		{http.MethodDelete, "/counters/key", "", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{http.MethodGet, "/other", "", http.StatusNotFound, "not_found"},
+	}
+	for _, c := range cases {
+		var resp errorResponse
+		if code := do(t, h, c.method, c.target, c.body, &resp); code != c.status || resp.Error.Code != c.code {
+			t.Errorf("%s %s should return %d %s, instead of %d %s", c.method, c.target, c.status, c.code, code, resp.Error.Code)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// env is the environment of a running command
+type env struct {
+	store  store
+	args   []string
+	stdin  io.Reader
+	out    *output
+	stderr io.Writer
+}
+
+// usageError is returned on invalid arguments of a command
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+var commands = map[string]func(e *env) error{
+	"get":    get,
+	"next":   next,
+	"set":    set,
+	"reset":  reset,
+	"delete": remove,
+	"list":   list,
+	"export": export,
+	"import": load,
+	"bench":  bench,
+}
+
+func get(e *env) error {
+	if len(e.args) == 0 {
+		return usageError("usage: get <key>...")
+	}
+
+	entries := make([]entry, 0, len(e.args))
+	for _, key := range e.args {
+		value, err := e.store.Get(key)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{Key: key, Value: value})
+	}
+
+	return e.out.entries(entries)
+}
+
+func next(e *env) error {
+	flags := flag.NewFlagSet("next", flag.ContinueOnError)
+	flags.SetOutput(e.stderr)
+	n := flags.Int("n", 1, "Number of increments")
+	rollover := flags.Uint64("rollover", 0, "Custom rollover, 0 means the configured one")
+	if err := flags.Parse(e.args); err != nil {
+		return usageError("usage: next [-n 1] [-rollover 0] <key>")
+	}
+	if flags.NArg() != 1 || *n < 1 {
+		return usageError("usage: next [-n 1] [-rollover 0] <key>")
+	}
+
+	key := flags.Arg(0)
+	entries := make([]entry, 0, *n)
+	for i := 0; i < *n; i++ {
+		value, err := e.store.Next(key, *rollover)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{Key: key, Value: value})
+	}
+
+	return e.out.entries(entries)
+}
+
+func set(e *env) error {
+	if len(e.args) != 2 {
+		return usageError("usage: set <key> <value>")
+	}
+	value, err := strconv.ParseInt(e.args[1], 10, 64)
+	if err != nil {
+		return usageError("value should be an integer")
+	}
+	if err := e.store.Set(e.args[0], value); err != nil {
+		return err
+	}
+
+	return e.out.entries([]entry{{Key: e.args[0], Value: value}})
+}
+
+func reset(e *env) error {
+	if len(e.args) != 1 {
+		return usageError("usage: reset <key>")
+	}
+	if err := e.store.Reset(e.args[0]); err != nil {
+		return err
+	}
+
+	return get(e)
+}
+
+func remove(e *env) error {
+	if len(e.args) != 1 {
+		return usageError("usage: delete <key>")
+	}
+
+	return e.store.Delete(e.args[0])
+}
+
+func list(e *env) error {
+	entries, err := entriesWithPrefix(e)
+	if err != nil {
+		return err
+	}
+
+	return e.out.entries(entries)
+}
+
+// export writes JSON regardless of the output format, so import can read it
+func export(e *env) error {
+	entries, err := entriesWithPrefix(e)
+	if err != nil {
+		return err
+	}
+	if entries == nil {
+		entries = []entry{}
+	}
+
+	return e.out.json(entries)
+}
+
+func entriesWithPrefix(e *env) ([]entry, error) {
+	if len(e.args) > 1 {
+		return nil, usageError("usage: list|export [prefix]")
+	}
+	var prefix string
+	if len(e.args) == 1 {
+		prefix = e.args[0]
+	}
+
+	return e.store.List(prefix)
+}
+
+// load is the import command, sets every key of the export file
+func load(e *env) error {
+	if len(e.args) != 1 {
+		return usageError("usage: import <file>")
+	}
+
+	var data []byte
+	var err error
+	if e.args[0] == "-" {
+		data, err = ioutil.ReadAll(e.stdin)
+	} else {
+		data, err = ioutil.ReadFile(e.args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	var entries []entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	for _, en := range entries {
+		if err := e.store.Set(en.Key, en.Value); err != nil {
+			return err
+		}
+	}
+
+	return e.out.table([]string{"imported"}, [][]interface{}{{len(entries)}})
+}
+
+func bench(e *env) error {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	flags.SetOutput(e.stderr)
+	n := flags.Int("n", 1000, "Number of increments")
+	c := flags.Int("c", 10, "Number of concurrent workers")
+	if err := flags.Parse(e.args); err != nil {
+		return usageError("usage: bench [-n 1000] [-c 10] <key>")
+	}
+	if flags.NArg() != 1 || *n < 1 || *c < 1 {
+		return usageError("usage: bench [-n 1000] [-c 10] <key>")
+	}
+	key := flags.Arg(0)
+
+	var mu sync.Mutex
+	var failed int
+	latencies := make([]time.Duration, 0, *n)
+	jobs := make(chan struct{}, *n)
+	for i := 0; i < *n; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := 0; w < *c; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				t := time.Now()
+				_, err := e.store.Next(key, 0)
+				elapsed := time.Since(t)
+				mu.Lock()
+				if err != nil {
+					failed++
+				} else {
+					latencies = append(latencies, elapsed)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	total := time.Since(start)
+
+	return e.out.table(
+		[]string{"ops", "failed", "ops_per_sec", "p50", "p99", "max"},
+		[][]interface{}{{
+			len(latencies),
+			failed,
+			int64(float64(len(latencies)) / total.Seconds()),
+			percentile(latencies, 0.50).String(),
+			percentile(latencies, 0.99).String(),
+			percentile(latencies, 1).String(),
+		}},
+	)
+}
+
+// percentile returns the p percentile of the latencies, sorts them in place
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sort.Slice(latencies, func(a, b int) bool {
+		return latencies[a] < latencies[b]
+	})
+	idx := int(p*float64(len(latencies))+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(latencies) {
+		idx = len(latencies) - 1
+	}
+
+	return latencies[idx]
+}
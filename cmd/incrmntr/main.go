@@ -0,0 +1,98 @@
+// Command incrmntr inspects and manages the counters from a terminal.
+//
+//	incrmntr [-config incrmntr.json] [-o table|json] <command> [arguments]
+//
+// The config file has the same shape as the config of framework.Counter.
+//
+//	get <key>...                      current values of the keys
+//	next [-n 1] [-rollover 0] <key>   increment the key
+//	set <key> <value>                 overwrite the value of the key
+//	reset <key>                       put back the key to the initial value
+//	delete <key>                      remove the key
+//	list [prefix]                     keys and values with the prefix
+//	export [prefix]                   keys and values with the prefix as JSON
+//	import <file>                     set the keys of an export, - is stdin
+//	bench [-n 1000] [-c 10] <key>     measure the increments of the key
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// usage is printed on invalid command line
+const usage = `usage: incrmntr [-config incrmntr.json] [-o table|json] <command> [arguments]
+
+commands:
+  get <key>...                      current values of the keys
+  next [-n 1] [-rollover 0] <key>   increment the key
+  set <key> <value>                 overwrite the value of the key
+  reset <key>                       put back the key to the initial value
+  delete <key>                      remove the key
+  list [prefix]                     keys and values with the prefix
+  export [prefix]                   keys and values with the prefix as JSON
+  import <file>                     set the keys of an export, - is stdin
+  bench [-n 1000] [-c 10] <key>     measure the increments of the key
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr, openCouchbase))
+}
+
+// run parses the command line, opens the store and executes the command
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer, open func(config) (store, error)) int {
+	flags := flag.NewFlagSet("incrmntr", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+	configPath := flags.String("config", "incrmntr.json", "Path of the config file")
+	format := flags.String("o", "table", "Output format, table or json")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(stderr, "unknown output format %q\n", *format)
+		return 2
+	}
+
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n", flags.Arg(0))
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	s, err := open(cfg)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer s.Close()
+
+	e := &env{
+		store:  s,
+		args:   flags.Args()[1:],
+		stdin:  stdin,
+		out:    newOutput(stdout, *format),
+		stderr: stderr,
+	}
+	if err := cmd(e); err != nil {
+		fmt.Fprintln(stderr, err)
+		if _, ok := err.(usageError); ok {
+			return 2
+		}
+		return 1
+	}
+
+	return 0
+}
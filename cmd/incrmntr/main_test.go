@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memoryStore is an in-memory store for the command tests
+type memoryStore struct {
+	sync.Mutex
+	values map[string]int64
+}
+
+func (s *memoryStore) Get(key string) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, ok := s.values[key]
+	if !ok {
+		return 0, errors.New("document not found")
+	}
+	return v, nil
+}
+
+func (s *memoryStore) Next(key string, rollover uint64) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.values[key]++
+	if rollover > 0 && s.values[key] > int64(rollover) {
+		s.values[key] = 1
+	}
+	return s.values[key], nil
+}
+
+func (s *memoryStore) Set(key string, value int64) error {
+	s.Lock()
+	defer s.Unlock()
+	s.values[key] = value
+	return nil
+}
+
+func (s *memoryStore) Reset(key string) error {
+	return s.Set(key, 1)
+}
+
+func (s *memoryStore) Delete(key string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.values, key)
+	return nil
+}
+
+func (s *memoryStore) List(prefix string) ([]entry, error) {
+	s.Lock()
+	defer s.Unlock()
+	var entries []entry
+	for k, v := range s.values {
+		if strings.HasPrefix(k, prefix) {
+			entries = append(entries, entry{Key: k, Value: v})
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Key < entries[b].Key })
+	return entries, nil
+}
+
+func (s *memoryStore) Close() error { return nil }
+
+// runTest executes the command line on the store and returns the exit code and stdout
+func runTest(t *testing.T, s *memoryStore, stdin string, args ...string) (int, string) {
+	dir, err := ioutil.TempDir("", "incrmntr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfgPath := filepath.Join(dir, "incrmntr.json")
+	if err := ioutil.WriteFile(cfgPath, []byte(`{"address":"couchbase://localhost","bucket":"increment","rollover":999,"initial":1}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := run(append([]string{"-config", cfgPath}, args...), strings.NewReader(stdin), &stdout, &stderr, func(cfg config) (store, error) {
+		if cfg.Bucket != "increment" {
+			t.Errorf("Bucket should be increment, instead of %s", cfg.Bucket)
+		}
+		return s, nil
+	})
+	return code, stdout.String()
+}
+
+func TestCommands(t *testing.T) {
+	s := &memoryStore{values: map[string]int64{}}
+
+	if code, out := runTest(t, s, "", "next", "-n", "3", "orders"); code != 0 || !strings.Contains(out, "orders  3") {
+		t.Errorf("next should print orders 3, instead of %d %q", code, out)
+	}
+	if code, _ := runTest(t, s, "", "set", "tickets", "40"); code != 0 {
+		t.Errorf("set should succeed, instead of %d", code)
+	}
+
+	code, out := runTest(t, s, "", "-o", "json", "get", "tickets")
+	var entries []entry
+	if err := json.Unmarshal([]byte(out), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if code != 0 || len(entries) != 1 || entries[0].Value != 40 {
+		t.Errorf("get should return tickets=40, instead of %d %v", code, entries)
+	}
+
+	if code, out := runTest(t, s, "", "reset", "tickets"); code != 0 || !strings.Contains(out, "tickets  1") {
+		t.Errorf("reset should print tickets 1, instead of %d %q", code, out)
+	}
+	if code, _ := runTest(t, s, "", "delete", "tickets"); code != 0 || len(s.values) != 1 {
+		t.Errorf("delete should remove the key, instead of %d %v", code, s.values)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	source := &memoryStore{values: map[string]int64{"inv:a": 5, "inv:b": 7, "other": 1}}
+	code, out := runTest(t, source, "", "export", "inv:")
+	if code != 0 {
+		t.Fatalf("export should succeed, instead of %d", code)
+	}
+
+	target := &memoryStore{values: map[string]int64{}}
+	if code, _ := runTest(t, target, out, "import", "-"); code != 0 {
+		t.Fatalf("import should succeed, instead of %d", code)
+	}
+	if len(target.values) != 2 || target.values["inv:a"] != 5 || target.values["inv:b"] != 7 {
+		t.Errorf("Imported values should be inv:a=5 inv:b=7, instead of %v", target.values)
+	}
+}
+
+func TestBench(t *testing.T) {
+	s := &memoryStore{values: map[string]int64{}}
+	code, out := runTest(t, s, "", "-o", "json", "bench", "-n", "100", "-c", "4", "bench")
+
+	var result []map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatal(err)
+	}
+	if code != 0 || len(result) != 1 || result[0]["ops"] != float64(100) {
+		t.Errorf("bench should report 100 ops, instead of %d %v", code, result)
+	}
+	if s.values["bench"] != 100 {
+		t.Errorf("Value should be 100, instead of %d", s.values["bench"])
+	}
+}
+
+func TestUsage(t *testing.T) {
+	s := &memoryStore{values: map[string]int64{}}
+	for _, args := range [][]string{{}, {"unknown"}, {"set", "key"}, {"set", "key", "x"}, {"-o", "xml", "get", "key"}} {
+		if code, _ := runTest(t, s, "", args...); code != 2 {
+			t.Errorf("%v should exit with 2, instead of %d", args, code)
+		}
+	}
+}
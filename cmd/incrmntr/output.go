@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// output writes the results as aligned table or JSON
+type output struct {
+	w      io.Writer
+	format string
+}
+
+func newOutput(w io.Writer, format string) *output {
+	return &output{w: w, format: format}
+}
+
+// entries writes the key-value pairs
+func (o *output) entries(entries []entry) error {
+	if o.format == "json" {
+		if entries == nil {
+			entries = []entry{}
+		}
+		return o.json(entries)
+	}
+
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%d\n", e.Key, e.Value)
+	}
+
+	return tw.Flush()
+}
+
+// table writes the rows under the header, or an object
+// of the header fields per row as JSON
+func (o *output) table(header []string, rows [][]interface{}) error {
+	if o.format == "json" {
+		objects := make([]map[string]interface{}, 0, len(rows))
+		for _, row := range rows {
+			object := make(map[string]interface{}, len(header))
+			for k, name := range header {
+				object[name] = row[k]
+			}
+			objects = append(objects, object)
+		}
+		return o.json(objects)
+	}
+
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	for k, name := range header {
+		if k > 0 {
+			fmt.Fprint(tw, "\t")
+		}
+		fmt.Fprint(tw, name)
+	}
+	fmt.Fprintln(tw)
+	for _, row := range rows {
+		for k, v := range row {
+			if k > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, v)
+		}
+		fmt.Fprintln(tw)
+	}
+
+	return tw.Flush()
+}
+
+func (o *output) json(v interface{}) error {
+	enc := json.NewEncoder(o.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
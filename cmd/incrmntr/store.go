@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+	"github.com/couchbase/gocb/v2"
+)
+
+// config has the same shape as the config of framework.Counter
+type config struct {
+	Address        string `json:"address"`
+	Username       string `json:"username"`
+	Password       string `json:"password"`
+	Bucket         string `json:"bucket"`
+	BucketPassword string `json:"bucket_password"`
+	Rollover       uint64 `json:"rollover"`
+	Initial        int64  `json:"initial"`
+}
+
+// entry is a counter key with the value of it
+type entry struct {
+	Key   string `json:"key"`
+	Value int64  `json:"value"`
+}
+
+// store is the set of operations the commands need
+type store interface {
+	Get(key string) (int64, error)
+	Next(key string, rollover uint64) (int64, error)
+	Set(key string, value int64) error
+	Reset(key string) error
+	Delete(key string) error
+	List(prefix string) ([]entry, error)
+	Close() error
+}
+
+// couchbaseStore is the store of the counters in the couchbase bucket
+type couchbaseStore struct {
+	cluster *gocb.Cluster
+	bucket  *gocb.Bucket
+	inc     incrmntr.Incrmntr
+	timeout time.Duration
+}
+
+// loadConfig reads the config file
+func loadConfig(path string) (config, error) {
+	var cfg config
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	err = json.Unmarshal(data, &cfg)
+
+	return cfg, err
+}
+
+// openCouchbase connects to the cluster of the config
+func openCouchbase(cfg config) (store, error) {
+	cluster, err := gocb.Connect(cfg.Address, gocb.ClusterOptions{
+		TimeoutsConfig: gocb.TimeoutsConfig{KVTimeout: 10 * time.Second, QueryTimeout: 10 * time.Second},
+		Authenticator: gocb.PasswordAuthenticator{
+			Username: cfg.Username,
+			Password: cfg.Password,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bucket := cluster.Bucket(cfg.Bucket)
+	inc, err := incrmntr.New(bucket, cfg.Rollover, cfg.Initial, 1, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &couchbaseStore{
+		cluster: cluster,
+		bucket:  bucket,
+		inc:     inc,
+		timeout: 10 * time.Second,
+	}, nil
+}
+
+func (s *couchbaseStore) Get(key string) (int64, error) {
+	return s.inc.Get(key)
+}
+
+func (s *couchbaseStore) Next(key string, rollover uint64) (int64, error) {
+	var value incrmntr.NullInt64
+	var err error
+	if rollover > 0 {
+		value, err = s.inc.AddSafeWithRollover(key, rollover)
+	} else {
+		value, err = s.inc.AddSafe(key)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !value.Valid {
+		return 0, errors.New("error invalid value")
+	}
+
+	return value.Value, nil
+}
+
+func (s *couchbaseStore) Set(key string, value int64) error {
+	return s.inc.Set(key, value)
+}
+
+func (s *couchbaseStore) Reset(key string) error {
+	return s.inc.Reset(key)
+}
+
+func (s *couchbaseStore) Delete(key string) error {
+	_, err := s.bucket.DefaultCollection().Remove(key, &gocb.RemoveOptions{
+		Timeout: s.timeout,
+	})
+
+	return err
+}
+
+// List queries the numeric documents of the bucket with the key prefix,
+// it needs a primary index on the bucket
+func (s *couchbaseStore) List(prefix string) ([]entry, error) {
+	statement := fmt.Sprintf("SELECT META(c).id AS `key`, c AS `value` FROM `%s` c WHERE META(c).id LIKE $1 AND ISNUMBER(c) ORDER BY META(c).id", s.bucket.Name())
+	res, err := s.cluster.Query(statement, &gocb.QueryOptions{
+		PositionalParameters: []interface{}{prefix + "%"},
+		Timeout:              s.timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var entries []entry
+	for res.Next() {
+		var e entry
+		if err := res.Row(&e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, res.Err()
+}
+
+func (s *couchbaseStore) Close() error {
+	return s.cluster.Close(nil)
+}
@@ -0,0 +1,216 @@
+package incrmntr
+
+import (
+	"context"
+	"math"
+	"sync"
+)
+
+// coalescer batches the concurrent AddSafe calls of a key into one add of
+// the steps of all of them, the contiguous values of the add are handed
+// out to the callers in the order of their arrival
+type coalescer struct {
+	max uint64
+
+	mu     sync.Mutex
+	queues map[coalesceKey]*coalesceQueue
+}
+
+// coalesceKey is the key and the rollover of the batched adds
+type coalesceKey struct {
+	key      string
+	rollover uint64
+}
+
+// coalesceQueue is the waiting calls of a key, one call at a time
+// leads: takes the waiting calls and runs the add of the batch
+type coalesceQueue struct {
+	waiting []*coalesceCall
+	leading bool
+}
+
+// coalesceCall is a call waiting for its value or for the lead
+type coalesceCall struct {
+	ctx  context.Context
+	done chan coalesceResult
+}
+
+type coalesceResult struct {
+	value NullInt64
+	err   error
+	lead  bool
+}
+
+// SetCoalescing batches the concurrent AddSafe calls of the same key into
+// one add of at most max steps, 0 or 1 disables it. The adds of a cycling
+// counter are batched up to the length of a cycle, a batch crossing the
+// rollover gets the values up to it and the rest of the calls wait for
+// the next batch, which cycles back, so no value of the cycle is skipped.
+func (i *Incrementer) SetCoalescing(max int) {
+	if max <= 1 {
+		i.coalescer = nil
+		return
+	}
+	i.coalescer = &coalescer{
+		max:    uint64(max),
+		queues: make(map[coalesceKey]*coalesceQueue),
+	}
+}
+
+// add waits for the value of the call, the call leads the batch if
+// no add of the key is in progress
+func (c *coalescer) add(ctx context.Context, i *Incrementer, key string, rollover uint64) (NullInt64, error) {
+	k := coalesceKey{key: key, rollover: rollover}
+	call := &coalesceCall{ctx: ctx, done: make(chan coalesceResult, 1)}
+
+	c.mu.Lock()
+	q, ok := c.queues[k]
+	if !ok {
+		q = &coalesceQueue{}
+		c.queues[k] = q
+	}
+	lead := !q.leading
+	if lead {
+		q.leading = true
+	} else {
+		q.waiting = append(q.waiting, call)
+	}
+	c.mu.Unlock()
+
+	for !lead {
+		var res coalesceResult
+		select {
+		case res = <-call.done:
+		case <-ctx.Done():
+			if c.leave(k, call) {
+				return nullInt64(), ctx.Err()
+			}
+			// ---- the call is in a batch already or got the lead
+			res = <-call.done
+			if res.lead {
+				c.mu.Lock()
+				c.handoff(k)
+				c.mu.Unlock()
+				return nullInt64(), ctx.Err()
+			}
+		}
+		if !res.lead {
+			return res.value, res.err
+		}
+		lead = true
+	}
+
+	return c.lead(ctx, i, k, call)
+}
+
+// lead runs the add of the batch of the waiting calls and hands out the
+// values, the calls without value wait for the next batch
+func (c *coalescer) lead(ctx context.Context, i *Incrementer, k coalesceKey, call *coalesceCall) (NullInt64, error) {
+	limit := c.size(i, k.rollover)
+
+	c.mu.Lock()
+	q := c.queues[k]
+	batch := []*coalesceCall{call}
+	for len(q.waiting) > 0 && uint64(len(batch)) < limit {
+		next := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		if err := next.ctx.Err(); err != nil {
+			next.done <- coalesceResult{err: err}
+			continue
+		}
+		batch = append(batch, next)
+	}
+	c.mu.Unlock()
+
+	last, given, err := i.addSafeSteps(ctx, k.key, k.rollover, uint64(len(batch)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var value NullInt64
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// ---- only the context of the leader is done,
+		// the others wait for the next batch
+		c.requeue(q, batch[1:])
+	case err != nil:
+		for _, b := range batch[1:] {
+			b.done <- coalesceResult{err: err}
+		}
+	default:
+		first := last.Value - int64((given-1)*i.inc)
+		value = nullInt64From(first)
+		for n, b := range batch[1:given] {
+			b.done <- coalesceResult{value: nullInt64From(first + int64(uint64(n+1)*i.inc))}
+		}
+		c.requeue(q, batch[given:])
+	}
+	c.handoff(k)
+
+	if err != nil {
+		return nullInt64(), err
+	}
+	return value, nil
+}
+
+// size returns the maximum steps of a batch, the batch started
+// from the initial value has to fit into the cycle
+func (c *coalescer) size(i *Incrementer, rollover uint64) uint64 {
+	size := c.max
+	if i.cycle && i.inc > 0 && rollover <= math.MaxInt64 {
+		fit := uint64(0)
+		if int64(rollover) > i.initial {
+			fit = uint64(int64(rollover)-i.initial) / i.inc
+		}
+		if fit < size {
+			size = fit
+		}
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	return size
+}
+
+// leave removes the waiting call, false if it isn't waiting anymore
+func (c *coalescer) leave(k coalesceKey, call *coalesceCall) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	q := c.queues[k]
+	if q == nil {
+		return false
+	}
+	for n, w := range q.waiting {
+		if w == call {
+			q.waiting = append(q.waiting[:n], q.waiting[n+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// requeue puts back the calls to the front of the queue,
+// the lock has to be held
+func (c *coalescer) requeue(q *coalesceQueue, calls []*coalesceCall) {
+	if len(calls) == 0 {
+		return
+	}
+	q.waiting = append(append([]*coalesceCall(nil), calls...), q.waiting...)
+}
+
+// handoff passes the lead to the first waiting call or removes
+// the empty queue, the lock has to be held
+func (c *coalescer) handoff(k coalesceKey) {
+	q := c.queues[k]
+	if len(q.waiting) == 0 {
+		q.leading = false
+		delete(c.queues, k)
+		return
+	}
+	next := q.waiting[0]
+	q.waiting = q.waiting[1:]
+	next.done <- coalesceResult{lead: true}
+}
@@ -0,0 +1,229 @@
+package incrmntr
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// batchBackend is an in-memory Backend recording the deltas of
+// the adds, every add waits for the gate if it's set
+type batchBackend struct {
+	mu     sync.Mutex
+	values map[string]int64
+	deltas []uint64
+	gate   chan struct{}
+}
+
+func newBatchBackend() *batchBackend {
+	return &batchBackend{values: make(map[string]int64)}
+}
+
+func (b *batchBackend) Name() string { return "batch" }
+
+func (b *batchBackend) Get(ctx context.Context, key string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	value, ok := b.values[key]
+	if !ok {
+		return 0, ErrKeyNotFound
+	}
+	return value, nil
+}
+
+func (b *batchBackend) Init(ctx context.Context, key string, value int64, expiry time.Duration) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.values[key]; ok {
+		return false, nil
+	}
+	b.values[key] = value
+	return true, nil
+}
+
+func (b *batchBackend) Add(ctx context.Context, key string, op AddOp) (int64, bool, error) {
+	if b.gate != nil {
+		select {
+		case <-b.gate:
+		case <-ctx.Done():
+			return 0, false, ctx.Err()
+		}
+	}
+	time.Sleep(time.Millisecond)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.deltas = append(b.deltas, op.Delta)
+	if err := op.Crossing(b.values[key]); err != nil {
+		return 0, false, err
+	}
+	value, rolled := op.Apply(b.values[key])
+	b.values[key] = value
+	return value, rolled, nil
+}
+
+func (b *batchBackend) Set(ctx context.Context, key string, value int64, expiry time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.values[key] = value
+	return nil
+}
+
+func (b *batchBackend) Close() error { return nil }
+
+// addConcurrently runs the adds from the workers and returns the values
+func addConcurrently(t *testing.T, inc *Incrementer, workers int, adds int) []int64 {
+	var mu sync.Mutex
+	var values []int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < adds; k++ {
+				v, err := inc.AddSafe("key")
+				if err != nil || !v.Valid {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				values = append(values, v.Value)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	sort.Slice(values, func(a, b int) bool { return values[a] < values[b] })
+
+	return values
+}
+
+func TestCoalescing(t *testing.T) {
+	backend := newBatchBackend()
+	inc, _ := NewWithBackend(backend, 0, 1, 2, false)
+	i := inc.(*Incrementer)
+	i.SetCoalescing(16)
+
+	// ---- every value is given once, without gaps, by fewer adds
+	const workers, adds = 50, 10
+	values := addConcurrently(t, i, workers, adds)
+	if len(values) != workers*adds {
+		t.Fatalf("should have %d values, instead of %d", workers*adds, len(values))
+	}
+	for k, v := range values {
+		if v != int64(1+2*k) {
+			t.Fatalf("value %d should be %d, instead of %d", k, 1+2*k, v)
+		}
+	}
+	if len(backend.deltas) >= workers*adds-1 {
+		t.Errorf("adds should be batched, instead of %d adds", len(backend.deltas))
+	}
+	for _, delta := range backend.deltas {
+		if delta > 2*16 {
+			t.Errorf("batch should be at most 16 steps, instead of %d", delta/2)
+		}
+	}
+	if len(i.coalescer.queues) != 0 {
+		t.Errorf("queues should be removed, instead of %d", len(i.coalescer.queues))
+	}
+}
+
+func TestCoalescingRollover(t *testing.T) {
+	backend := newBatchBackend()
+	inc, _ := NewWithBackend(backend, 10, 1, 1, true)
+	i := inc.(*Incrementer)
+	i.SetCoalescing(64)
+
+	// ---- the batches fit into the cycle and never exceed the rollover
+	values := addConcurrently(t, i, 30, 10)
+	for _, delta := range backend.deltas {
+		if delta > 9 {
+			t.Fatalf("batch should fit into the cycle, instead of %d steps", delta)
+		}
+	}
+	counts := make(map[int64]int)
+	for _, v := range values {
+		if v < 1 || v > 10 {
+			t.Fatalf("value should be between 1 and 10, instead of %d", v)
+		}
+		counts[v]++
+	}
+
+	// ---- the batches crossing the rollover give the values up to it,
+	// so every value is given once in every cycle, none is skipped
+	for v := int64(1); v <= 10; v++ {
+		if counts[v] != 30 {
+			t.Errorf("value %d should be given 30 times, instead of %d", v, counts[v])
+		}
+	}
+}
+
+func TestCoalescingLeaderDone(t *testing.T) {
+	backend := newBatchBackend()
+	_ = backend.Set(context.Background(), "key", 10, 0)
+	backend.gate = make(chan struct{})
+	inc, _ := NewWithBackend(backend, 0, 1, 1, false)
+	i := inc.(*Incrementer)
+	i.SetCoalescing(16)
+
+	// ---- the leader waits in the add, the others in the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := i.AddSafeContext(ctx, "key")
+		leader <- err
+	}()
+	waitFor(t, func() bool {
+		i.coalescer.mu.Lock()
+		defer i.coalescer.mu.Unlock()
+		return len(i.coalescer.queues) == 1
+	})
+	values := make(chan int64, 2)
+	for w := 0; w < 2; w++ {
+		go func() {
+			v, err := i.AddSafe("key")
+			if err != nil {
+				t.Error(err)
+			}
+			values <- v.Value
+		}()
+	}
+	waitFor(t, func() bool {
+		i.coalescer.mu.Lock()
+		defer i.coalescer.mu.Unlock()
+		return len(i.coalescer.queues[coalesceKey{key: "key"}].waiting) == 2
+	})
+
+	// ---- the waiting calls are batched after the leader gave up
+	cancel()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Fatalf("leader should be canceled, instead of %v", err)
+	}
+	close(backend.gate)
+	a, b := <-values, <-values
+	if a+b != 11+12 || a == b {
+		t.Errorf("values should be 11 and 12, instead of %d and %d", a, b)
+	}
+	if len(backend.deltas) != 1 || backend.deltas[0] != 2 {
+		t.Errorf("waiting calls should be one batch, instead of %v", backend.deltas)
+	}
+}
+
+// waitFor polls the condition for a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for k := 0; k < 1000; k++ {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("condition not met")
+}
@@ -0,0 +1,76 @@
+package incrmntr
+
+import "time"
+
+// EventKind is the kind of the internal events of the Incrementer
+type EventKind int
+
+const (
+	// EventRetry emitted before AddSafe retries a failed add
+	EventRetry EventKind = iota + 1
+
+	// EventLockWait emitted after the lock of the key acquired or failed,
+	// Duration is the time spent waiting and Err the lock error
+	EventLockWait
+
+	// EventRollover emitted when the key cycled back to the initial value
+	EventRollover
+
+	// EventKeyCreated emitted when a missing key initialized
+	EventKeyCreated
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventRetry:
+		return "retry"
+	case EventLockWait:
+		return "lock_wait"
+	case EventRollover:
+		return "rollover"
+	case EventKeyCreated:
+		return "key_created"
+	}
+
+	return "unknown"
+}
+
+// Event is an internal event of the Incrementer, which isn't
+// visible from the results of the public methods
+type Event struct {
+	Kind     EventKind
+	Key      string
+	Attempt  int
+	Duration time.Duration
+	Err      error
+}
+
+// Observer receives the events of the Incrementer, Observe is
+// called synchronously so it has to return fast
+type Observer interface {
+	Observe(e Event)
+}
+
+// SetObserver sets the receiver of the events, the observers
+// added before are removed
+func (i *Incrementer) SetObserver(observer Observer) {
+	i.observers = nil
+	i.AddObserver(observer)
+}
+
+// AddObserver adds a receiver of the events, every observer
+// gets the events in the order they were added
+func (i *Incrementer) AddObserver(observer Observer) {
+	if observer == nil {
+		return
+	}
+	i.observers = append(i.observers, observer)
+}
+
+// emit sends the event to the observers and logs it
+func (i *Incrementer) emit(e Event) {
+	i.logEvent(e)
+	for _, observer := range i.observers {
+		observer.Observe(e)
+	}
+}
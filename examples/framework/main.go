@@ -6,7 +6,7 @@ import (
 	"sync"
 	"time"
 
-	"github.com/PumpkinSeed/incrmntr/framework"
+	"github.com/PumpkinSeed/incrmntr/v2/framework"
 )
 
 var load = 20
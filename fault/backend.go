@@ -0,0 +1,329 @@
+// Package fault wraps a Backend of the incrementer with injected faults,
+// to test the retries of AddSafe and of the callers: latency, errors by
+// operation, replies lost after a successful write and keys locked until
+// they are unlocked. The faults are decided by a seeded random source, so
+// a sequence of calls fails the same way in every run.
+package fault
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+)
+
+// ErrInjected is the injected error of the rules without error
+var ErrInjected = errors.New("fault: injected error")
+
+// ErrReplyLost returned when the operation was done by the wrapped
+// backend but its reply was dropped, so the outcome is ambiguous
+var ErrReplyLost = errors.New("fault: reply lost after the operation")
+
+// Op is an operation of the Backend
+type Op string
+
+// Operations of the Backend, OpAll matches all of them
+const (
+	OpGet  Op = "Get"
+	OpInit Op = "Init"
+	OpAdd  Op = "Add"
+	OpSet  Op = "Set"
+	OpAll  Op = "*"
+
+	OpAddIdempotent Op = "AddIdempotent"
+)
+
+// rule is the faults of an operation
+type rule struct {
+	minLatency time.Duration
+	maxLatency time.Duration
+	errorRate  float64
+	err        error
+	dropRate   float64
+}
+
+// Option adds a fault to the Backend
+type Option func(b *Backend)
+
+// WithLatency delays the operation by a random duration between
+// min and max, the delay is cut by the context of the operation
+func WithLatency(op Op, min time.Duration, max time.Duration) Option {
+	return func(b *Backend) {
+		r := b.rule(op)
+		r.minLatency, r.maxLatency = min, max
+	}
+}
+
+// WithErrors fails the rate (0-1) of the operations with the error
+// before they reach the wrapped backend, ErrInjected if the error is nil
+func WithErrors(op Op, rate float64, err error) Option {
+	return func(b *Backend) {
+		if err == nil {
+			err = ErrInjected
+		}
+		r := b.rule(op)
+		r.errorRate, r.err = rate, err
+	}
+}
+
+// WithLostReplies drops the reply of the rate (0-1) of the operations
+// done by the wrapped backend, they return ErrReplyLost
+func WithLostReplies(op Op, rate float64) Option {
+	return func(b *Backend) {
+		b.rule(op).dropRate = rate
+	}
+}
+
+// Counts are the number of the injected faults by operation
+type Counts struct {
+	Delayed map[Op]int
+	Errors  map[Op]int
+	Lost    map[Op]int
+
+	// Locked is the number of the writes rejected on the locked keys
+	Locked map[Op]int
+}
+
+// Backend implements incrmntr.Backend with the faults on the wrapped one
+type Backend struct {
+	next incrmntr.Backend
+
+	mu     sync.Mutex
+	rnd    *rand.Rand
+	rules  map[Op]*rule
+	locked map[string]error
+	counts Counts
+}
+
+// Wrap wraps the backend with the faults, the seed sets the random
+// source deciding the faults
+func Wrap(next incrmntr.Backend, seed int64, opts ...Option) *Backend {
+	b := &Backend{
+		next:   next,
+		rnd:    rand.New(rand.NewSource(seed)),
+		rules:  make(map[Op]*rule),
+		locked: make(map[string]error),
+		counts: Counts{
+			Delayed: make(map[Op]int),
+			Errors:  make(map[Op]int),
+			Lost:    make(map[Op]int),
+			Locked:  make(map[Op]int),
+		},
+	}
+	b.Inject(opts...)
+
+	return b
+}
+
+// Inject adds the faults to the running backend
+func (b *Backend) Inject(opts ...Option) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, opt := range opts {
+		opt(b)
+	}
+}
+
+// Clear removes the faults and unlocks the keys, e.g. at the end of a storm
+func (b *Backend) Clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.rules = make(map[Op]*rule)
+	b.locked = make(map[string]error)
+}
+
+// Lock holds the lock of the key until Unlock, the adds and the sets of the
+// key fail with the error, incrmntr.ErrConflict if it's nil, so AddSafe
+// retries them until its context is done
+func (b *Backend) Lock(key string, err error) {
+	if err == nil {
+		err = incrmntr.ErrConflict
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.locked[key] = err
+}
+
+// Unlock releases the lock of the key
+func (b *Backend) Unlock(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.locked, key)
+}
+
+// Counts returns the copy of the fault counts
+func (b *Backend) Counts() Counts {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return Counts{
+		Delayed: copyCounts(b.counts.Delayed),
+		Errors:  copyCounts(b.counts.Errors),
+		Lost:    copyCounts(b.counts.Lost),
+		Locked:  copyCounts(b.counts.Locked),
+	}
+}
+
+// Name is the name of the wrapped backend
+func (b *Backend) Name() string {
+	return b.next.Name()
+}
+
+// Get returns the value of the key
+func (b *Backend) Get(ctx context.Context, key string) (value int64, err error) {
+	err = b.do(ctx, OpGet, "", func() error {
+		value, err = b.next.Get(ctx, key)
+		return err
+	})
+	return value, err
+}
+
+// Init creates the key if it doesn't exist
+func (b *Backend) Init(ctx context.Context, key string, value int64, expiry time.Duration) (created bool, err error) {
+	err = b.do(ctx, OpInit, "", func() error {
+		created, err = b.next.Init(ctx, key, value, expiry)
+		return err
+	})
+	return created, err
+}
+
+// Add increments the key
+func (b *Backend) Add(ctx context.Context, key string, op incrmntr.AddOp) (value int64, rolled bool, err error) {
+	err = b.do(ctx, OpAdd, key, func() error {
+		value, rolled, err = b.next.Add(ctx, key, op)
+		return err
+	})
+	return value, rolled, err
+}
+
+// AddIdempotent increments the key once per request, it returns
+// incrmntr.ErrNotIdempotent if the wrapped backend doesn't support it
+func (b *Backend) AddIdempotent(ctx context.Context, key string, requestID string, op incrmntr.AddOp, ttl time.Duration) (res incrmntr.IdempotentResult, err error) {
+	next, ok := b.next.(incrmntr.IdempotentBackend)
+	if !ok {
+		return res, incrmntr.ErrNotIdempotent
+	}
+	err = b.do(ctx, OpAddIdempotent, key, func() error {
+		res, err = next.AddIdempotent(ctx, key, requestID, op, ttl)
+		return err
+	})
+	return res, err
+}
+
+// Set overwrites the value of the key
+func (b *Backend) Set(ctx context.Context, key string, value int64, expiry time.Duration) error {
+	return b.do(ctx, OpSet, key, func() error {
+		return b.next.Set(ctx, key, value, expiry)
+	})
+}
+
+// Close closes the wrapped backend
+func (b *Backend) Close() error {
+	return b.next.Close()
+}
+
+// do runs the operation with the faults, the locked key is
+// checked for the writes only
+func (b *Backend) do(ctx context.Context, op Op, lockKey string, fn func() error) error {
+	// ---- decide all the faults of the call at once, so the
+	// sequence of the random numbers depends on the calls only
+	b.mu.Lock()
+	r := b.merged(op)
+	var delay time.Duration
+	if r.maxLatency > 0 {
+		delay = r.minLatency
+		if r.maxLatency > r.minLatency {
+			delay += time.Duration(b.rnd.Int63n(int64(r.maxLatency - r.minLatency)))
+		}
+	}
+	fail := r.errorRate > 0 && b.rnd.Float64() < r.errorRate
+	lose := r.dropRate > 0 && b.rnd.Float64() < r.dropRate
+	lockErr, locked := b.locked[lockKey]
+	locked = locked && lockKey != ""
+	if delay > 0 {
+		b.counts.Delayed[op]++
+	}
+	b.mu.Unlock()
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	b.mu.Lock()
+	switch {
+	case locked:
+		b.counts.Locked[op]++
+	case fail:
+		b.counts.Errors[op]++
+	}
+	b.mu.Unlock()
+	if locked {
+		return lockErr
+	}
+	if fail {
+		return r.err
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+	if lose {
+		b.mu.Lock()
+		b.counts.Lost[op]++
+		b.mu.Unlock()
+		return ErrReplyLost
+	}
+
+	return nil
+}
+
+// rule returns the rule of the operation, it's created if it's missing
+func (b *Backend) rule(op Op) *rule {
+	r, ok := b.rules[op]
+	if !ok {
+		r = &rule{}
+		b.rules[op] = r
+	}
+	return r
+}
+
+// merged returns the rule of the operation completed by the rule of OpAll
+func (b *Backend) merged(op Op) rule {
+	var r rule
+	if all, ok := b.rules[OpAll]; ok {
+		r = *all
+	}
+	if own, ok := b.rules[op]; ok {
+		if own.maxLatency > 0 {
+			r.minLatency, r.maxLatency = own.minLatency, own.maxLatency
+		}
+		if own.errorRate > 0 {
+			r.errorRate, r.err = own.errorRate, own.err
+		}
+		if own.dropRate > 0 {
+			r.dropRate = own.dropRate
+		}
+	}
+	return r
+}
+
+func copyCounts(counts map[Op]int) map[Op]int {
+	c := make(map[Op]int, len(counts))
+	for op, n := range counts {
+		c[op] = n
+	}
+	return c
+}
@@ -0,0 +1,191 @@
+package fault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+	"github.com/PumpkinSeed/incrmntr/v2/incrmntrtest"
+	"github.com/couchbase/gocb/v2"
+)
+
+func newTestIncrementer(t *testing.T, b *Backend) *incrmntr.Incrementer {
+	inc, err := incrmntr.NewWithBackend(b, 0, 1, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inc.(*incrmntr.Incrementer)
+}
+
+func TestSeedIsDeterministic(t *testing.T) {
+	run := func(seed int64) string {
+		b := Wrap(incrmntrtest.NewMemory(), seed, WithErrors(OpAll, 0.3, nil))
+		var outcome []bool
+		for k := 0; k < 50; k++ {
+			err := b.Set(context.Background(), "key", int64(k), 0)
+			outcome = append(outcome, err == nil)
+		}
+		return fmt.Sprint(outcome)
+	}
+
+	if run(42) != run(42) {
+		t.Error("the same seed should inject the same faults")
+	}
+	if run(42) == run(43) {
+		t.Error("other seeds should inject other faults")
+	}
+}
+
+func TestTemporaryFailureStorm(t *testing.T) {
+	b := Wrap(incrmntrtest.NewMemory(), 1,
+		WithErrors(OpInit, 0.5, gocb.ErrTemporaryFailure),
+		WithErrors(OpAdd, 0.5, gocb.ErrTemporaryFailure),
+	)
+	inc := newTestIncrementer(t, b)
+	defer inc.Close()
+
+	// ---- the failures are injected before the write, so the retries
+	// of AddSafe don't skip values
+	for e := int64(1); e <= 50; e++ {
+		v, err := inc.AddSafe("storm")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Value != e {
+			t.Fatalf("value should be %d, instead of %d", e, v.Value)
+		}
+	}
+	if counts := b.Counts(); counts.Errors[OpAdd] == 0 {
+		t.Errorf("errors should be injected, instead of %v", counts.Errors)
+	}
+
+	// ---- Add doesn't retry
+	var failed bool
+	for k := 0; k < 20 && !failed; k++ {
+		_, err := inc.Add("storm")
+		failed = errors.Is(err, gocb.ErrTemporaryFailure)
+	}
+	if !failed {
+		t.Error("Add should return the injected error")
+	}
+}
+
+func TestLostReply(t *testing.T) {
+	memory := incrmntrtest.NewMemory()
+	b := Wrap(memory, 1)
+	inc := newTestIncrementer(t, b)
+	defer inc.Close()
+
+	if _, err := inc.AddSafe("lost"); err != nil {
+		t.Fatal(err)
+	}
+
+	// ---- the add is done, only its reply is lost
+	b.Inject(WithLostReplies(OpAdd, 1))
+	if _, err := inc.AddSafe("lost"); !errors.Is(err, ErrReplyLost) {
+		t.Fatalf("error should be ErrReplyLost, instead of %v", err)
+	}
+	if v, _ := memory.Get(context.Background(), "lost"); v != 2 {
+		t.Errorf("value should be 2, instead of %d", v)
+	}
+	if counts := b.Counts(); counts.Lost[OpAdd] != 1 {
+		t.Errorf("one reply should be lost, instead of %d", counts.Lost[OpAdd])
+	}
+
+	b.Clear()
+	if v, err := inc.AddSafe("lost"); err != nil || v.Value != 3 {
+		t.Errorf("value should be 3, instead of %d (%v)", v.Value, err)
+	}
+}
+
+func TestLostReplyIdempotent(t *testing.T) {
+	memory := incrmntrtest.NewMemory()
+	b := Wrap(memory, 1, WithLostReplies(OpAddIdempotent, 1))
+	inc := newTestIncrementer(t, b)
+	defer inc.Close()
+
+	// ---- the retry of the request returns the value of the lost reply
+	if _, err := inc.AddIdempotent("lost", "request"); !errors.Is(err, ErrReplyLost) {
+		t.Fatalf("error should be ErrReplyLost, instead of %v", err)
+	}
+	b.Clear()
+	if v, err := inc.AddIdempotent("lost", "request"); err != nil || v.Value != 1 {
+		t.Fatalf("retry should be 1, instead of %d (%v)", v.Value, err)
+	}
+	if v, err := inc.AddIdempotent("lost", "other"); err != nil || v.Value != 2 {
+		t.Errorf("other request should be 2, instead of %d (%v)", v.Value, err)
+	}
+
+	// ---- the wrapped backend without idempotent adds
+	inc = newTestIncrementer(t, Wrap(onlyBackend{memory}, 1))
+	if _, err := inc.AddIdempotent("lost", "request"); !errors.Is(err, incrmntr.ErrNotIdempotent) {
+		t.Errorf("error should be ErrNotIdempotent, instead of %v", err)
+	}
+}
+
+// onlyBackend hides the optional methods of the backend
+type onlyBackend struct {
+	incrmntr.Backend
+}
+
+func TestLatency(t *testing.T) {
+	b := Wrap(incrmntrtest.NewMemory(), 1, WithLatency(OpAll, 50*time.Millisecond, 100*time.Millisecond))
+	inc := newTestIncrementer(t, b)
+	defer inc.Close()
+	inc.SetTimeout(10 * time.Millisecond)
+
+	// ---- the delay is cut by the timeout of the incrementer
+	start := time.Now()
+	if _, err := inc.AddSafe("slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error should be deadline exceeded, instead of %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("call should return at the timeout, instead of %v", elapsed)
+	}
+
+	inc.SetTimeout(time.Second)
+	if _, err := inc.AddSafe("slow"); err != nil {
+		t.Error(err)
+	}
+	if counts := b.Counts(); counts.Delayed[OpInit] != 2 {
+		t.Errorf("two inits should be delayed, instead of %d", counts.Delayed[OpInit])
+	}
+}
+
+func TestLockHeldForever(t *testing.T) {
+	b := Wrap(incrmntrtest.NewMemory(), 1)
+	inc := newTestIncrementer(t, b)
+	defer inc.Close()
+
+	if _, err := inc.AddSafe("locked"); err != nil {
+		t.Fatal(err)
+	}
+	b.Lock("locked", nil)
+
+	// ---- AddSafe retries until its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := inc.AddSafeContext(ctx, "locked"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error should be deadline exceeded, instead of %v", err)
+	}
+	if counts := b.Counts(); counts.Locked[OpAdd] < 2 {
+		t.Errorf("adds should be retried on the locked key, instead of %d", counts.Locked[OpAdd])
+	}
+	if v, err := inc.Get("locked"); err != nil || v != 1 {
+		t.Errorf("the locked key should be readable, instead of %d (%v)", v, err)
+	}
+
+	b.Unlock("locked")
+	if v, err := inc.AddSafe("locked"); err != nil || v.Value != 2 {
+		t.Errorf("value should be 2 after unlock, instead of %d (%v)", v.Value, err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	incrmntrtest.Run(t, func(t *testing.T) (incrmntr.Backend, func()) {
+		return Wrap(incrmntrtest.NewMemory(), 1), func() {}
+	})
+}
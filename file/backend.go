@@ -0,0 +1,641 @@
+// Package file is the Backend of the Incrementer persisting the counters
+// to a local append-only log, for the single-node deployments without
+// database server.
+//
+// Every write appends a checksummed record to the log, the log replayed
+// on Open and a torn or corrupted tail (e.g. after a crash during a write)
+// truncated to the last complete record. A failed write is truncated the
+// same way, if the truncate fails too the backend fails every later call.
+// The log is compacted to the live keys when it grows past the threshold,
+// a failed compaction is retried after the next threshold records. The file
+// can be used by a single process only, Open takes an exclusive lock of it.
+package file
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+)
+
+// SyncPolicy decides when the log is flushed to the disk
+type SyncPolicy int
+
+const (
+	// SyncAlways syncs the log before every write returns
+	SyncAlways SyncPolicy = iota
+
+	// SyncInterval syncs the log periodically, the writes of the
+	// last interval can be lost on power failure
+	SyncInterval
+
+	// SyncNever leaves the syncing to the operating system
+	SyncNever
+)
+
+// headerSize is the length and the checksum of the record
+const headerSize = 8
+
+// record operations
+const (
+	opSet   byte = 1
+	opBatch byte = 2
+)
+
+// Option configures the Backend
+type Option func(b *Backend)
+
+// WithSync sets the sync policy, SyncAlways by default,
+// the interval is used by SyncInterval only
+func WithSync(policy SyncPolicy, interval time.Duration) Option {
+	return func(b *Backend) {
+		b.policy = policy
+		if interval > 0 {
+			b.interval = interval
+		}
+	}
+}
+
+// WithCompactThreshold sets the number of the records in the log, which
+// triggers the compaction if most of them are stale, 10000 by default
+func WithCompactThreshold(records int) Option {
+	return func(b *Backend) {
+		if records > 0 {
+			b.threshold = records
+		}
+	}
+}
+
+// Backend implements incrmntr.Backend with a local file
+type Backend struct {
+	path      string
+	policy    SyncPolicy
+	interval  time.Duration
+	threshold int
+	now       func() time.Time
+
+	mu      sync.Mutex
+	lock    *os.File
+	f       *os.File
+	w       *bufio.Writer
+	offset  int64
+	values  map[string]entry
+	records int
+	dirty   bool
+	closed  bool
+
+	// failed is the error of the log, which can't be written anymore
+	failed error
+	// compactAt is the number of the records triggering the next compaction
+	compactAt  int
+	compactErr error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type entry struct {
+	value   int64
+	expires time.Time
+}
+
+// change is a key written by a record
+type change struct {
+	key string
+	e   entry
+}
+
+// Open opens or creates the log, the complete records replayed
+// and the torn tail truncated
+func Open(path string, opts ...Option) (*Backend, error) {
+	b := &Backend{
+		path:      path,
+		policy:    SyncAlways,
+		interval:  time.Second,
+		threshold: 10000,
+		now:       time.Now,
+		values:    make(map[string]entry),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.compactAt = b.threshold
+
+	// ---- the lock is a separate file, the log is replaced by the compaction
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	b.lock = lock
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		b.unlock()
+		return nil, err
+	}
+	valid, err := b.replay(f)
+	if err != nil {
+		f.Close()
+		b.unlock()
+		return nil, err
+	}
+
+	// ---- drop the torn tail, the next record is appended after the last valid one
+	b.f = f
+	b.w = bufio.NewWriter(f)
+	if err := b.truncate(valid); err != nil {
+		f.Close()
+		b.unlock()
+		return nil, err
+	}
+
+	if b.policy == SyncInterval {
+		go b.syncer()
+	} else {
+		close(b.done)
+	}
+
+	return b, nil
+}
+
+// replay reads the records of the log and returns the offset
+// of the end of the last valid one
+func (b *Backend) replay(f *os.File) (int64, error) {
+	r := bufio.NewReader(f)
+	var offset int64
+	header := make([]byte, headerSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return offset, nil
+		}
+		size := binary.BigEndian.Uint32(header[0:4])
+		sum := binary.BigEndian.Uint32(header[4:8])
+		if size > 1<<20 {
+			return offset, nil
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return offset, nil
+		}
+		if crc32.ChecksumIEEE(payload) != sum {
+			return offset, nil
+		}
+		changes, ok := decode(payload)
+		if !ok {
+			return offset, nil
+		}
+
+		for _, c := range changes {
+			b.values[c.key] = c.e
+		}
+		b.records++
+		offset += int64(headerSize + size)
+	}
+}
+
+// Name is the name of the backend in the spans
+func (b *Backend) Name() string {
+	return "file"
+}
+
+// Get returns the value of the key
+func (b *Backend) Get(ctx context.Context, key string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.usable(); err != nil {
+		return 0, err
+	}
+	e, ok := b.lookup(key)
+	if !ok {
+		return 0, incrmntr.ErrKeyNotFound
+	}
+
+	return e.value, nil
+}
+
+// Init creates the key with the value if it doesn't exist
+func (b *Backend) Init(ctx context.Context, key string, value int64, expiry time.Duration) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.usable(); err != nil {
+		return false, err
+	}
+	if _, ok := b.lookup(key); ok {
+		return false, nil
+	}
+	if err := b.write(key, value, expiry); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Add increments the key, the lock of the backend makes it atomic
+func (b *Backend) Add(ctx context.Context, key string, op incrmntr.AddOp) (int64, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.usable(); err != nil {
+		return 0, false, err
+	}
+	e, ok := b.lookup(key)
+	if !ok {
+		// ---- expired after the init, AddSafe initializes it again
+		return 0, false, incrmntr.ErrConflict
+	}
+	if err := op.Crossing(e.value); err != nil {
+		return 0, false, err
+	}
+	value, rolled := op.Apply(e.value)
+	if err := b.write(key, value, op.Expiry); err != nil {
+		return 0, false, err
+	}
+
+	return value, rolled, nil
+}
+
+// AddIdempotent increments the key and records the result of the request,
+// both are written in one record, so the replay applies both or none
+func (b *Backend) AddIdempotent(ctx context.Context, key string, requestID string, op incrmntr.AddOp, ttl time.Duration) (incrmntr.IdempotentResult, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.usable(); err != nil {
+		return incrmntr.IdempotentResult{}, err
+	}
+	recordKey := incrmntr.IdempotencyKey(key, requestID)
+	if e, ok := b.lookup(recordKey); ok {
+		return incrmntr.IdempotentResult{Value: e.value, Replayed: true}, nil
+	}
+
+	var res incrmntr.IdempotentResult
+	if e, ok := b.lookup(key); ok {
+		res.Value, res.Rolled = op.Apply(e.value)
+	} else {
+		res.Value, res.Created = op.Initial, true
+	}
+	err := b.writeAll(
+		change{key: key, e: b.entry(res.Value, op.Expiry)},
+		change{key: recordKey, e: b.entry(res.Value, ttl)},
+	)
+	if err != nil {
+		return incrmntr.IdempotentResult{}, err
+	}
+
+	return res, nil
+}
+
+// Set overwrites the value of the key
+func (b *Backend) Set(ctx context.Context, key string, value int64, expiry time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.usable(); err != nil {
+		return err
+	}
+
+	return b.write(key, value, expiry)
+}
+
+// Ping returns the error of the log if it failed
+func (b *Backend) Ping(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.usable()
+}
+
+// CompactErr returns the error of the last compaction, nil if it succeeded,
+// the failed compaction doesn't fail the write triggering it
+func (b *Backend) CompactErr() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.compactErr
+}
+
+// Close syncs and closes the log
+func (b *Backend) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.mu.Unlock()
+
+	close(b.stop)
+	<-b.done
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var err error
+	if b.failed == nil {
+		err = b.flush(true)
+	}
+	if cerr := b.f.Close(); err == nil {
+		err = cerr
+	}
+	b.unlock()
+
+	return err
+}
+
+var (
+	errClosed = errors.New("error file backend is closed")
+
+	// ErrLocked returned by Open when an other process uses the file
+	ErrLocked = errors.New("error file is locked by an other process")
+
+	// ErrFailed wrapped by the errors of the backend after a write
+	// failed and the log couldn't be truncated to the last record
+	ErrFailed = errors.New("error file backend failed")
+)
+
+// usable returns the error if the backend is closed or failed
+func (b *Backend) usable() error {
+	if b.closed {
+		return errClosed
+	}
+
+	return b.failed
+}
+
+// unlock releases the lock of the file
+func (b *Backend) unlock() {
+	_ = unlockFile(b.lock)
+	_ = b.lock.Close()
+}
+
+// lookup returns the key, the expired keys are deleted from the memory
+// only, the log keeps them until the next compaction
+func (b *Backend) lookup(key string) (entry, bool) {
+	e, ok := b.values[key]
+	if ok && !e.expires.IsZero() && !b.now().Before(e.expires) {
+		delete(b.values, key)
+		return entry{}, false
+	}
+
+	return e, ok
+}
+
+// write appends the record of the key to the log and syncs it by the policy
+func (b *Backend) write(key string, value int64, expiry time.Duration) error {
+	return b.writeAll(change{key: key, e: b.entry(value, expiry)})
+}
+
+// entry is the value expiring after the expiry, none if it's 0
+func (b *Backend) entry(value int64, expiry time.Duration) entry {
+	e := entry{value: value}
+	if expiry > 0 {
+		e.expires = b.now().Add(expiry)
+	}
+
+	return e
+}
+
+// writeAll appends the changes in one record, so the replay
+// applies all or none of them, and syncs it by the policy
+func (b *Backend) writeAll(changes ...change) error {
+	payload := encode(changes)
+	header := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(payload))
+	_, err := b.w.Write(header)
+	if err == nil {
+		_, err = b.w.Write(payload)
+	}
+	if err == nil {
+		err = b.w.Flush()
+	}
+	if err != nil {
+		// ---- the record may be partially written, it's cut off
+		if terr := b.truncate(b.offset); terr != nil {
+			b.failed = fmt.Errorf("%w: %v", ErrFailed, terr)
+		}
+		return err
+	}
+	b.offset += int64(len(header) + len(payload))
+	b.records++
+	b.dirty = true
+
+	if err := b.flush(b.policy == SyncAlways); err != nil {
+		return err
+	}
+	for _, c := range changes {
+		b.values[c.key] = c.e
+	}
+
+	if b.records >= b.compactAt && b.records > 2*len(b.values) {
+		// ---- the write is done, the failed compaction is retried later
+		b.compactErr = b.compact()
+		if b.compactErr != nil {
+			b.compactAt = b.records + b.threshold
+		}
+	}
+
+	return nil
+}
+
+// flush writes the buffer to the file and syncs it if needed, the written
+// records aren't known to be on the disk after a failed sync, so the
+// backend fails
+func (b *Backend) flush(sync bool) error {
+	if err := b.w.Flush(); err != nil {
+		return err
+	}
+	if sync && b.dirty {
+		if err := b.f.Sync(); err != nil {
+			b.failed = fmt.Errorf("%w: %v", ErrFailed, err)
+			return err
+		}
+		b.dirty = false
+	}
+
+	return nil
+}
+
+// truncate cuts the log at the offset and appends the next record there
+func (b *Backend) truncate(offset int64) error {
+	if err := b.f.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := b.f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	b.w.Reset(b.f)
+	b.offset = offset
+
+	return nil
+}
+
+// compact writes the live keys to a new log and replaces the old one with
+// it, the rename is atomic so a crash leaves either the old or the new log,
+// the old log is kept if the compaction fails
+func (b *Backend) compact() error {
+	tmp := b.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+
+	header := make([]byte, headerSize)
+	records := 0
+	var offset int64
+	for key := range b.values {
+		e, ok := b.lookup(key)
+		if !ok {
+			continue
+		}
+		payload := encodeSet(key, e)
+		binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+		binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(payload))
+		w.Write(header)
+		w.Write(payload)
+		records++
+		offset += int64(headerSize + len(payload))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, b.path); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(b.path))
+
+	b.f.Close()
+	b.f = f
+	b.w = bufio.NewWriter(f)
+	b.offset = offset
+	b.records = records
+	b.dirty = false
+	b.compactAt = b.threshold
+
+	return nil
+}
+
+// syncDir syncs the directory, so the rename survives a crash
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
+
+// syncer syncs the log in every interval with SyncInterval
+func (b *Backend) syncer() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			b.mu.Lock()
+			_ = b.flush(true)
+			b.mu.Unlock()
+		}
+	}
+}
+
+// encode is the payload of the record of the changes, a single change is
+// a set, more of them are a batch: the operation and the length-prefixed
+// payloads of the sets
+func encode(changes []change) []byte {
+	if len(changes) == 1 {
+		return encodeSet(changes[0].key, changes[0].e)
+	}
+	payload := []byte{opBatch}
+	for _, c := range changes {
+		set := encodeSet(c.key, c.e)
+		payload = append(payload, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(payload[len(payload)-4:], uint32(len(set)))
+		payload = append(payload, set...)
+	}
+
+	return payload
+}
+
+// decode returns the changes of the payload
+func decode(payload []byte) ([]change, bool) {
+	if len(payload) == 0 || payload[0] != opBatch {
+		key, e, ok := decodeSet(payload)
+		return []change{{key: key, e: e}}, ok
+	}
+
+	var changes []change
+	for rest := payload[1:]; len(rest) > 0; {
+		if len(rest) < 4 {
+			return nil, false
+		}
+		size := binary.BigEndian.Uint32(rest[0:4])
+		if uint32(len(rest)-4) < size {
+			return nil, false
+		}
+		key, e, ok := decodeSet(rest[4 : 4+size])
+		if !ok {
+			return nil, false
+		}
+		changes = append(changes, change{key: key, e: e})
+		rest = rest[4+size:]
+	}
+
+	return changes, len(changes) > 0
+}
+
+// encodeSet is the payload of a set: the operation, the value,
+// the expiry in unix nanoseconds (0 if none) and the key
+func encodeSet(key string, e entry) []byte {
+	payload := make([]byte, 17+len(key))
+	payload[0] = opSet
+	binary.BigEndian.PutUint64(payload[1:9], uint64(e.value))
+	if !e.expires.IsZero() {
+		binary.BigEndian.PutUint64(payload[9:17], uint64(e.expires.UnixNano()))
+	}
+	copy(payload[17:], key)
+
+	return payload
+}
+
+func decodeSet(payload []byte) (string, entry, bool) {
+	if len(payload) < 17 || payload[0] != opSet {
+		return "", entry{}, false
+	}
+	e := entry{value: int64(binary.BigEndian.Uint64(payload[1:9]))}
+	if expires := int64(binary.BigEndian.Uint64(payload[9:17])); expires != 0 {
+		e.expires = time.Unix(0, expires)
+	}
+
+	return string(payload[17:]), e, true
+}
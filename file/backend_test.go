@@ -0,0 +1,394 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+	"github.com/PumpkinSeed/incrmntr/v2/incrmntrtest"
+)
+
+func tempPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "incrmntr-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "counters.log"), func() { os.RemoveAll(dir) }
+}
+
+func TestBackendAdd(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	b, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inc, _ := incrmntr.NewWithBackend(b, 3, 1, 1, true)
+	defer inc.Close()
+
+	for k, e := range []int64{1, 2, 3, 1, 2} {
+		v, err := inc.AddSafe("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Value != e {
+			t.Errorf("AddSafe %d should return %d, instead of %d", k, e, v.Value)
+		}
+	}
+	if _, err := inc.Get("missing"); !errors.Is(err, incrmntr.ErrKeyNotFound) {
+		t.Errorf("Get of missing key should fail with ErrKeyNotFound, instead of %v", err)
+	}
+}
+
+func TestBackendRestart(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	b, err := Open(path, WithSync(SyncInterval, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inc, _ := incrmntr.NewWithBackend(b, 999, 1, 1, true)
+	for k := 0; k < 5; k++ {
+		inc.AddSafe("orders")
+	}
+	inc.Set("tickets", 100)
+	if err := inc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if v, _ := b.Get(context.Background(), "orders"); v != 5 {
+		t.Errorf("Orders should be 5 after restart, instead of %d", v)
+	}
+	if v, _ := b.Get(context.Background(), "tickets"); v != 100 {
+		t.Errorf("Tickets should be 100 after restart, instead of %d", v)
+	}
+}
+
+func TestBackendTornWrite(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	b, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Set(context.Background(), "key", 1, 0)
+	b.Set(context.Background(), "key", 2, 0)
+	b.Close()
+
+	// ---- cut the last record in half, as if the process died during the write
+	info, _ := os.Stat(path)
+	recordSize := info.Size() / 2
+	if err := os.Truncate(path, info.Size()-recordSize/2); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := b.Get(context.Background(), "key"); v != 1 {
+		t.Errorf("Value should be the last complete record 1, instead of %d", v)
+	}
+	if info, _ := os.Stat(path); info.Size() != recordSize {
+		t.Errorf("Torn tail should be truncated to %d, instead of %d", recordSize, info.Size())
+	}
+
+	// ---- the next write appended after the last complete record
+	b.Set(context.Background(), "key", 3, 0)
+	b.Close()
+	b, _ = Open(path)
+	defer b.Close()
+	if v, _ := b.Get(context.Background(), "key"); v != 3 {
+		t.Errorf("Value should be 3 after the recovery, instead of %d", v)
+	}
+}
+
+func TestBackendIdempotentTornWrite(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	op := incrmntr.AddOp{Delta: 1, Initial: 1}
+	b, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.AddIdempotent(ctx, "key", "r1", op, time.Hour)
+	info, _ := os.Stat(path)
+	b.AddIdempotent(ctx, "key", "r2", op, time.Hour)
+	b.Close()
+
+	// ---- the add and the request are replayed together
+	b, _ = Open(path)
+	if res, err := b.AddIdempotent(ctx, "key", "r2", op, time.Hour); err != nil || !res.Replayed || res.Value != 2 {
+		t.Errorf("r2 should be replayed with 2, instead of %+v (%v)", res, err)
+	}
+	b.Close()
+
+	// ---- cut the record of r2, neither the add nor the request is kept
+	if err := os.Truncate(path, info.Size()+3); err != nil {
+		t.Fatal(err)
+	}
+	b, _ = Open(path)
+	defer b.Close()
+	if v, _ := b.Get(ctx, "key"); v != 1 {
+		t.Errorf("Value should be 1 without the torn add, instead of %d", v)
+	}
+	if res, err := b.AddIdempotent(ctx, "key", "r2", op, time.Hour); err != nil || res.Replayed || res.Value != 2 {
+		t.Errorf("r2 should be added again with 2, instead of %+v (%v)", res, err)
+	}
+}
+
+func TestBackendCorruptedRecord(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	b, _ := Open(path)
+	b.Set(context.Background(), "key", 1, 0)
+	b.Set(context.Background(), "key", 2, 0)
+	b.Close()
+
+	// ---- flip the last byte of the last record, its checksum fails
+	data, _ := ioutil.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	ioutil.WriteFile(path, data, 0644)
+
+	b, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if v, _ := b.Get(context.Background(), "key"); v != 1 {
+		t.Errorf("Value should be the last valid record 1, instead of %d", v)
+	}
+}
+
+func TestBackendCompaction(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	b, err := Open(path, WithCompactThreshold(100), WithSync(SyncNever, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inc, _ := incrmntr.NewWithBackend(b, 1000000, 1, 1, true)
+	for k := 0; k < 250; k++ {
+		inc.AddSafe("a")
+		inc.AddSafe("b")
+	}
+	inc.Close()
+
+	if b.records >= 100 {
+		t.Errorf("Log should be compacted below 100 records, instead of %d", b.records)
+	}
+	b, _ = Open(path)
+	defer b.Close()
+	if v, _ := b.Get(context.Background(), "a"); v != 250 {
+		t.Errorf("Value of a should be 250 after compaction, instead of %d", v)
+	}
+	if v, _ := b.Get(context.Background(), "b"); v != 250 {
+		t.Errorf("Value of b should be 250 after compaction, instead of %d", v)
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Error("Temporary compaction file should be renamed")
+	}
+}
+
+func TestBackendExpiry(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	b, _ := Open(path)
+	defer b.Close()
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	b.Set(context.Background(), "session", 7, time.Minute)
+	if v, err := b.Get(context.Background(), "session"); err != nil || v != 7 {
+		t.Errorf("Session should be 7 before expiry, instead of %d, %v", v, err)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := b.Get(context.Background(), "session"); !errors.Is(err, incrmntr.ErrKeyNotFound) {
+		t.Errorf("Session should be expired, instead of %v", err)
+	}
+}
+
+func TestBackendConcurrentAddSafe(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	b, _ := Open(path, WithSync(SyncNever, 0))
+	inc, _ := incrmntr.NewWithBackend(b, 1000000, 1, 1, true)
+	defer inc.Close()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 100; k++ {
+				if _, err := inc.AddSafe("key"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, _ := inc.Get("key"); v != 800 {
+		t.Errorf("Value should be 800, instead of %d", v)
+	}
+}
+
+// partialWriter writes the first n bytes to the file and fails
+type partialWriter struct {
+	f *os.File
+	n int
+}
+
+func (w *partialWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		p = p[:w.n]
+	}
+	n, _ := w.f.Write(p)
+	w.n -= n
+	return n, errors.New("disk full")
+}
+
+func TestBackendFailedWrite(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	b, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	b.Set(ctx, "a", 1, 0)
+
+	// ---- the partial record is cut off, the next one follows the last valid one
+	b.w = bufio.NewWriter(&partialWriter{f: b.f, n: 3})
+	if err := b.Set(ctx, "b", 2, 0); err == nil {
+		t.Fatal("Set should fail")
+	}
+	if err := b.Set(ctx, "c", 3, 0); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	b, _ = Open(path)
+	defer b.Close()
+	if v, err := b.Get(ctx, "c"); err != nil || v != 3 {
+		t.Errorf("Value of c should be 3 after the failed write, instead of %d, %v", v, err)
+	}
+	if _, err := b.Get(ctx, "b"); !errors.Is(err, incrmntr.ErrKeyNotFound) {
+		t.Errorf("Failed write of b shouldn't be stored, instead of %v", err)
+	}
+}
+
+func TestBackendFailed(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	b, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// ---- the log can't be truncated, every later call fails
+	b.f.Close()
+	if err := b.Set(ctx, "a", 1, 0); err == nil {
+		t.Fatal("Set should fail")
+	}
+	if _, err := b.Get(ctx, "a"); !errors.Is(err, ErrFailed) {
+		t.Errorf("Get should fail with ErrFailed, instead of %v", err)
+	}
+	if err := b.Ping(ctx); !errors.Is(err, ErrFailed) {
+		t.Errorf("Ping should fail with ErrFailed, instead of %v", err)
+	}
+	b.Close()
+}
+
+func TestBackendCompactionFailed(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	// ---- the directory in the place of the new log fails the compaction
+	if err := os.Mkdir(path+".compact", 0755); err != nil {
+		t.Fatal(err)
+	}
+	b, err := Open(path, WithCompactThreshold(10), WithSync(SyncNever, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := int64(1); k <= 30; k++ {
+		if err := b.Set(context.Background(), "a", k, 0); err != nil {
+			t.Fatalf("Set shouldn't fail on the compaction, instead of %v", err)
+		}
+	}
+	if b.CompactErr() == nil || b.records != 30 {
+		t.Errorf("Compaction should fail and keep 30 records, instead of %v and %d", b.CompactErr(), b.records)
+	}
+
+	// ---- it's retried after the next threshold records
+	os.Remove(path + ".compact")
+	for k := 0; k < 10; k++ {
+		b.Set(context.Background(), "a", 40, 0)
+	}
+	if b.CompactErr() != nil || b.records >= 10 {
+		t.Errorf("Compaction should be retried, instead of %v and %d records", b.CompactErr(), b.records)
+	}
+	b.Close()
+}
+
+func TestBackendLocked(t *testing.T) {
+	if !locks {
+		t.Skip("file isn't locked on the platform")
+	}
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	b, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); !errors.Is(err, ErrLocked) {
+		t.Errorf("Second Open should fail with ErrLocked, instead of %v", err)
+	}
+	b.Close()
+
+	b, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open after Close should succeed, instead of %v", err)
+	}
+	b.Close()
+}
+
+func TestConformance(t *testing.T) {
+	incrmntrtest.Run(t, func(t *testing.T) (incrmntr.Backend, func()) {
+		path, remove := tempPath(t)
+		b, err := Open(path)
+		if err != nil {
+			remove()
+			t.Fatal(err)
+		}
+		return b, remove
+	})
+}
@@ -1,11 +1,12 @@
 package framework
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"time"
 
-	"github.com/PumpkinSeed/incrmntr"
+	"github.com/PumpkinSeed/incrmntr/v2"
 	"github.com/couchbase/gocb/v2"
 )
 
@@ -14,6 +15,11 @@ type Counter interface {
 	Init(config []byte) error
 	NextVal(key string) (int64, error)
 	NextValWithRollover(key string, rollover uint64) (int64, error)
+	Get(key string) (int64, error)
+	Set(key string, value int64) error
+	Reset(key string) error
+	Ping(ctx context.Context) (incrmntr.HealthReport, error)
+	Health() incrmntr.HealthReport
 	Stop() error
 }
 
@@ -30,17 +36,47 @@ type config struct {
 
 // couchbase is the implementation of Counter with couchbase
 type couchbase struct {
+	options
 	inc incrmntr.Incrmntr
 
 	// mut *sync.Mutex
 }
 
+// Option configures the Counter and Counters implementations
+type Option func(o *options)
+
+// options is the common configuration of the implementations
+type options struct {
+	wrappers []incrmntr.Middleware
+}
+
+// WithWrapper wraps the incrementers created in Init, e.g. with the
+// instrumentation of the metrics package
+func WithWrapper(wrap incrmntr.Middleware) Option {
+	return func(o *options) {
+		o.wrappers = append(o.wrappers, wrap)
+	}
+}
+
+// wrap applies the wrappers on the incrementer
+func (o options) wrap(inc incrmntr.Incrmntr) incrmntr.Incrmntr {
+	for _, wrap := range o.wrappers {
+		inc = wrap(inc)
+	}
+	return inc
+}
+
 // NewCouchbase creates a new implementation of Counter with couchbase
-func NewCouchbase() Counter {
-	return &couchbase{
+func NewCouchbase(opts ...Option) Counter {
+	c := &couchbase{
 		//analytics: analytics,
 		// mut: &sync.Mutex{},
 	}
+	for _, opt := range opts {
+		opt(&c.options)
+	}
+
+	return c
 }
 
 // Init an incrementer based on the config
@@ -51,32 +87,27 @@ func (c *couchbase) Init(cfgByte []byte) error {
 		return err
 	}
 
-	opts := gocb.ClusterOptions{
-		TimeoutsConfig: gocb.TimeoutsConfig{KVTimeout: 10*time.Second, QueryTimeout: 10*time.Second},
-		Authenticator: gocb.PasswordAuthenticator{
-			"Administrator",
-			"password",
-		},
-	}
-	cluster, err := gocb.Connect("localhost", opts)
-	if err != nil {
-		panic(err)
-	}
-
-	// get a bucket reference
-	bucket := cluster.Bucket("increment")
-	if err != nil {
-		return err
-	}
-
-	c.inc, err = incrmntr.New(bucket, cfg.Rollover, cfg.Initial, 1, false)
+	// ---- the cluster is closed by Stop through the incrementer
+	inc, err := incrmntr.Connect(cfg.Address, clusterOptions(cfg.Username, cfg.Password), cfg.Bucket, cfg.Rollover, cfg.Initial, 1, false)
 	if err != nil {
 		return err
 	}
+	c.inc = c.wrap(inc)
 
 	return nil
 }
 
+// clusterOptions is the connection options of the cluster
+func clusterOptions(username string, password string) gocb.ClusterOptions {
+	return gocb.ClusterOptions{
+		TimeoutsConfig: gocb.TimeoutsConfig{KVTimeout: 10 * time.Second, QueryTimeout: 10 * time.Second},
+		Authenticator: gocb.PasswordAuthenticator{
+			Username: username,
+			Password: password,
+		},
+	}
+}
+
 // NextVal returns the next value of the key
 func (c *couchbase) NextVal(key string) (int64, error) {
 	if c.inc == nil {
@@ -84,12 +115,12 @@ func (c *couchbase) NextVal(key string) (int64, error) {
 	}
 	// c.mut.Lock()
 	// defer c.mut.Unlock()
-	_, err := c.inc.AddSafe(key)
+	value, err := c.inc.AddSafe(key)
 	if err != nil {
 		return 0, err
 	}
 
-	return c.inc.Get(key)
+	return value.Value, nil
 }
 
 // NextValWithRollover returns the next value of the key with rollover
@@ -99,14 +130,77 @@ func (c *couchbase) NextValWithRollover(key string, rollover uint64) (int64, err
 	}
 	// c.mut.Lock()
 	// defer c.mut.Unlock()
-	_, err := c.inc.AddSafeWithRollover(key, rollover)
+	value, err := c.inc.AddSafeWithRollover(key, rollover)
 	if err != nil {
 		return 0, err
 	}
 
+	return value.Value, nil
+}
+
+// Get returns the current value of the key
+func (c *couchbase) Get(key string) (int64, error) {
+	if c.inc == nil {
+		return 0, errors.New("nil increment")
+	}
+
 	return c.inc.Get(key)
 }
 
+// Set overwrites the value of the key
+func (c *couchbase) Set(key string, value int64) error {
+	if c.inc == nil {
+		return errors.New("nil increment")
+	}
+
+	return c.inc.Set(key, value)
+}
+
+// Reset puts back the key to the initial value
+func (c *couchbase) Reset(key string) error {
+	if c.inc == nil {
+		return errors.New("nil increment")
+	}
+
+	return c.inc.Reset(key)
+}
+
+// Ping checks the bucket through the incrementer
+func (c *couchbase) Ping(ctx context.Context) (incrmntr.HealthReport, error) {
+	report := incrmntr.HealthReport{CheckedAt: time.Now()}
+	if c.inc == nil {
+		report.Error = "nil increment"
+		return report, errors.New("nil increment")
+	}
+	p, ok := incrmntr.Lookup(c.inc, isPinger)
+	if !ok {
+		report.Error = "incrementer doesn't support ping"
+		return report, errors.New("incrementer doesn't support ping")
+	}
+
+	return p.(incrmntr.Pinger).Ping(ctx)
+}
+
+// Health is Ping bound by the operation timeout
+func (c *couchbase) Health() incrmntr.HealthReport {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	report, _ := c.Ping(ctx)
+	return report
+}
+
+func isPinger(inc incrmntr.Incrmntr) bool {
+	_, ok := inc.(incrmntr.Pinger)
+	return ok
+}
+
+// Stop waits for the in-flight calls and closes the cluster,
+// the calls after it fail with incrmntr.ErrClosed
 func (c *couchbase) Stop() error {
+	if c.inc == nil {
+		return nil
+	}
+
 	return c.inc.Close()
 }
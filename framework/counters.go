@@ -0,0 +1,279 @@
+package framework
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+	"github.com/couchbase/gocb/v2"
+)
+
+// ErrUnknownCounter returned when the counter isn't declared in the config
+var ErrUnknownCounter = errors.New("unknown counter")
+
+// Counters is the set of named counters, every counter has its own
+// policy, and the keys of a counter are stored as "<counter>::<key>"
+type Counters interface {
+	Init(config []byte) error
+	NextVal(counter string, key string) (int64, error)
+	Get(counter string, key string) (int64, error)
+	Set(counter string, key string, value int64) error
+	Reset(counter string, key string) error
+	Names() []string
+	Ping(ctx context.Context) (incrmntr.HealthReport, error)
+	Health() incrmntr.HealthReport
+	Stop() error
+}
+
+// countersConfig is the config of the named counters
+type countersConfig struct {
+	Address  string            `json:"address"`
+	Username string            `json:"username"`
+	Password string            `json:"password"`
+	Bucket   string            `json:"bucket"`
+	Counters map[string]policy `json:"counters"`
+}
+
+// policy is the definition of a named counter, the counter cycles
+// back to initial after rollover if cycle set, the keys expire after
+// ttl without write if it's set
+type policy struct {
+	Rollover uint64   `json:"rollover"`
+	Initial  int64    `json:"initial"`
+	Step     uint64   `json:"step"`
+	Cycle    bool     `json:"cycle"`
+	TTL      duration `json:"ttl"`
+}
+
+// duration is a time.Duration read from strings like "30m"
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+
+	return nil
+}
+
+// parseCountersConfig reads the config, fills the defaults and validates it
+func parseCountersConfig(data []byte) (countersConfig, error) {
+	var cfg countersConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, err
+	}
+	if len(cfg.Counters) == 0 {
+		return cfg, errors.New("error no counter declared")
+	}
+
+	for name, p := range cfg.Counters {
+		if p.Step == 0 {
+			p.Step = 1
+		}
+		if err := p.validate(name); err != nil {
+			return cfg, err
+		}
+		if p.Rollover == 0 {
+			p.Rollover = maxRollover
+		}
+		cfg.Counters[name] = p
+	}
+
+	return cfg, nil
+}
+
+// maxRollover is the rollover of the counters without one
+const maxRollover = uint64(1<<63 - 1)
+
+// validate checks the policy of the named counter, rollover 0
+// means the counter doesn't have one
+func (p policy) validate(name string) error {
+	switch {
+	case name == "" || strings.Contains(name, "::"):
+		return fmt.Errorf("error invalid counter name %q", name)
+	case p.Initial < 0:
+		return fmt.Errorf("error counter %s: initial should be positive", name)
+	case p.TTL.Duration < 0:
+		return fmt.Errorf("error counter %s: ttl should be positive", name)
+	case p.TTL.Duration > 0 && p.TTL.Duration < time.Second:
+		return fmt.Errorf("error counter %s: ttl should be at least 1s", name)
+	case p.Rollover == 0 && p.Cycle:
+		return fmt.Errorf("error counter %s: cycle needs rollover", name)
+	case p.Rollover == 0:
+		return nil
+	case p.Rollover > maxRollover:
+		return fmt.Errorf("error counter %s: rollover is out of range", name)
+	case int64(p.Rollover) <= p.Initial:
+		return fmt.Errorf("error counter %s: rollover should be higher than initial", name)
+	case p.Cycle && p.Step > p.Rollover-uint64(p.Initial):
+		return fmt.Errorf("error counter %s: step should be lower than the range of the cycle", name)
+	}
+
+	return nil
+}
+
+// counters is the implementation of Counters with couchbase
+type counters struct {
+	options
+	cluster *gocb.Cluster
+	incs    map[string]incrmntr.Incrmntr
+}
+
+// NewCouchbaseCounters creates a new implementation of Counters with couchbase
+func NewCouchbaseCounters(opts ...Option) Counters {
+	c := &counters{}
+	for _, opt := range opts {
+		opt(&c.options)
+	}
+
+	return c
+}
+
+// Init validates the config and creates an incrementer per counter on the bucket
+func (c *counters) Init(cfgByte []byte) error {
+	cfg, err := parseCountersConfig(cfgByte)
+	if err != nil {
+		return err
+	}
+
+	cluster, err := gocb.Connect(cfg.Address, clusterOptions(cfg.Username, cfg.Password))
+	if err != nil {
+		return err
+	}
+	bucket := cluster.Bucket(cfg.Bucket)
+
+	c.cluster = cluster
+	c.incs = make(map[string]incrmntr.Incrmntr, len(cfg.Counters))
+	for name, p := range cfg.Counters {
+		inc, _ := incrmntr.New(bucket, p.Rollover, p.Initial, p.Step, p.Cycle)
+		inc.(*incrmntr.Incrementer).SetExpiry(p.TTL.Duration)
+		c.incs[name] = c.wrap(inc)
+	}
+
+	return nil
+}
+
+// counter returns the incrementer of the counter and the stored key
+func (c *counters) counter(name string, key string) (incrmntr.Incrmntr, string, error) {
+	if c.incs == nil {
+		return nil, "", errors.New("nil increment")
+	}
+	inc, ok := c.incs[name]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrUnknownCounter, name)
+	}
+
+	return inc, name + "::" + key, nil
+}
+
+// NextVal returns the next value of the key by the policy of the counter
+func (c *counters) NextVal(counter string, key string) (int64, error) {
+	inc, key, err := c.counter(counter, key)
+	if err != nil {
+		return 0, err
+	}
+	value, err := inc.AddSafe(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return value.Value, nil
+}
+
+// Get returns the current value of the key of the counter
+func (c *counters) Get(counter string, key string) (int64, error) {
+	inc, key, err := c.counter(counter, key)
+	if err != nil {
+		return 0, err
+	}
+
+	return inc.Get(key)
+}
+
+// Set overwrites the value of the key of the counter
+func (c *counters) Set(counter string, key string, value int64) error {
+	inc, key, err := c.counter(counter, key)
+	if err != nil {
+		return err
+	}
+
+	return inc.Set(key, value)
+}
+
+// Reset puts back the key to the initial value of the counter
+func (c *counters) Reset(counter string, key string) error {
+	inc, key, err := c.counter(counter, key)
+	if err != nil {
+		return err
+	}
+
+	return inc.Reset(key)
+}
+
+// Names returns the sorted names of the counters
+func (c *counters) Names() []string {
+	names := make([]string, 0, len(c.incs))
+	for name := range c.incs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Ping checks the bucket through one of the incrementers,
+// all of them share the same bucket
+func (c *counters) Ping(ctx context.Context) (incrmntr.HealthReport, error) {
+	report := incrmntr.HealthReport{CheckedAt: time.Now()}
+	if len(c.incs) == 0 {
+		report.Error = "nil increment"
+		return report, errors.New("nil increment")
+	}
+	p, ok := incrmntr.Lookup(c.incs[c.Names()[0]], isPinger)
+	if !ok {
+		report.Error = "incrementer doesn't support ping"
+		return report, errors.New("incrementer doesn't support ping")
+	}
+
+	return p.(incrmntr.Pinger).Ping(ctx)
+}
+
+// Health is Ping bound by the operation timeout
+func (c *counters) Health() incrmntr.HealthReport {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	report, _ := c.Ping(ctx)
+	return report
+}
+
+// Stop closes the incrementers, then the cluster
+func (c *counters) Stop() error {
+	var first error
+	for _, inc := range c.incs {
+		if err := inc.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	if c.cluster != nil {
+		if err := c.cluster.Close(nil); err != nil && first == nil {
+			first = err
+		}
+		c.cluster = nil
+	}
+
+	return first
+}
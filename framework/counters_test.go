@@ -0,0 +1,89 @@
+package framework
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+)
+
+func TestParseCountersConfig(t *testing.T) {
+	cfg, err := parseCountersConfig([]byte(`{
+		"bucket": "increment",
+		"counters": {
+			"orders": {"rollover": 999999, "initial": 1, "cycle": true},
+			"tickets": {"initial": 10, "step": 10},
+			"sessions": {"ttl": "30m"}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p := cfg.Counters["orders"]; p.Rollover != 999999 || !p.Cycle || p.Step != 1 {
+		t.Errorf("Orders policy should cycle at 999999 by 1, instead of %+v", p)
+	}
+	if p := cfg.Counters["tickets"]; p.Step != 10 || p.Cycle || p.Rollover != maxRollover {
+		t.Errorf("Tickets policy should step 10 without cycle, instead of %+v", p)
+	}
+	if p := cfg.Counters["sessions"]; p.TTL.Duration != 30*time.Minute {
+		t.Errorf("Sessions ttl should be 30m, instead of %v", p.TTL.Duration)
+	}
+}
+
+func TestParseCountersConfigErrors(t *testing.T) {
+	var cases = map[string]string{
+		`{}`:                         "no counter declared",
+		`{"counters": {"a::b": {}}}`: "invalid counter name",
+		`{"counters": {"orders": {"initial": -1}}}`:                            "initial should be positive",
+		`{"counters": {"orders": {"cycle": true}}}`:                            "cycle needs rollover",
+		`{"counters": {"orders": {"rollover": 5, "initial": 5}}}`:              "rollover should be higher than initial",
+		`{"counters": {"orders": {"rollover": 9, "step": 20, "cycle": true}}}`: "step should be lower",
+		`{"counters": {"sessions": {"ttl": "10ms"}}}`:                          "ttl should be at least 1s",
+		`{"counters": {"sessions": {"ttl": "soon"}}}`:                          "invalid duration",
+	}
+	for data, expected := range cases {
+		_, err := parseCountersConfig([]byte(data))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Config %s should fail with %q, instead of %v", data, expected, err)
+		}
+	}
+}
+
+// stubIncrementer records the keys of the adds
+type stubIncrementer struct {
+	incrmntr.Base
+	keys []string
+}
+
+func (s *stubIncrementer) AddSafe(key string) (incrmntr.NullInt64, error) {
+	s.keys = append(s.keys, key)
+	return incrmntr.NullInt64{Valid: true, Value: int64(len(s.keys))}, nil
+}
+
+func TestCountersDispatch(t *testing.T) {
+	orders, tickets := &stubIncrementer{}, &stubIncrementer{}
+	c := &counters{incs: map[string]incrmntr.Incrmntr{"orders": orders, "tickets": tickets}}
+
+	if _, err := c.NextVal("orders", "2020"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.NextVal("tickets", "2020"); err != nil {
+		t.Fatal(err)
+	}
+	if len(orders.keys) != 1 || orders.keys[0] != "orders::2020" {
+		t.Errorf("Orders should get the prefixed key, instead of %v", orders.keys)
+	}
+	if len(tickets.keys) != 1 || tickets.keys[0] != "tickets::2020" {
+		t.Errorf("Tickets should get the prefixed key, instead of %v", tickets.keys)
+	}
+
+	if _, err := c.NextVal("missing", "2020"); !errors.Is(err, ErrUnknownCounter) {
+		t.Errorf("Unknown counter should fail with ErrUnknownCounter, instead of %v", err)
+	}
+	if names := c.Names(); strings.Join(names, ",") != "orders,tickets" {
+		t.Errorf("Names should be orders,tickets, instead of %v", names)
+	}
+}
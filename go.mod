@@ -3,8 +3,16 @@ module github.com/PumpkinSeed/incrmntr/v2
 go 1.13
 
 require (
-	github.com/couchbase/gocb/v2 v2.0.0
+	github.com/couchbase/gocb/v2 v2.1.0
 	github.com/pkg/profile v1.3.0
+	github.com/prometheus/client_golang v1.5.1
+	github.com/prometheus/client_model v0.2.0
 	github.com/rs/xid v1.2.1
-	golang.org/x/net v0.0.0-20190503192946-f4e77d36d62c // indirect
+	github.com/yuin/gopher-lua v1.1.0
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	google.golang.org/grpc v1.40.0
+	google.golang.org/protobuf v1.31.0
+	modernc.org/sqlite v1.20.4
 )
@@ -0,0 +1,168 @@
+package incrmntr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// HealthKey is the sentinel key read by Ping, it doesn't have to exist
+const HealthKey = "incrmntr::health"
+
+// ErrUnhealthy returned by Ping when the storage isn't usable
+var ErrUnhealthy = errors.New("storage is unhealthy")
+
+// NodeHealth is the ping result of a single node
+type NodeHealth struct {
+	ID      string        `json:"id"`
+	Remote  string        `json:"remote"`
+	Healthy bool          `json:"healthy"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// HealthReport is the result of Ping, Latency is the latency
+// of the sentinel read
+type HealthReport struct {
+	Healthy   bool          `json:"healthy"`
+	Latency   time.Duration `json:"latency"`
+	Nodes     []NodeHealth  `json:"nodes"`
+	Error     string        `json:"error,omitempty"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+// Pinger is implemented by the incrementers able to check their storage
+type Pinger interface {
+	Ping(ctx context.Context) (HealthReport, error)
+}
+
+// Ping reads the sentinel key and pings the key-value nodes of the bucket,
+// the error wraps ErrUnhealthy if any of them failed or it's ErrClosed
+func (i *Incrementer) Ping(ctx context.Context) (HealthReport, error) {
+	report := HealthReport{CheckedAt: time.Now()}
+	if err := i.acquire(); err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+	defer i.release()
+
+	if i.backend != nil {
+		return i.pingBackend(ctx, report)
+	}
+	if i.bucket == nil {
+		return unhealthy(report, errors.New("error bucket is nil"))
+	}
+
+	// ---- the sentinel read, a missing key still means the bucket is reachable
+	start := time.Now()
+	err := i.storage(ctx, "Get", HealthKey, func(timeout time.Duration) error {
+		_, err := i.bucket.DefaultCollection().Get(HealthKey, &gocb.GetOptions{
+			Timeout: timeout,
+		})
+		return err
+	})
+	report.Latency = time.Since(start)
+	if err != nil && !errors.Is(err, gocb.ErrDocumentNotFound) {
+		return unhealthy(report, err)
+	}
+
+	// ---- the native ping bound by the deadline of the context
+	var res *gocb.PingResult
+	err = i.storage(ctx, "Ping", HealthKey, func(timeout time.Duration) error {
+		var err error
+		res, err = i.bucket.Ping(&gocb.PingOptions{
+			ServiceTypes: []gocb.ServiceType{gocb.ServiceTypeKeyValue},
+			Timeout:      timeout,
+		})
+		return err
+	})
+	if err != nil {
+		return unhealthy(report, err)
+	}
+	report.Nodes = nodeHealth(res)
+
+	return checkNodes(report)
+}
+
+// backendPinger is implemented by the backends with native ping
+type backendPinger interface {
+	Ping(ctx context.Context) error
+}
+
+// pingBackend reads the sentinel key from the backend, the backend
+// reported as the single node with the native ping if it supports it
+func (i *Incrementer) pingBackend(ctx context.Context, report HealthReport) (HealthReport, error) {
+	start := time.Now()
+	err := i.call(ctx, "Get", HealthKey, func(ctx context.Context) error {
+		_, err := i.backend.Get(ctx, HealthKey)
+		return err
+	})
+	report.Latency = time.Since(start)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return unhealthy(report, err)
+	}
+
+	node := NodeHealth{ID: i.backend.Name(), Healthy: true, Latency: report.Latency}
+	if p, ok := i.backend.(backendPinger); ok {
+		start := time.Now()
+		err := p.Ping(ctx)
+		node.Latency = time.Since(start)
+		if err != nil {
+			node.Healthy = false
+			node.Error = err.Error()
+		}
+	}
+	report.Nodes = []NodeHealth{node}
+
+	return checkNodes(report)
+}
+
+// Health is Ping bound by the timeout of the incrementer
+func (i *Incrementer) Health() HealthReport {
+	ctx, cancel := context.WithTimeout(context.Background(), i.GetTimeout())
+	defer cancel()
+
+	report, _ := i.Ping(ctx)
+	return report
+}
+
+// nodeHealth converts the ping result of the key-value nodes
+func nodeHealth(res *gocb.PingResult) []NodeHealth {
+	var nodes []NodeHealth
+	for _, endpoint := range res.Services[gocb.ServiceTypeKeyValue] {
+		nodes = append(nodes, NodeHealth{
+			ID:      endpoint.ID,
+			Remote:  endpoint.Remote,
+			Healthy: endpoint.State == gocb.PingStateOk,
+			Latency: endpoint.Latency,
+			Error:   endpoint.Error,
+		})
+	}
+
+	return nodes
+}
+
+// checkNodes marks the report healthy if there is at least
+// one node and all of them are healthy
+func checkNodes(report HealthReport) (HealthReport, error) {
+	if len(report.Nodes) == 0 {
+		return unhealthy(report, errors.New("no key-value node responded"))
+	}
+	for _, node := range report.Nodes {
+		if !node.Healthy {
+			return unhealthy(report, fmt.Errorf("node %s: %s", node.Remote, node.Error))
+		}
+	}
+	report.Healthy = true
+
+	return report, nil
+}
+
+func unhealthy(report HealthReport, err error) (HealthReport, error) {
+	report.Healthy = false
+	report.Error = err.Error()
+	return report, fmt.Errorf("%w: %v", ErrUnhealthy, err)
+}
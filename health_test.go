@@ -0,0 +1,48 @@
+package incrmntr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+func TestPingNilBucket(t *testing.T) {
+	inc := &Incrementer{timeout: time.Second}
+
+	report, err := inc.Ping(context.Background())
+	if !errors.Is(err, ErrUnhealthy) || report.Healthy || report.Error == "" {
+		t.Errorf("Ping should be unhealthy without bucket, instead of %+v, %v", report, err)
+	}
+	if report := inc.Health(); report.Healthy {
+		t.Error("Health should be unhealthy without bucket")
+	}
+}
+
+func TestNodeHealth(t *testing.T) {
+	res := &gocb.PingResult{Services: map[gocb.ServiceType][]gocb.EndpointPingReport{
+		gocb.ServiceTypeKeyValue: {
+			{ID: "a", Remote: "10.0.0.1:11210", State: gocb.PingStateOk, Latency: time.Millisecond},
+			{ID: "b", Remote: "10.0.0.2:11210", State: gocb.PingStateTimeout, Error: "timeout"},
+		},
+	}}
+
+	report, err := checkNodes(HealthReport{Nodes: nodeHealth(res)})
+	if !errors.Is(err, ErrUnhealthy) || report.Healthy {
+		t.Errorf("Report should be unhealthy with a timed out node, instead of %+v", report)
+	}
+	if len(report.Nodes) != 2 || !report.Nodes[0].Healthy || report.Nodes[1].Healthy {
+		t.Errorf("Node states should be healthy and unhealthy, instead of %+v", report.Nodes)
+	}
+
+	report, err = checkNodes(HealthReport{Nodes: report.Nodes[:1]})
+	if err != nil || !report.Healthy {
+		t.Errorf("Report should be healthy, instead of %+v, %v", report, err)
+	}
+
+	if _, err = checkNodes(HealthReport{}); !errors.Is(err, ErrUnhealthy) {
+		t.Errorf("Report should be unhealthy without nodes, instead of %v", err)
+	}
+}
@@ -0,0 +1,423 @@
+package incrmntr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// DefaultIdempotencyTTL is how long the result of a request is kept
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// ErrAmbiguous returned by AddIdempotent when an earlier attempt of the
+// request is in progress or its outcome is unknown, so the request can't
+// be done again without the risk of a second increment
+var ErrAmbiguous = errors.New("outcome of an earlier attempt of the request is unknown")
+
+// ErrNotIdempotent returned by AddIdempotent when the backend
+// doesn't implement IdempotentBackend
+var ErrNotIdempotent = errors.New("error backend doesn't support idempotent adds")
+
+// IdempotencyKey is the key of the recorded result of the request
+func IdempotencyKey(key string, requestID string) string {
+	return key + "::idempotency::" + requestID
+}
+
+// IdempotentResult is the result of an idempotent add
+type IdempotentResult struct {
+	Value int64
+
+	// Created is true if the add created the key with the initial value
+	Created bool
+
+	// Rolled is true if the add cycled back to the initial value
+	Rolled bool
+
+	// Replayed is true if the result was recorded by an earlier attempt
+	Replayed bool
+}
+
+// IdempotentBackend is implemented by the backends recording the
+// result of the request atomically with the add, the missing key is
+// created with the initial value of the op
+type IdempotentBackend interface {
+	AddIdempotent(ctx context.Context, key string, requestID string, op AddOp, ttl time.Duration) (IdempotentResult, error)
+}
+
+// idempotencyRecord is the document of the request on the bucket
+type idempotencyRecord struct {
+	Value   int64 `json:"value"`
+	Pending bool  `json:"pending,omitempty"`
+}
+
+// requestsXattr is the extended attribute of the counter document
+// recording the last requests added to it
+const requestsXattr = "incrmntr.requests"
+
+// maxRecordedRequests is the number of the requests kept in the counter document
+const maxRecordedRequests = 128
+
+// recordedRequest is a request in the counter document, Expires
+// is its expiry in unix nanoseconds
+type recordedRequest struct {
+	ID      string `json:"id"`
+	Value   int64  `json:"value"`
+	Expires int64  `json:"expires"`
+}
+
+// SetIdempotencyTTL sets how long the result of a request is
+// kept, DefaultIdempotencyTTL if it isn't set
+func (i *Incrementer) SetIdempotencyTTL(ttl time.Duration) {
+	i.idempotencyTTL = ttl
+}
+
+// AddIdempotent do the increment on the specified key once per request,
+// the retries of the request return the value of the first attempt
+func (i *Incrementer) AddIdempotent(key string, requestID string) (NullInt64, error) {
+	return i.AddIdempotentContext(context.Background(), key, requestID)
+}
+
+// AddIdempotentContext is AddIdempotent bound by the context. On the bucket
+// the request is claimed by a document before the add, the add records the
+// request in the counter document by the same CAS write and the value is
+// copied to the claim after. The retries of an attempt failed with a timeout
+// find the claim and look for the request in the counter document, they
+// return ErrAmbiguous only if it isn't there, e.g. it was overwritten by a
+// plain add. The IdempotentBackend backends do it atomically without ambiguity.
+func (i *Incrementer) AddIdempotentContext(ctx context.Context, key string, requestID string) (value NullInt64, err error) {
+	ctx, span := i.startSpan(ctx, "AddIdempotent", key)
+	defer func() { endSpan(span, err) }()
+
+	if err = i.acquire(); err != nil {
+		return nullInt64(), err
+	}
+	defer i.release()
+
+	if i.bucket == nil && i.backend == nil {
+		return nullInt64(), errors.New("error bucket is nil")
+	}
+	if requestID == "" {
+		return nullInt64(), errors.New("error request id is empty")
+	}
+	if i.backend != nil {
+		return i.backendAddIdempotent(ctx, key, requestID)
+	}
+
+	return i.bucketAddIdempotent(ctx, key, requestID)
+}
+
+// ttl returns the idempotency ttl of the requests
+func (i *Incrementer) ttl() time.Duration {
+	if i.idempotencyTTL > 0 {
+		return i.idempotencyTTL
+	}
+	return DefaultIdempotencyTTL
+}
+
+// backendAddIdempotent does the idempotent add on the backend, it's
+// retried on the retryable errors like AddSafe
+func (i *Incrementer) backendAddIdempotent(ctx context.Context, key string, requestID string) (NullInt64, error) {
+	b, ok := i.backend.(IdempotentBackend)
+	if !ok {
+		return nullInt64(), ErrNotIdempotent
+	}
+
+	op := AddOp{
+		Delta:    i.inc,
+		Rollover: i.rollover,
+		Initial:  i.initial,
+		Cycle:    i.cycle,
+		Expiry:   i.expiry,
+	}
+	var res IdempotentResult
+	add := func() error {
+		return i.call(ctx, "AddIdempotent", key, func(ctx context.Context) error {
+			var err error
+			res, err = b.AddIdempotent(ctx, key, requestID, op, i.ttl())
+			return err
+		})
+	}
+	err := add()
+	for attempt := 1; retryable(err); attempt++ {
+		if ctx.Err() != nil {
+			return nullInt64(), ctx.Err()
+		}
+		i.emit(Event{Kind: EventRetry, Key: key, Attempt: attempt, Err: err})
+		spanFromContext(ctx).SetAttributes(Attribute{Key: AttrRetryAttempt, Value: attempt})
+		err = add()
+	}
+	if err != nil {
+		return nullInt64(), err
+	}
+
+	switch {
+	case res.Created:
+		i.emit(Event{Kind: EventKeyCreated, Key: key})
+	case res.Rolled:
+		i.emit(Event{Kind: EventRollover, Key: key})
+		spanFromContext(ctx).SetAttributes(Attribute{Key: AttrRollover, Value: true})
+	}
+
+	return nullInt64From(res.Value), nil
+}
+
+// bucketAddIdempotent claims the request with a document, does the add
+// recording the request in the counter and copies its value to the claim
+func (i *Incrementer) bucketAddIdempotent(ctx context.Context, key string, requestID string) (NullInt64, error) {
+	recordKey := IdempotencyKey(key, requestID)
+	collection := i.bucket.DefaultCollection()
+
+	// ---- the result of an earlier attempt
+	var record idempotencyRecord
+	err := i.storage(ctx, "Get", recordKey, func(timeout time.Duration) error {
+		res, err := collection.Get(recordKey, &gocb.GetOptions{Timeout: timeout})
+		if err != nil {
+			return err
+		}
+		return res.Content(&record)
+	})
+	if err == nil && record.Pending {
+		return i.resolveClaim(ctx, key, requestID)
+	}
+	if err == nil {
+		return nullInt64From(record.Value), nil
+	}
+	if !errors.Is(err, gocb.ErrDocumentNotFound) {
+		return nullInt64(), err
+	}
+
+	// ---- claim the request, the concurrent attempts of it fail here
+	err = i.storage(ctx, "Insert", recordKey, func(timeout time.Duration) error {
+		_, err := collection.Insert(recordKey, idempotencyRecord{Pending: true}, &gocb.InsertOptions{
+			Timeout: timeout,
+			Expiry:  i.ttl(),
+		})
+		return err
+	})
+	if errors.Is(err, gocb.ErrDocumentExists) {
+		return nullInt64(), ErrAmbiguous
+	}
+	if err != nil {
+		i.removeClaim(recordKey)
+		return nullInt64(), err
+	}
+
+	value, err := i.addRecorded(ctx, key, requestID)
+	if err != nil {
+		// ---- the failed add can be retried, the timed out write may be
+		// done, its claim is resolved from the counter by the retries
+		if !errors.Is(err, ErrAmbiguous) {
+			i.removeClaim(recordKey)
+		}
+		return nullInt64(), err
+	}
+
+	// ---- the value is returned even if it isn't copied, the
+	// retries of the request find it in the counter then
+	i.recordClaim(ctx, recordKey, value.Value)
+
+	return value, nil
+}
+
+// resolveClaim returns the value of the pending request recorded in the
+// counter document, ErrAmbiguous if it isn't there
+func (i *Incrementer) resolveClaim(ctx context.Context, key string, requestID string) (NullInt64, error) {
+	requests, err := i.recordedRequests(ctx, key)
+	if errors.Is(err, gocb.ErrDocumentNotFound) {
+		return nullInt64(), ErrAmbiguous
+	}
+	if err != nil {
+		return nullInt64(), err
+	}
+	for _, r := range requests {
+		if r.ID == requestID {
+			i.recordClaim(ctx, IdempotencyKey(key, requestID), r.Value)
+			return nullInt64From(r.Value), nil
+		}
+	}
+
+	return nullInt64(), ErrAmbiguous
+}
+
+// recordClaim writes the value of the request to its claim
+func (i *Incrementer) recordClaim(ctx context.Context, recordKey string, value int64) {
+	_ = i.storage(ctx, "Replace", recordKey, func(timeout time.Duration) error {
+		_, err := i.bucket.DefaultCollection().Replace(recordKey, idempotencyRecord{Value: value}, &gocb.ReplaceOptions{
+			Timeout: timeout,
+			Expiry:  i.ttl(),
+		})
+		return err
+	})
+}
+
+// addRecorded does the add of the request, it's retried on the retryable
+// errors like AddSafe, but not after the write of the counter timed out
+func (i *Incrementer) addRecorded(ctx context.Context, key string, requestID string) (NullInt64, error) {
+	value, err := i.addRecordedOnce(ctx, key, requestID)
+	for attempt := 1; retryable(err); attempt++ {
+		if ctx.Err() != nil {
+			return nullInt64(), ctx.Err()
+		}
+		i.emit(Event{Kind: EventRetry, Key: key, Attempt: attempt, Err: err})
+		spanFromContext(ctx).SetAttributes(Attribute{Key: AttrRetryAttempt, Value: attempt})
+		value, err = i.addRecordedOnce(ctx, key, requestID)
+	}
+
+	return value, err
+}
+
+// addRecordedOnce increments the counter and records the request in it by
+// one CAS write, the missing counter is created with the request
+func (i *Incrementer) addRecordedOnce(ctx context.Context, key string, requestID string) (NullInt64, error) {
+	collection := i.bucket.DefaultCollection()
+	now := time.Now()
+	record := recordedRequest{ID: requestID, Expires: now.Add(i.ttl()).UnixNano()}
+
+	// ---- get the current value and lock the cas
+	var res *gocb.GetResult
+	lockStart := time.Now()
+	err := i.storage(ctx, "GetAndLock", key, func(timeout time.Duration) error {
+		var err error
+		res, err = collection.GetAndLock(key, 100*time.Millisecond, &gocb.GetAndLockOptions{
+			Timeout: timeout,
+		})
+		return err
+	})
+	i.emit(Event{Kind: EventLockWait, Key: key, Duration: time.Since(lockStart), Err: err})
+	if errors.Is(err, gocb.ErrDocumentNotFound) {
+		record.Value = i.initial
+		err = i.storage(ctx, "MutateIn", key, func(timeout time.Duration) error {
+			_, err := collection.MutateIn(key, requestSpecs(i.initial, []recordedRequest{record}), &gocb.MutateInOptions{
+				StoreSemantic: gocb.StoreSemanticsInsert,
+				Expiry:        i.expiry,
+				Timeout:       timeout,
+			})
+			return err
+		})
+		if errors.Is(err, gocb.ErrDocumentExists) {
+			return nullInt64(), ErrConflict
+		}
+		if err != nil {
+			return nullInt64(), written(err)
+		}
+		i.emit(Event{Kind: EventKeyCreated, Key: key})
+		return nullInt64From(i.initial), nil
+	}
+	if err != nil {
+		return nullInt64(), err
+	}
+	var current int64
+	if err := res.Content(&current); err != nil {
+		return nullInt64(), err
+	}
+	requests, err := i.recordedRequests(ctx, key)
+	if err != nil {
+		return nullInt64(), err
+	}
+
+	value, rolled := AddOp{
+		Delta:    i.inc,
+		Rollover: i.rollover,
+		Initial:  i.initial,
+		Cycle:    i.cycle,
+	}.Apply(current)
+	record.Value = value
+	requests = append(pruneRequests(requests, now.UnixNano()), record)
+
+	err = i.storage(ctx, "MutateIn", key, func(timeout time.Duration) error {
+		_, err := collection.MutateIn(key, requestSpecs(value, requests), &gocb.MutateInOptions{
+			Cas:     res.Cas(),
+			Expiry:  i.expiry,
+			Timeout: timeout,
+		})
+		return err
+	})
+	if err != nil {
+		return nullInt64(), written(err)
+	}
+	if rolled {
+		i.emit(Event{Kind: EventRollover, Key: key})
+		spanFromContext(ctx).SetAttributes(Attribute{Key: AttrRollover, Value: true})
+	}
+
+	return nullInt64From(value), nil
+}
+
+// recordedRequests returns the requests recorded in the counter document
+func (i *Incrementer) recordedRequests(ctx context.Context, key string) ([]recordedRequest, error) {
+	var requests []recordedRequest
+	err := i.storage(ctx, "LookupIn", key, func(timeout time.Duration) error {
+		res, err := i.bucket.DefaultCollection().LookupIn(key, []gocb.LookupInSpec{
+			gocb.GetSpec(requestsXattr, &gocb.GetSpecOptions{IsXattr: true}),
+		}, &gocb.LookupInOptions{Timeout: timeout})
+		if err != nil {
+			return err
+		}
+		if !res.Exists(0) {
+			return nil
+		}
+		return res.ContentAt(0, &requests)
+	})
+
+	return requests, err
+}
+
+// requestSpecs replaces the counter document with the value
+// and the recorded requests
+func requestSpecs(value int64, requests []recordedRequest) []gocb.MutateInSpec {
+	return []gocb.MutateInSpec{
+		gocb.UpsertSpec(requestsXattr, requests, &gocb.UpsertSpecOptions{IsXattr: true, CreatePath: true}),
+		gocb.ReplaceSpec("", value, nil),
+	}
+}
+
+// pruneRequests drops the expired requests and the oldest ones
+// over the limit, so the new one fits
+func pruneRequests(requests []recordedRequest, now int64) []recordedRequest {
+	live := make([]recordedRequest, 0, len(requests)+1)
+	for _, r := range requests {
+		if r.Expires > now {
+			live = append(live, r)
+		}
+	}
+	if len(live) >= maxRecordedRequests {
+		live = live[len(live)-maxRecordedRequests+1:]
+	}
+
+	return live
+}
+
+// removeClaim removes the claim of the request, it runs after the context
+// of the call may be done, so it's bound by the timeout only
+func (i *Incrementer) removeClaim(recordKey string) {
+	_ = i.storage(context.Background(), "Remove", recordKey, func(timeout time.Duration) error {
+		_, err := i.bucket.DefaultCollection().Remove(recordKey, &gocb.RemoveOptions{Timeout: timeout})
+		return err
+	})
+}
+
+// written returns the error of the write of the counter, ErrAmbiguous
+// wrapping it if the write may be done
+func written(err error) error {
+	if ambiguous(err) {
+		return fmt.Errorf("%w: %v", ErrAmbiguous, err)
+	}
+	return err
+}
+
+// ambiguous reports whether the failed write may be done on the server,
+// it's called with the errors of the writes only, a timed out read
+// before the write leaves nothing done
+func ambiguous(err error) bool {
+	if errors.Is(err, gocb.ErrUnambiguousTimeout) {
+		return false
+	}
+	return errors.Is(err, gocb.ErrTimeout) ||
+		errors.Is(err, gocb.ErrAmbiguousTimeout) ||
+		errors.Is(err, gocb.ErrRequestCanceled) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,46 @@
+package incrmntr
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+func TestWritten(t *testing.T) {
+	for _, test := range []struct {
+		err       error
+		ambiguous bool
+	}{
+		{gocb.ErrAmbiguousTimeout, true},
+		{context.DeadlineExceeded, true},
+		{gocb.ErrUnambiguousTimeout, false},
+		{gocb.ErrCasMismatch, false},
+		{gocb.ErrTemporaryFailure, false},
+	} {
+		err := written(test.err)
+		if errors.Is(err, ErrAmbiguous) != test.ambiguous || (!test.ambiguous && err != test.err) {
+			t.Errorf("error of the write %v should be ambiguous %v, instead of %v", test.err, test.ambiguous, err)
+		}
+	}
+}
+
+func TestPruneRequests(t *testing.T) {
+	var requests []recordedRequest
+	for k := 0; k < maxRecordedRequests+10; k++ {
+		requests = append(requests, recordedRequest{ID: string(rune('a' + k%26)), Value: int64(k), Expires: int64(k)})
+	}
+
+	// ---- the expired requests and the oldest ones over the limit are dropped
+	pruned := pruneRequests(requests, 5)
+	if len(pruned) != maxRecordedRequests-1 {
+		t.Fatalf("requests should be %d, instead of %d", maxRecordedRequests-1, len(pruned))
+	}
+	if last := pruned[len(pruned)-1]; last.Value != int64(maxRecordedRequests+9) {
+		t.Errorf("newest request should be kept, instead of %+v", last)
+	}
+	if pruned := pruneRequests(requests[:10], 5); len(pruned) != 4 || pruned[0].Value != 6 {
+		t.Errorf("expired requests should be dropped, instead of %+v", pruned)
+	}
+}
@@ -1,6 +1,7 @@
 package incrmntr
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"time"
@@ -15,10 +16,25 @@ type Incrmntr interface {
 	AddSafe(key string) (NullInt64, error)
 	AddWithRollover(key string, rollover uint64) (NullInt64, error)
 	AddSafeWithRollover(key string, rollover uint64) (NullInt64, error)
+	Set(key string, value int64) error
+	Reset(key string) error
 	SetTimeout(timeout time.Duration)
 	Close() error
 }
 
+// ContextIncrmntr is the Incrmntr taking the context of the calls, it's
+// implemented by the Incrementer and by the middlewares built on Base
+type ContextIncrmntr interface {
+	Incrmntr
+	GetContext(ctx context.Context, key string) (int64, error)
+	AddContext(ctx context.Context, key string) (NullInt64, error)
+	AddSafeContext(ctx context.Context, key string) (NullInt64, error)
+	AddWithRolloverContext(ctx context.Context, key string, rollover uint64) (NullInt64, error)
+	AddSafeWithRolloverContext(ctx context.Context, key string, rollover uint64) (NullInt64, error)
+	SetContext(ctx context.Context, key string, value int64) error
+	ResetContext(ctx context.Context, key string) error
+}
+
 type BucketOpts struct {
 	OperationTimeout      NullTimeout
 	BulkOperationTimeout  NullTimeout
@@ -40,6 +56,20 @@ type Incrementer struct {
 	inc      uint64
 	cycle    bool
 	timeout  time.Duration
+	expiry   time.Duration
+
+	idempotencyTTL time.Duration
+	coalescer      *coalescer
+
+	stream        ChangeStream
+	watchInterval time.Duration
+	observers     []Observer
+	tracer        Tracer
+	logger        *logger
+
+	life    lifecycle
+	cluster *gocb.Cluster
+	backend Backend
 }
 
 // New creates a new handler which implements the Incrmntr and setup the buckets
@@ -56,9 +86,39 @@ func New(bucket *gocb.Bucket, rollover uint64, initial int64, inc uint64, cycle
 
 // Get the value of the given key
 func (i *Incrementer) Get(key string) (int64, error) {
+	return i.GetContext(context.Background(), key)
+}
+
+// GetContext is Get bound by the context
+func (i *Incrementer) GetContext(ctx context.Context, key string) (value int64, err error) {
+	ctx, span := i.startSpan(ctx, "Get", key)
+	defer func() { endSpan(span, err) }()
+
+	if err = i.acquire(); err != nil {
+		return 0, err
+	}
+	defer i.release()
+
+	if i.bucket == nil && i.backend == nil {
+		return 0, errors.New("error bucket is nil")
+	}
+
+	if i.backend != nil {
+		err = i.call(ctx, "Get", key, func(ctx context.Context) error {
+			value, err = i.backend.Get(ctx, key)
+			return err
+		})
+		return value, err
+	}
+
 	var v interface{}
-	doc, err := i.bucket.DefaultCollection().Get(key, &gocb.GetOptions{
-		Timeout: i.GetTimeout(),
+	var doc *gocb.GetResult
+	err = i.storage(ctx, "Get", key, func(timeout time.Duration) error {
+		var err error
+		doc, err = i.bucket.DefaultCollection().Get(key, &gocb.GetOptions{
+			Timeout: timeout,
+		})
+		return err
 	})
 	if err != nil {
 		return 0, err
@@ -71,120 +131,255 @@ func (i *Incrementer) Get(key string) (int64, error) {
 // AddWithRollover is do the increment on the specified key
 // custom rollover on the key available
 func (i *Incrementer) AddWithRollover(key string, rollover uint64) (NullInt64, error) {
-	if i.bucket == nil {
+	return i.AddWithRolloverContext(context.Background(), key, rollover)
+}
+
+// AddWithRolloverContext is AddWithRollover bound by the context
+func (i *Incrementer) AddWithRolloverContext(ctx context.Context, key string, rollover uint64) (value NullInt64, err error) {
+	ctx, span := i.startSpan(ctx, "AddWithRollover", key)
+	defer func() { endSpan(span, err) }()
+
+	if err = i.acquire(); err != nil {
+		return nullInt64(), err
+	}
+	defer i.release()
+
+	if i.bucket == nil && i.backend == nil {
 		return nullInt64(), errors.New("error bucket is nil")
 	}
-	return i.add(key, rollover)
+	return i.add(ctx, key, rollover)
 }
 
 // AddSafeWithRollover do the increment on the specified key
 // concurrency and lock safe increment
 // custom rollover on the key available
 func (i *Incrementer) AddSafeWithRollover(key string, rollover uint64) (NullInt64, error) {
-	if i.bucket == nil {
-		return nullInt64(), errors.New("error bucket is nil")
-	}
+	return i.AddSafeWithRolloverContext(context.Background(), key, rollover)
+}
 
-	var value NullInt64
-	var err error
-	value, err = i.add(key, rollover)
-	if errors.Is(err, gocb.ErrTemporaryFailure) {
-		for {
-			value, err = i.add(key, rollover)
-			if err == nil {
-				break
-			}
-		}
-	} else if err != nil {
+// AddSafeWithRolloverContext is AddSafeWithRollover bound by the context,
+// the retries stop when the context is done
+func (i *Incrementer) AddSafeWithRolloverContext(ctx context.Context, key string, rollover uint64) (value NullInt64, err error) {
+	ctx, span := i.startSpan(ctx, "AddSafeWithRollover", key)
+	defer func() { endSpan(span, err) }()
+
+	if err = i.acquire(); err != nil {
 		return nullInt64(), err
 	}
+	defer i.release()
 
-	return value, nil
+	return i.addSafe(ctx, key, rollover)
 }
 
 // Add is do the increment on the specified key
 func (i *Incrementer) Add(key string) (NullInt64, error) {
-	if i.bucket == nil {
+	return i.AddContext(context.Background(), key)
+}
+
+// AddContext is Add bound by the context
+func (i *Incrementer) AddContext(ctx context.Context, key string) (value NullInt64, err error) {
+	ctx, span := i.startSpan(ctx, "Add", key)
+	defer func() { endSpan(span, err) }()
+
+	if err = i.acquire(); err != nil {
+		return nullInt64(), err
+	}
+	defer i.release()
+
+	if i.bucket == nil && i.backend == nil {
 		return nullInt64(), errors.New("error bucket is nil")
 	}
-	return i.add(key, i.rollover)
+	return i.add(ctx, key, i.rollover)
 }
 
 // AddSafe do the increment on the specified key
 // concurrency and lock safe increment
 func (i *Incrementer) AddSafe(key string) (NullInt64, error) {
-	if i.bucket == nil {
+	return i.AddSafeContext(context.Background(), key)
+}
+
+// AddSafeContext is AddSafe bound by the context,
+// the retries stop when the context is done
+func (i *Incrementer) AddSafeContext(ctx context.Context, key string) (value NullInt64, err error) {
+	ctx, span := i.startSpan(ctx, "AddSafe", key)
+	defer func() { endSpan(span, err) }()
+
+	if err = i.acquire(); err != nil {
+		return nullInt64(), err
+	}
+	defer i.release()
+
+	return i.addSafe(ctx, key, i.rollover)
+}
+
+// addSafe retries the add until it succeeds or the context is done,
+// the concurrent adds of the key are batched if coalescing is set
+func (i *Incrementer) addSafe(ctx context.Context, key string, rollover uint64) (NullInt64, error) {
+	if i.bucket == nil && i.backend == nil {
 		return nullInt64(), errors.New("error bucket is nil")
 	}
+	if i.coalescer != nil {
+		return i.coalescer.add(ctx, i, key, rollover)
+	}
 
-	var value NullInt64
-	var err error
-	value, err = i.add(key, i.rollover)
-	if errors.Is(err, gocb.ErrTemporaryFailure) {
-		for {
-			value, err = i.add(key, i.rollover)
-			if err == nil {
-				break
-			}
+	value, _, err := i.addSafeSteps(ctx, key, rollover, 1)
+	return value, err
+}
+
+// addSafeSteps retries the add of the steps on the retryable errors, the
+// other ones aren't retried, e.g. the timed out Replace may be done
+// already, it returns like addSteps
+func (i *Incrementer) addSafeSteps(ctx context.Context, key string, rollover uint64, steps uint64) (NullInt64, uint64, error) {
+	value, given, err := i.addSteps(ctx, key, rollover, steps)
+	for attempt := 1; retryable(err); attempt++ {
+		if ctx.Err() != nil {
+			return nullInt64(), 0, ctx.Err()
 		}
-	}else if err != nil {
-		return nullInt64(), err
+		i.emit(Event{Kind: EventRetry, Key: key, Attempt: attempt, Err: err})
+		spanFromContext(ctx).SetAttributes(Attribute{Key: AttrRetryAttempt, Value: attempt})
+		value, given, err = i.addSteps(ctx, key, rollover, steps)
+	}
+	if err != nil {
+		return nullInt64(), 0, err
 	}
 
-	return value, nil
+	return value, given, nil
+}
+
+// retryable reports whether the failed add wrote nothing and can be
+// retried: a conflict, e.g. the lock expired and an other writer changed
+// the cas before the Replace, the key locked by an other writer or a
+// temporary failure of the server
+func retryable(err error) bool {
+	return errors.Is(err, ErrConflict) ||
+		errors.Is(err, gocb.ErrCasMismatch) ||
+		errors.Is(err, gocb.ErrDocumentLocked) ||
+		errors.Is(err, gocb.ErrTemporaryFailure)
 }
 
-// Close the bucket
-func (i *Incrementer) Close() error {
-	//err := i.bucket.Close()
-	i.bucket = nil
-	return nil
+// Set overwrites the value of the given key
+func (i *Incrementer) Set(key string, value int64) error {
+	return i.SetContext(context.Background(), key, value)
+}
+
+// SetContext is Set bound by the context
+func (i *Incrementer) SetContext(ctx context.Context, key string, value int64) (err error) {
+	ctx, span := i.startSpan(ctx, "Set", key)
+	defer func() { endSpan(span, err) }()
+
+	if err = i.acquire(); err != nil {
+		return err
+	}
+	defer i.release()
+
+	if i.bucket == nil && i.backend == nil {
+		return errors.New("error bucket is nil")
+	}
+	if i.backend != nil {
+		return i.call(ctx, "Set", key, func(ctx context.Context) error {
+			return i.backend.Set(ctx, key, value, i.expiry)
+		})
+	}
+
+	return i.storage(ctx, "Upsert", key, func(timeout time.Duration) error {
+		_, err := i.bucket.DefaultCollection().Upsert(key, value, &gocb.UpsertOptions{
+			Timeout: timeout,
+			Expiry:  i.expiry,
+		})
+		return err
+	})
+}
+
+// Reset puts back the given key to the initial value
+func (i *Incrementer) Reset(key string) error {
+	return i.SetContext(context.Background(), key, i.initial)
+}
+
+// ResetContext is Reset bound by the context
+func (i *Incrementer) ResetContext(ctx context.Context, key string) error {
+	return i.SetContext(ctx, key, i.initial)
 }
 
 // add handle the increment mechanism, rollover passed as
 // parameter because there is functions with custom rollover
-func (i *Incrementer) add(key string, rollover uint64) (NullInt64, error) {
+func (i *Incrementer) add(ctx context.Context, key string, rollover uint64) (NullInt64, error) {
+	value, _, err := i.addSteps(ctx, key, rollover, 1)
+	return value, err
+}
+
+// addSteps increments the key by the steps at once, it returns the last
+// value and the number of the values given by the add: the steps, or 1
+// if the key was created or cycled back to the initial value
+func (i *Incrementer) addSteps(ctx context.Context, key string, rollover uint64, steps uint64) (NullInt64, uint64, error) {
+	if i.backend != nil {
+		return i.backendAdd(ctx, key, rollover, steps)
+	}
+
 	var err error
 
 	// ---- initKey called first to ensure key will be ready for operation
-	initHappened, err := i.initKey(key)
+	initHappened, err := i.initKey(ctx, key)
 	if err != nil {
-		return nullInt64(), err
+		return nullInt64(), 0, err
 	}
 	if initHappened {
-		return nullInt64From(1), nil
+		return nullInt64From(i.initial), 1, nil
 	}
 
 	// ---- get the current value and lock the cas
 	var current interface{}
-	res, err := i.bucket.DefaultCollection().GetAndLock(key, 100*time.Millisecond, &gocb.GetAndLockOptions{
-		Timeout: i.GetTimeout(),
+	var res *gocb.GetResult
+	lockStart := time.Now()
+	err = i.storage(ctx, "GetAndLock", key, func(timeout time.Duration) error {
+		var err error
+		res, err = i.bucket.DefaultCollection().GetAndLock(key, 100*time.Millisecond, &gocb.GetAndLockOptions{
+			Timeout: timeout,
+		})
+		return err
 	})
+	i.emit(Event{Kind: EventLockWait, Key: key, Duration: time.Since(lockStart), Err: err})
 	if err != nil {
-		return nullInt64(), err
+		return nullInt64(), 0, err
 	}
 	cas := res.Cas()
 	err = res.Content(&current)
 	if err != nil {
-		return nullInt64(), err
+		return nullInt64(), 0, err
 	}
 
 	// ---- do the exact increment mechanism
-	newValue := current.(float64) + float64(i.inc)
-	if i.cycle && newValue > float64(rollover) {
+	newValue := current.(float64) + float64(i.inc*steps)
+	rolled := i.cycle && newValue > float64(rollover)
+	if rolled && steps > 1 {
+		// ---- the batch crosses the rollover, give the values up to it
+		if fit := fitSteps(int64(current.(float64)), rollover, i.inc); fit > 0 {
+			steps = fit
+			newValue = current.(float64) + float64(i.inc*steps)
+			rolled = false
+		}
+	}
+	if rolled {
 		newValue = float64(i.initial)
+		steps = 1
 	}
 
-	_, err = i.bucket.DefaultCollection().Replace(key, newValue, &gocb.ReplaceOptions{Expiry: 0, Cas: cas, Timeout: i.GetTimeout()})
+	err = i.storage(ctx, "Replace", key, func(timeout time.Duration) error {
+		_, err := i.bucket.DefaultCollection().Replace(key, newValue, &gocb.ReplaceOptions{Expiry: i.expiry, Cas: cas, Timeout: timeout})
+		return err
+	})
 
 	// https://developer.couchbase.com/documentation/server/3.x/developer/dev-guide-3.0/lock-items.html
+	if rolled && err == nil {
+		i.emit(Event{Kind: EventRollover, Key: key})
+		spanFromContext(ctx).SetAttributes(Attribute{Key: AttrRollover, Value: true})
+	}
 
-	return nullInt64From(int64(newValue)), err
+	return nullInt64From(int64(newValue)), steps, err
 }
 
 // initKey do the key initialze process, it's means
 // if the key not found, call the Counter which creates it
-func (i *Incrementer) initKey(key string) (bool, error) {
+func (i *Incrementer) initKey(ctx context.Context, key string) (bool, error) {
 	i.Lock()
 	defer i.Unlock()
 
@@ -192,20 +387,27 @@ func (i *Incrementer) initKey(key string) (bool, error) {
 	var happened = false
 
 	// ---- check key is exists, if not create it
-	_, err := i.bucket.DefaultCollection().Get(key, &gocb.GetOptions{
-		Timeout: i.GetTimeout(),
+	err := i.storage(ctx, "Get", key, func(timeout time.Duration) error {
+		_, err := i.bucket.DefaultCollection().Get(key, &gocb.GetOptions{
+			Timeout: timeout,
+		})
+		return err
 	})
 	//res.Content(&v)
 	if errors.Is(err, gocb.ErrDocumentNotFound) {
-		_, err = i.bucket.DefaultCollection().Binary().Increment(key, &gocb.IncrementOptions{
-			Initial: i.initial,
-			Delta:   uint64(i.initial),
-			Timeout: i.GetTimeout(),
-			Expiry:  0, // Seconds
+		err = i.storage(ctx, "Increment", key, func(timeout time.Duration) error {
+			_, err := i.bucket.DefaultCollection().Binary().Increment(key, &gocb.IncrementOptions{
+				Initial: i.initial,
+				Delta:   uint64(i.initial),
+				Timeout: timeout,
+				Expiry:  i.expiry,
+			})
+			return err
 		})
 		if err != nil {
 			return false, err
 		}
+		i.emit(Event{Kind: EventKeyCreated, Key: key})
 		happened = true
 	} else {
 		return false, err
@@ -214,6 +416,12 @@ func (i *Incrementer) initKey(key string) (bool, error) {
 	return happened, nil
 }
 
+// SetExpiry sets the expiry of the keys, refreshed on every write,
+// 0 means the keys never expire
+func (i *Incrementer) SetExpiry(expiry time.Duration) {
+	i.expiry = expiry
+}
+
 func (i *Incrementer) GetTimeout() time.Duration {
 	return i.timeout
 }
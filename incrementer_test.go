@@ -1,6 +1,7 @@
 package incrmntr
 
 import (
+	"context"
 	"github.com/couchbase/gocb/v2"
 	"github.com/rs/xid"
 	"sync"
@@ -192,6 +193,27 @@ func TestIncrementer_ReturnAdd(t *testing.T) {
 	}
 }
 
+func TestIncrementer_FirstAddInitial(t *testing.T) {
+	var key = xid.New().String()
+
+	bucket, closeCluster := getBucket()
+	defer closeCluster(nil)
+
+	inc, err := New(bucket, 99, 5, 1, true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// ---- the first add creates the key with the initial value and returns it
+	value, err := inc.AddSafe(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !value.Valid || value.Value != 5 {
+		t.Errorf("First value should be the initial 5, instead of %d", value.Value)
+	}
+}
+
 func TestIncrementer_AddWithoutycle(t *testing.T) {
 	var rollover = int64(99)
 	var init = int64(1)
@@ -238,7 +260,7 @@ func TestInitKey(t *testing.T) {
 	}
 
 	incrementer := inc.(*Incrementer)
-	_, err = incrementer.initKey(key)
+	_, err = incrementer.initKey(context.Background(), key)
 	if err != nil {
 		t.Error(err)
 	}
@@ -0,0 +1,142 @@
+package incrmntrtest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+)
+
+// Memory is the reference Backend keeping the counters in memory,
+// for the tests of the code built on the incrementer
+type Memory struct {
+	mu     sync.Mutex
+	keys   map[string]entry
+	now    func() time.Time
+	closed bool
+}
+
+type entry struct {
+	value   int64
+	expires time.Time
+}
+
+// NewMemory creates an empty backend
+func NewMemory() *Memory {
+	return &Memory{keys: make(map[string]entry), now: time.Now}
+}
+
+// Name is memory
+func (m *Memory) Name() string {
+	return "memory"
+}
+
+// Get returns the value of the key
+func (m *Memory) Get(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.get(key)
+	if !ok {
+		return 0, incrmntr.ErrKeyNotFound
+	}
+	return e.value, nil
+}
+
+// Init creates the key if it doesn't exist
+func (m *Memory) Init(ctx context.Context, key string, value int64, expiry time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.get(key); ok {
+		return false, nil
+	}
+	m.keys[key] = entry{value: value, expires: m.expires(expiry)}
+
+	return true, nil
+}
+
+// Add increments the key, ErrConflict returned if the key expired
+func (m *Memory) Add(ctx context.Context, key string, op incrmntr.AddOp) (int64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.get(key)
+	if !ok {
+		return 0, false, incrmntr.ErrConflict
+	}
+	if err := op.Crossing(e.value); err != nil {
+		return 0, false, err
+	}
+	value, rolled := op.Apply(e.value)
+	m.keys[key] = entry{value: value, expires: m.expires(op.Expiry)}
+
+	return value, rolled, nil
+}
+
+// AddIdempotent increments the key and records the result of the request
+func (m *Memory) AddIdempotent(ctx context.Context, key string, requestID string, op incrmntr.AddOp, ttl time.Duration) (incrmntr.IdempotentResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	recordKey := incrmntr.IdempotencyKey(key, requestID)
+	if e, ok := m.get(recordKey); ok {
+		return incrmntr.IdempotentResult{Value: e.value, Replayed: true}, nil
+	}
+
+	var res incrmntr.IdempotentResult
+	if e, ok := m.get(key); ok {
+		res.Value, res.Rolled = op.Apply(e.value)
+	} else {
+		res.Value, res.Created = op.Initial, true
+	}
+	m.keys[key] = entry{value: res.Value, expires: m.expires(op.Expiry)}
+	m.keys[recordKey] = entry{value: res.Value, expires: m.expires(ttl)}
+
+	return res, nil
+}
+
+// Set overwrites the value of the key
+func (m *Memory) Set(ctx context.Context, key string, value int64, expiry time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keys[key] = entry{value: value, expires: m.expires(expiry)}
+	return nil
+}
+
+// Close marks the backend closed
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	return nil
+}
+
+// Closed reports whether Close was called
+func (m *Memory) Closed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.closed
+}
+
+// get returns the live entry of the key
+func (m *Memory) get(key string) (entry, bool) {
+	e, ok := m.keys[key]
+	if ok && !e.expires.IsZero() && !m.now().Before(e.expires) {
+		delete(m.keys, key)
+		return entry{}, false
+	}
+	return e, ok
+}
+
+// expires returns the expiry time of a key written now
+func (m *Memory) expires(expiry time.Duration) time.Time {
+	if expiry <= 0 {
+		return time.Time{}
+	}
+	return m.now().Add(expiry)
+}
@@ -0,0 +1,13 @@
+package incrmntrtest
+
+import (
+	"testing"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+)
+
+func TestMemory(t *testing.T) {
+	Run(t, func(t *testing.T) (incrmntr.Backend, func()) {
+		return NewMemory(), func() {}
+	})
+}
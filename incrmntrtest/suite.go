@@ -0,0 +1,597 @@
+// Package incrmntrtest is the conformance suite of the incrementer
+// backends, every backend runs it to behave the same way:
+//
+//	func TestConformance(t *testing.T) {
+//		incrmntrtest.Run(t, func(t *testing.T) (incrmntr.Backend, func()) {
+//			srv, err := redistest.NewServer()
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			backend, err := redis.New(context.Background(), srv.Addr())
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			return backend, func() { srv.Close() }
+//		})
+//	}
+//
+// The suite runs on the Backend implementations only, the Couchbase bucket
+// of New isn't a Backend, so it's covered by the tests of the root package
+// against a Couchbase server instead.
+//
+// The package holds the Memory backend too, the reference implementation
+// of the Backend for the tests of the code built on the incrementer.
+package incrmntrtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+)
+
+// Factory creates an empty backend for a test, the backend is closed by
+// the incrementer of the test and then the returned function is called to
+// release the rest (e.g. the server of the backend)
+type Factory func(t *testing.T) (incrmntr.Backend, func())
+
+// expiry is the expiry of the keys in the expiry tests
+const expiry = 100 * time.Millisecond
+
+// Run runs the conformance tests on the backends of the factory
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, factory Factory)
+	}{
+		{"Sequential", testSequential},
+		{"RolloverBoundary", testRolloverBoundary},
+		{"Step", testStep},
+		{"WithoutCycle", testWithoutCycle},
+		{"CustomRollover", testCustomRollover},
+		{"GetSetReset", testGetSetReset},
+		{"ConcurrentUniqueness", testConcurrentUniqueness},
+		{"Coalescing", testCoalescing},
+		{"BatchCrossing", testBatchCrossing},
+		{"ConcurrentKeys", testConcurrentKeys},
+		{"InitRace", testInitRace},
+		{"Idempotent", testIdempotent},
+		{"Expiry", testExpiry},
+		{"LockExpiry", testLockExpiry},
+		{"ContextDone", testContextDone},
+		{"Close", testClose},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.fn(t, factory)
+		})
+	}
+}
+
+// newIncrementer creates the incrementer on a new backend of the factory,
+// the returned function closes both of them
+func newIncrementer(t *testing.T, factory Factory, rollover uint64, initial int64, inc uint64, cycle bool) (*incrmntr.Incrementer, func()) {
+	backend, release := factory(t)
+	i, err := incrmntr.NewWithBackend(backend, rollover, initial, inc, cycle)
+	if err != nil {
+		release()
+		t.Fatal(err)
+	}
+
+	return i.(*incrmntr.Incrementer), func() {
+		if err := i.Close(); err != nil {
+			t.Errorf("close: %v", err)
+		}
+		release()
+	}
+}
+
+// expectAdds checks the values of the AddSafe calls in order
+func expectAdds(t *testing.T, inc *incrmntr.Incrementer, key string, expected []int64) {
+	t.Helper()
+
+	for k, e := range expected {
+		v, err := inc.AddSafe(key)
+		if err != nil {
+			t.Fatalf("add %d: %v", k, err)
+		}
+		if !v.Valid || v.Value != e {
+			t.Fatalf("add %d should be %d, instead of %+v", k, e, v)
+		}
+	}
+}
+
+func testSequential(t *testing.T, factory Factory) {
+	inc, closeAll := newIncrementer(t, factory, 999, 1, 1, true)
+	defer closeAll()
+
+	var expected []int64
+	for k := int64(1); k <= 100; k++ {
+		expected = append(expected, k)
+	}
+	expectAdds(t, inc, "sequential", expected)
+
+	v, err := inc.Get("sequential")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 100 {
+		t.Errorf("value should be 100, instead of %d", v)
+	}
+}
+
+func testRolloverBoundary(t *testing.T, factory Factory) {
+	inc, closeAll := newIncrementer(t, factory, 3, 1, 1, true)
+	defer closeAll()
+
+	// ---- the rollover itself is a valid value, the next one cycles back
+	expectAdds(t, inc, "boundary", []int64{1, 2, 3, 1, 2, 3, 1})
+}
+
+func testStep(t *testing.T, factory Factory) {
+	inc, closeAll := newIncrementer(t, factory, 10, 0, 4, true)
+	defer closeAll()
+
+	// ---- a step jumping over the rollover cycles back
+	expectAdds(t, inc, "step", []int64{0, 4, 8, 0, 4})
+}
+
+func testWithoutCycle(t *testing.T, factory Factory) {
+	inc, closeAll := newIncrementer(t, factory, 3, 1, 1, false)
+	defer closeAll()
+
+	expectAdds(t, inc, "nocycle", []int64{1, 2, 3, 4, 5})
+}
+
+func testCustomRollover(t *testing.T, factory Factory) {
+	inc, closeAll := newIncrementer(t, factory, 999, 1, 1, true)
+	defer closeAll()
+
+	var values []int64
+	for k := 0; k < 4; k++ {
+		v, err := inc.AddSafeWithRollover("custom", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, v.Value)
+	}
+	if fmt.Sprint(values) != fmt.Sprint([]int64{1, 2, 1, 2}) {
+		t.Errorf("values should be [1 2 1 2], instead of %v", values)
+	}
+
+	// ---- a rollover beyond int64 never cycles
+	if err := inc.Set("custom", math.MaxInt64-1); err != nil {
+		t.Fatal(err)
+	}
+	v, err := inc.AddSafeWithRollover("custom", math.MaxUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Value != math.MaxInt64 {
+		t.Errorf("value should be %d, instead of %d", int64(math.MaxInt64), v.Value)
+	}
+}
+
+func testGetSetReset(t *testing.T, factory Factory) {
+	inc, closeAll := newIncrementer(t, factory, 999, 5, 1, true)
+	defer closeAll()
+
+	if _, err := inc.Get("missing"); !errors.Is(err, incrmntr.ErrKeyNotFound) {
+		t.Errorf("error of the missing key should be ErrKeyNotFound, instead of %v", err)
+	}
+
+	if err := inc.Set("key", 100); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := inc.Get("key"); err != nil || v != 100 {
+		t.Errorf("value should be 100, instead of %d (%v)", v, err)
+	}
+	expectAdds(t, inc, "key", []int64{101})
+
+	if err := inc.Reset("key"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := inc.Get("key"); err != nil || v != 5 {
+		t.Errorf("value should be 5 after reset, instead of %d (%v)", v, err)
+	}
+	expectAdds(t, inc, "key", []int64{6})
+}
+
+func testConcurrentUniqueness(t *testing.T, factory Factory) {
+	inc, closeAll := newIncrementer(t, factory, 0, 1, 1, false)
+	defer closeAll()
+
+	expectUnique(t, inc)
+}
+
+func testCoalescing(t *testing.T, factory Factory) {
+	inc, closeAll := newIncrementer(t, factory, 0, 1, 1, false)
+	defer closeAll()
+	inc.SetCoalescing(8)
+
+	// ---- the batches give the same values as the single adds
+	expectUnique(t, inc)
+	if v, err := inc.Get("concurrent"); err != nil || v != 500 {
+		t.Errorf("value should be 500, instead of %d (%v)", v, err)
+	}
+}
+
+func testBatchCrossing(t *testing.T, factory Factory) {
+	backend, release := factory(t)
+	defer release()
+	defer backend.Close()
+	ctx := context.Background()
+
+	if _, err := backend.Init(ctx, "crossing", 7, 0); err != nil {
+		t.Fatal(err)
+	}
+	op := incrmntr.AddOp{Delta: 5, Step: 1, Rollover: 10, Initial: 1, Cycle: true}
+
+	// ---- the batch crossing the rollover isn't applied
+	_, _, err := backend.Add(ctx, "crossing", op)
+	var crossing *incrmntr.CrossingError
+	if !errors.As(err, &crossing) || crossing.Current != 7 {
+		t.Fatalf("batch should cross the rollover at 7, instead of %v", err)
+	}
+	if v, err := backend.Get(ctx, "crossing"); err != nil || v != 7 {
+		t.Fatalf("value should stay 7, instead of %d (%v)", v, err)
+	}
+
+	// ---- the batch fitting below the rollover is applied
+	op.Delta = 3
+	if v, rolled, err := backend.Add(ctx, "crossing", op); err != nil || v != 10 || rolled {
+		t.Fatalf("value should be 10, instead of %d %v (%v)", v, rolled, err)
+	}
+}
+
+// expectUnique calls AddSafe concurrently, every value
+// has to be given once, without gaps
+func expectUnique(t *testing.T, inc *incrmntr.Incrementer) {
+	const workers, adds = 20, 25
+	values := make(chan int64, workers*adds)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < adds; k++ {
+				v, err := inc.AddSafe("concurrent")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				values <- v.Value
+			}
+		}()
+	}
+	wg.Wait()
+	close(values)
+
+	// ---- every value is given once, without gaps
+	var sorted []int64
+	for v := range values {
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a] < sorted[b] })
+	if len(sorted) != workers*adds {
+		t.Fatalf("should have %d values, instead of %d", workers*adds, len(sorted))
+	}
+	for k, v := range sorted {
+		if v != int64(k+1) {
+			t.Fatalf("value %d should be %d, instead of %d", k, k+1, v)
+		}
+	}
+}
+
+func testConcurrentKeys(t *testing.T, factory Factory) {
+	inc, closeAll := newIncrementer(t, factory, 999, 1, 1, true)
+	defer closeAll()
+
+	// ---- the flows of behaviour_test.go, sharing a common key
+	var flows = [][]string{
+		{"flow1-a", "flow1-b", "common"},
+		{"flow2-a", "flow2-b", "common"},
+	}
+	const workers = 25
+	var wg sync.WaitGroup
+	for _, keys := range flows {
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(keys []string) {
+				defer wg.Done()
+				for _, key := range keys {
+					if _, err := inc.AddSafe(key); err != nil {
+						t.Error(err)
+					}
+				}
+			}(keys)
+		}
+	}
+	wg.Wait()
+
+	var expected = map[string]int64{
+		"flow1-a": workers, "flow1-b": workers,
+		"flow2-a": workers, "flow2-b": workers,
+		"common": 2 * workers,
+	}
+	for key, e := range expected {
+		if v, err := inc.Get(key); err != nil || v != e {
+			t.Errorf("value of %s should be %d, instead of %d (%v)", key, e, v, err)
+		}
+	}
+}
+
+func testInitRace(t *testing.T, factory Factory) {
+	backend, release := factory(t)
+	defer release()
+	defer backend.Close()
+
+	// ---- exactly one of the concurrent inits creates the key
+	const workers = 20
+	var created int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			ok, err := backend.Init(context.Background(), "init", int64(w), 0)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+	if created != 1 {
+		t.Errorf("one init should create the key, instead of %d", created)
+	}
+
+	// ---- the existing key isn't overwritten
+	if err := backend.Set(context.Background(), "init", 42, 0); err != nil {
+		t.Fatal(err)
+	}
+	ok, err := backend.Init(context.Background(), "init", 1, 0)
+	if err != nil || ok {
+		t.Errorf("init of the existing key should be false, instead of %v (%v)", ok, err)
+	}
+	if v, err := backend.Get(context.Background(), "init"); err != nil || v != 42 {
+		t.Errorf("value should be 42, instead of %d (%v)", v, err)
+	}
+}
+
+func testIdempotent(t *testing.T, factory Factory) {
+	inc, closeAll := newIncrementer(t, factory, 999, 1, 1, true)
+	defer closeAll()
+	if _, err := inc.AddIdempotent("idempotent", "probe"); errors.Is(err, incrmntr.ErrNotIdempotent) {
+		t.Skip(err)
+	}
+
+	// ---- the first request created the key, the retries return its value
+	for k := 0; k < 3; k++ {
+		v, err := inc.AddIdempotent("idempotent", "probe")
+		if err != nil || v.Value != 1 {
+			t.Fatalf("retry of the request should be 1, instead of %d (%v)", v.Value, err)
+		}
+	}
+	if v, err := inc.AddIdempotent("idempotent", "next"); err != nil || v.Value != 2 {
+		t.Fatalf("new request should be 2, instead of %d (%v)", v.Value, err)
+	}
+
+	// ---- the concurrent attempts of a request increment once
+	const workers = 20
+	values := make(chan int64, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := inc.AddIdempotent("idempotent", "concurrent")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			values <- v.Value
+		}()
+	}
+	wg.Wait()
+	close(values)
+	for v := range values {
+		if v != 3 {
+			t.Errorf("attempts of the request should be 3, instead of %d", v)
+		}
+	}
+	if v, err := inc.Get("idempotent"); err != nil || v != 3 {
+		t.Errorf("value should be 3, instead of %d (%v)", v, err)
+	}
+}
+
+func testExpiry(t *testing.T, factory Factory) {
+	inc, closeAll := newIncrementer(t, factory, 999, 1, 1, true)
+	defer closeAll()
+	inc.SetExpiry(expiry)
+
+	expectAdds(t, inc, "expiry", []int64{1, 2})
+
+	// ---- every write refreshes the expiry
+	time.Sleep(expiry / 2)
+	expectAdds(t, inc, "expiry", []int64{3})
+	time.Sleep(expiry / 2)
+	if v, err := inc.Get("expiry"); err != nil || v != 3 {
+		t.Fatalf("value should be 3 before the expiry, instead of %d (%v)", v, err)
+	}
+
+	// ---- the expired key starts again from the initial value
+	time.Sleep(2 * expiry)
+	if _, err := inc.Get("expiry"); !errors.Is(err, incrmntr.ErrKeyNotFound) {
+		t.Errorf("error of the expired key should be ErrKeyNotFound, instead of %v", err)
+	}
+	expectAdds(t, inc, "expiry", []int64{1})
+}
+
+func testLockExpiry(t *testing.T, factory Factory) {
+	backend, release := factory(t)
+	defer release()
+	locking := &lockingBackend{Backend: backend}
+	i, err := incrmntr.NewWithBackend(locking, 999, 1, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inc := i.(*incrmntr.Incrementer)
+	defer inc.Close()
+	expectAdds(t, inc, "lock", []int64{1})
+
+	// ---- the lock of a crashed holder rejects the writes until it
+	// expires, AddSafe retries them and adds once after the expiry
+	locked := time.Now()
+	locking.lock(expiry)
+	if v, err := inc.Get("lock"); err != nil || v != 1 {
+		t.Fatalf("the locked key should be readable, instead of %d (%v)", v, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*expiry)
+	defer cancel()
+	v, err := inc.AddSafeContext(ctx, "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Value != 2 {
+		t.Errorf("value should be 2 after the lock expired, instead of %d", v.Value)
+	}
+	if elapsed := time.Since(locked); elapsed < expiry {
+		t.Errorf("add should wait for the lock expiry, instead of %s", elapsed)
+	}
+	if locking.rejected() == 0 {
+		t.Error("adds should be rejected while the key is locked")
+	}
+	expectAdds(t, inc, "lock", []int64{3})
+}
+
+// lockingBackend holds a lock rejecting the adds and the sets with
+// incrmntr.ErrConflict until the lock expires
+type lockingBackend struct {
+	incrmntr.Backend
+
+	mu      sync.Mutex
+	until   time.Time
+	rejects int
+}
+
+func (b *lockingBackend) lock(ttl time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.until = time.Now().Add(ttl)
+}
+
+func (b *lockingBackend) locked() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if time.Now().Before(b.until) {
+		b.rejects++
+		return true
+	}
+	return false
+}
+
+func (b *lockingBackend) rejected() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.rejects
+}
+
+func (b *lockingBackend) Add(ctx context.Context, key string, op incrmntr.AddOp) (int64, bool, error) {
+	if b.locked() {
+		return 0, false, incrmntr.ErrConflict
+	}
+	return b.Backend.Add(ctx, key, op)
+}
+
+func (b *lockingBackend) Set(ctx context.Context, key string, value int64, expiry time.Duration) error {
+	if b.locked() {
+		return incrmntr.ErrConflict
+	}
+	return b.Backend.Set(ctx, key, value, expiry)
+}
+
+func testContextDone(t *testing.T, factory Factory) {
+	inc, closeAll := newIncrementer(t, factory, 999, 1, 1, true)
+	defer closeAll()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := inc.AddSafeContext(ctx, "ctx"); !errors.Is(err, context.Canceled) {
+		t.Errorf("error should be context.Canceled, instead of %v", err)
+	}
+	if _, err := inc.Get("ctx"); !errors.Is(err, incrmntr.ErrKeyNotFound) {
+		t.Errorf("the canceled add should not create the key, instead of %v", err)
+	}
+}
+
+func testClose(t *testing.T, factory Factory) {
+	backend, release := factory(t)
+	defer release()
+	counted := &closeCounter{Backend: backend}
+	i, err := incrmntr.NewWithBackend(counted, 999, 1, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inc := i.(*incrmntr.Incrementer)
+	expectAdds(t, inc, "close", []int64{1})
+
+	// ---- Close is idempotent and closes the backend once
+	for k := 0; k < 2; k++ {
+		if err := inc.Close(); err != nil {
+			t.Errorf("close %d: %v", k, err)
+		}
+	}
+	if counted.count() != 1 {
+		t.Errorf("backend should be closed once, instead of %d", counted.count())
+	}
+
+	if _, err := inc.AddSafe("close"); !errors.Is(err, incrmntr.ErrClosed) {
+		t.Errorf("error after close should be ErrClosed, instead of %v", err)
+	}
+	if _, err := inc.Get("close"); !errors.Is(err, incrmntr.ErrClosed) {
+		t.Errorf("error after close should be ErrClosed, instead of %v", err)
+	}
+	if err := inc.Set("close", 1); !errors.Is(err, incrmntr.ErrClosed) {
+		t.Errorf("error after close should be ErrClosed, instead of %v", err)
+	}
+}
+
+// closeCounter counts the Close calls of the backend
+type closeCounter struct {
+	incrmntr.Backend
+
+	mu     sync.Mutex
+	closes int
+}
+
+func (c *closeCounter) Close() error {
+	c.mu.Lock()
+	c.closes++
+	c.mu.Unlock()
+
+	return c.Backend.Close()
+}
+
+func (c *closeCounter) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closes
+}
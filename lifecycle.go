@@ -0,0 +1,137 @@
+package incrmntr
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// ErrClosed returned by the calls started after Close
+var ErrClosed = errors.New("incrementer is closed")
+
+// lifecycle tracks the in-flight calls of the Incrementer,
+// so Close can wait for them before releasing the storage
+type lifecycle struct {
+	mu       sync.Mutex
+	closed   bool
+	released bool
+	done     chan struct{}
+	inflight sync.WaitGroup
+}
+
+// Connect opens the cluster and creates the Incrementer on the bucket,
+// the cluster closed by Close of the Incrementer
+func Connect(address string, opts gocb.ClusterOptions, bucket string, rollover uint64, initial int64, inc uint64, cycle bool) (*Incrementer, error) {
+	cluster, err := gocb.Connect(address, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	i, _ := New(cluster.Bucket(bucket), rollover, initial, inc, cycle)
+	i.(*Incrementer).cluster = cluster
+
+	return i.(*Incrementer), nil
+}
+
+// acquire registers an in-flight call, it fails with
+// ErrClosed after Close called
+func (i *Incrementer) acquire() error {
+	i.life.mu.Lock()
+	defer i.life.mu.Unlock()
+
+	if i.life.closed {
+		return ErrClosed
+	}
+	i.life.inflight.Add(1)
+
+	return nil
+}
+
+// release marks the in-flight call as done
+func (i *Incrementer) release() {
+	i.life.inflight.Done()
+}
+
+// closing returns the channel closed by Close
+func (i *Incrementer) closing() <-chan struct{} {
+	i.life.mu.Lock()
+	defer i.life.mu.Unlock()
+
+	if i.life.done == nil {
+		i.life.done = make(chan struct{})
+	}
+	return i.life.done
+}
+
+// Close rejects the new calls, waits for the in-flight ones and closes
+// the cluster if it was opened by Connect or the backend if it's set
+func (i *Incrementer) Close() error {
+	return i.CloseContext(context.Background())
+}
+
+// CloseContext is Close bound by the context, the cluster is left
+// open if the in-flight calls didn't finish before the context done.
+// Calling it again waits for the in-flight calls again.
+func (i *Incrementer) CloseContext(ctx context.Context) error {
+	i.life.mu.Lock()
+	if !i.life.closed {
+		if i.life.done == nil {
+			i.life.done = make(chan struct{})
+		}
+		i.life.closed = true
+		close(i.life.done)
+	}
+	i.life.mu.Unlock()
+
+	// ---- wait for the in-flight calls
+	drained := make(chan struct{})
+	go func() {
+		i.life.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	i.life.mu.Lock()
+	defer i.life.mu.Unlock()
+	if i.backend != nil && !i.life.released {
+		i.life.released = true
+		return i.backend.Close()
+	}
+	if i.cluster != nil {
+		cluster := i.cluster
+		i.cluster = nil
+		return cluster.Close(nil)
+	}
+
+	return nil
+}
+
+// Closed reports whether Close was called
+func (i *Incrementer) Closed() bool {
+	i.life.mu.Lock()
+	defer i.life.mu.Unlock()
+
+	return i.life.closed
+}
+
+// untilClosed returns a context canceled by Close too, it's used
+// by the long running operations like Watch
+func (i *Incrementer) untilClosed(ctx context.Context) (context.Context, context.CancelFunc) {
+	done := i.closing()
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer cancel()
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
@@ -0,0 +1,121 @@
+package incrmntr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// blockingChangeStream sends the value then holds the channel open until the context done
+type blockingChangeStream struct {
+	value int64
+}
+
+func (s blockingChangeStream) Changes(ctx context.Context, key string) (<-chan int64, error) {
+	values := make(chan int64)
+	go func() {
+		defer close(values)
+		select {
+		case values <- s.value:
+		case <-ctx.Done():
+			return
+		}
+		<-ctx.Done()
+	}()
+	return values, nil
+}
+
+func TestCloseRejectsCalls(t *testing.T) {
+	inc := &Incrementer{timeout: time.Second}
+	if err := inc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := inc.Close(); err != nil {
+		t.Errorf("Close should be idempotent, instead of %v", err)
+	}
+	if !inc.Closed() {
+		t.Error("Closed should be true after Close")
+	}
+
+	if _, err := inc.Get("key"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Get should fail with ErrClosed, instead of %v", err)
+	}
+	if _, err := inc.AddSafe("key"); !errors.Is(err, ErrClosed) {
+		t.Errorf("AddSafe should fail with ErrClosed, instead of %v", err)
+	}
+	if err := inc.Reset("key"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Reset should fail with ErrClosed, instead of %v", err)
+	}
+	if _, err := inc.Txn().Incr("key", 1).Commit(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Commit should fail with ErrClosed, instead of %v", err)
+	}
+	if _, err := inc.Ping(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("Ping should fail with ErrClosed, instead of %v", err)
+	}
+	if _, err := inc.Watch(context.Background(), "key"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Watch should fail with ErrClosed, instead of %v", err)
+	}
+}
+
+func TestCloseWaitsForInFlight(t *testing.T) {
+	inc := &Incrementer{timeout: time.Second}
+	if err := inc.acquire(); err != nil {
+		t.Fatal(err)
+	}
+
+	// ---- the in-flight call holds the close until the context done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := inc.CloseContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close should time out with in-flight call, instead of %v", err)
+	}
+	if err := inc.acquire(); !errors.Is(err, ErrClosed) {
+		t.Errorf("New calls should be rejected while closing, instead of %v", err)
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- inc.Close()
+	}()
+	select {
+	case err := <-closed:
+		t.Fatalf("Close should wait for the in-flight call, returned %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	inc.release()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close should return after the in-flight call finished")
+	}
+}
+
+func TestCloseStopsWatch(t *testing.T) {
+	inc := &Incrementer{}
+	inc.SetChangeStream(blockingChangeStream{value: 7})
+
+	changes, err := inc.Watch(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change := <-changes; change.Value != 7 {
+		t.Errorf("Change should be 7, instead of %d", change.Value)
+	}
+	if err := inc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case _, ok := <-changes:
+		if ok {
+			t.Error("Changes should be closed after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Watch should stop after Close")
+	}
+}
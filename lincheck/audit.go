@@ -0,0 +1,150 @@
+package lincheck
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+)
+
+// AuditStats are the counts of the Audit
+type AuditStats struct {
+	// Checked is the number of the checked windows
+	Checked int
+
+	// Skipped is the number of the windows dropped without check,
+	// because the key was never idle or the search reached the limit
+	Skipped int
+
+	// Violations is the number of the windows failed the check
+	Violations int
+}
+
+// Audit checks the calls of the running incrementer in windows, a window
+// of a key is checked when it's full and the key is idle (no call in
+// progress), with unknown value at its start. The audit assumes the
+// process is the only writer of the keys, the writes of the others
+// look like violations.
+type Audit struct {
+	model       Model
+	window      int
+	onViolation func(Result)
+	clock       clock
+
+	mu    sync.Mutex
+	keys  map[string]*auditKey
+	stats AuditStats
+	wg    sync.WaitGroup
+}
+
+// auditKey is the open window of a key, cut is the time of the
+// last window dropped while calls were in progress
+type auditKey struct {
+	pending int
+	ops     []Operation
+	cut     uint64
+}
+
+// errCut marks the calls in progress at the drop of the window, they may
+// have taken effect before the dropped calls or after the cut, so only
+// whether they were done is unknown, their results are ignored
+var errCut = errors.New("error call overlapped a dropped window")
+
+// NewAudit creates the audit with the size of the windows, the failed
+// checks are reported to onViolation from an other goroutine
+func NewAudit(model Model, window int, onViolation func(Result)) *Audit {
+	if window < 1 {
+		window = 100
+	}
+
+	return &Audit{
+		model:       model,
+		window:      window,
+		onViolation: onViolation,
+		keys:        make(map[string]*auditKey),
+	}
+}
+
+// Middleware audits the calls of the wrapped incrementer
+func (a *Audit) Middleware() incrmntr.Middleware {
+	return func(next incrmntr.Incrmntr) incrmntr.Incrmntr {
+		return recorded{Base: incrmntr.NewBase(next), sink: a, model: a.model}
+	}
+}
+
+// Wait waits for the running checks
+func (a *Audit) Wait() {
+	a.wg.Wait()
+}
+
+// Stats returns the counts of the audit
+func (a *Audit) Stats() AuditStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.stats
+}
+
+func (a *Audit) invoke(key string) uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	k, ok := a.keys[key]
+	if !ok {
+		k = &auditKey{}
+		a.keys[key] = k
+	}
+	k.pending++
+
+	return a.clock.tick()
+}
+
+func (a *Audit) complete(op Operation) {
+	op.Return = a.clock.tick()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	k := a.keys[op.Key]
+	k.pending--
+	if op.Call < k.cut && op.Err == nil {
+		op.Err = errCut
+	}
+	k.ops = append(k.ops, op)
+
+	switch {
+	case k.pending == 0 && len(k.ops) >= a.window:
+		ops := k.ops
+		delete(a.keys, op.Key)
+		a.wg.Add(1)
+		go a.check(ops)
+	case len(k.ops) >= 4*a.window:
+		// ---- the key is never idle, the window can't be cut
+		k.ops = nil
+		k.cut = a.clock.tick()
+		a.stats.Skipped++
+	}
+}
+
+// check checks the window and reports the violation
+func (a *Audit) check(ops []Operation) {
+	defer a.wg.Done()
+
+	res := check(a.model, ops, unknown, DefaultMaxStates)
+
+	a.mu.Lock()
+	switch {
+	case res.OK:
+		a.stats.Checked++
+	case res.Unknown:
+		a.stats.Skipped++
+	default:
+		a.stats.Checked++
+		a.stats.Violations++
+	}
+	a.mu.Unlock()
+
+	if !res.OK && !res.Unknown && a.onViolation != nil {
+		a.onViolation(res)
+	}
+}
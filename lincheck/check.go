@@ -0,0 +1,152 @@
+package lincheck
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultMaxStates is the default limit of the explored states of a key
+const DefaultMaxStates = 1000000
+
+// Result is the result of a Check
+type Result struct {
+	// OK is true if the history is linearizable
+	OK bool
+
+	// Unknown is true if the search stopped at the limit of the states
+	Unknown bool
+
+	// Key is the first key failed the check and History is its history
+	Key     string
+	History []Operation
+}
+
+// Check checks whether the history is linearizable with the keys
+// missing at the start of the history
+func Check(model Model, history []Operation) Result {
+	return check(model, history, missing, DefaultMaxStates)
+}
+
+// CheckFrom checks the history with unknown values of the keys at the
+// start, e.g. a window of the history of a running counter
+func CheckFrom(model Model, history []Operation) Result {
+	return check(model, history, unknown, DefaultMaxStates)
+}
+
+// check checks the keys separately, the history of the counter is
+// linearizable if the history of every key is
+func check(model Model, history []Operation, initial state, maxStates int) Result {
+	keys := make(map[string][]Operation)
+	var order []string
+	for _, op := range history {
+		if op.Kind == KindGet && op.Err != nil {
+			// ---- the failed reads don't change the state
+			continue
+		}
+		if _, ok := keys[op.Key]; !ok {
+			order = append(order, op.Key)
+		}
+		keys[op.Key] = append(keys[op.Key], op)
+	}
+
+	for _, key := range order {
+		ok, complete := search(model, keys[key], initial, maxStates)
+		if !ok {
+			return Result{Unknown: !complete, Key: key, History: keys[key]}
+		}
+	}
+
+	return Result{OK: true}
+}
+
+// search looks for a linearization of the history of a key with depth
+// first search, the visited pairs of linearized operations and states
+// are cached. The ambiguous operations can be left out of the
+// linearization, they don't bound the others by their return.
+func search(model Model, ops []Operation, initial state, maxStates int) (ok bool, complete bool) {
+	ops = append([]Operation(nil), ops...)
+	sort.SliceStable(ops, func(a, b int) bool { return ops[a].Call < ops[b].Call })
+
+	returns := make([]uint64, len(ops))
+	required := 0
+	for k, op := range ops {
+		returns[k] = op.Return
+		if op.ambiguous() {
+			returns[k] = math.MaxUint64
+		} else {
+			required++
+		}
+	}
+
+	done := make([]bool, len(ops))
+	bits := make([]byte, (len(ops)+7)/8)
+	visited := make(map[string]struct{})
+
+	var dfs func(s state, linearized int) bool
+	dfs = func(s state, linearized int) bool {
+		if linearized == required {
+			return true
+		}
+		if len(visited) >= maxStates {
+			return false
+		}
+		key := string(bits) + stateKey(s)
+		if _, ok := visited[key]; ok {
+			return false
+		}
+		visited[key] = struct{}{}
+
+		// ---- an operation can be the next one if it started before
+		// the end of every remaining operation
+		minReturn := uint64(math.MaxUint64)
+		for k := range ops {
+			if !done[k] && returns[k] < minReturn {
+				minReturn = returns[k]
+			}
+		}
+		for k, op := range ops {
+			if op.Call > minReturn {
+				break
+			}
+			if done[k] {
+				continue
+			}
+			next, valid := model.step(s, op)
+			if !valid {
+				continue
+			}
+
+			done[k] = true
+			bits[k/8] |= 1 << uint(k%8)
+			count := linearized
+			if !op.ambiguous() {
+				count++
+			}
+			if dfs(next, count) {
+				return true
+			}
+			done[k] = false
+			bits[k/8] &^= 1 << uint(k%8)
+		}
+		return false
+	}
+
+	ok = dfs(initial, 0)
+	return ok, ok || len(visited) < maxStates
+}
+
+// stateKey encodes the state for the cache
+func stateKey(s state) string {
+	var b [10]byte
+	if s.known {
+		b[0] = 1
+	}
+	if s.exists {
+		b[1] = 1
+	}
+	v := uint64(s.value)
+	for k := 0; k < 8; k++ {
+		b[2+k] = byte(v >> (8 * uint(k)))
+	}
+	return string(b[:])
+}
@@ -0,0 +1,194 @@
+package lincheck
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+	"github.com/PumpkinSeed/incrmntr/v2/fault"
+	"github.com/PumpkinSeed/incrmntr/v2/incrmntrtest"
+)
+
+var testModel = Model{Rollover: 3, Initial: 1, Step: 1, Cycle: true}
+
+func add(call, ret uint64, value int64) Operation {
+	return Operation{Kind: KindAdd, Key: "key", Rollover: testModel.Rollover, Value: value, Call: call, Return: ret}
+}
+
+func TestCheck(t *testing.T) {
+	var errLost = errors.New("lost")
+	var cases = []struct {
+		name    string
+		history []Operation
+		ok      bool
+	}{
+		{"sequential", []Operation{add(1, 2, 1), add(3, 4, 2), add(5, 6, 3), add(7, 8, 1)}, true},
+		{"concurrent", []Operation{add(1, 6, 2), add(2, 3, 1), add(4, 5, 3)}, true},
+		{"duplicate", []Operation{add(1, 3, 1), add(2, 4, 1)}, false},
+		{"reordered", []Operation{add(1, 2, 2), add(3, 4, 1)}, false},
+		{"skipped", []Operation{add(1, 2, 1), add(3, 4, 3)}, false},
+		{"stale read", []Operation{
+			add(1, 2, 1), add(3, 4, 2),
+			{Kind: KindGet, Key: "key", Value: 1, Found: true, Call: 5, Return: 6},
+		}, false},
+		{"concurrent read", []Operation{
+			add(1, 2, 1), add(3, 6, 2),
+			{Kind: KindGet, Key: "key", Value: 1, Found: true, Call: 4, Return: 5},
+		}, true},
+		{"missing read", []Operation{{Kind: KindGet, Key: "key", Call: 1, Return: 2}, add(3, 4, 1)}, true},
+		{"set", []Operation{
+			add(1, 2, 1), {Kind: KindSet, Key: "key", Value: 3, Call: 3, Return: 4}, add(5, 6, 1),
+		}, true},
+		{"lost reply applied", []Operation{
+			add(1, 2, 1), {Kind: KindAdd, Key: "key", Rollover: 3, Err: errLost, Call: 3, Return: 4}, add(5, 6, 3),
+		}, true},
+		{"lost reply not applied", []Operation{
+			add(1, 2, 1), {Kind: KindAdd, Key: "key", Rollover: 3, Err: errLost, Call: 3, Return: 4}, add(5, 6, 2),
+		}, true},
+		{"custom rollover", []Operation{
+			add(1, 2, 1), {Kind: KindAdd, Key: "key", Rollover: 1, Value: 1, Call: 3, Return: 4},
+		}, true},
+	}
+	for _, c := range cases {
+		res := Check(testModel, c.history)
+		if res.OK != c.ok {
+			t.Errorf("%s should be %v, instead of %+v", c.name, c.ok, res)
+		}
+	}
+}
+
+func TestCheckSeparateKeys(t *testing.T) {
+	history := []Operation{
+		add(1, 2, 1),
+		{Kind: KindAdd, Key: "other", Rollover: 3, Value: 1, Call: 3, Return: 4},
+		add(5, 6, 2),
+		{Kind: KindAdd, Key: "other", Rollover: 3, Value: 1, Call: 7, Return: 8},
+	}
+	res := Check(testModel, history)
+	if res.OK || res.Key != "other" || len(res.History) != 2 {
+		t.Errorf("other key should fail, instead of %+v", res)
+	}
+}
+
+// racy is a broken backend with a read and a later write in the add
+type racy struct {
+	*incrmntrtest.Memory
+}
+
+func (r racy) Add(ctx context.Context, key string, op incrmntr.AddOp) (int64, bool, error) {
+	current, err := r.Memory.Get(ctx, key)
+	if err != nil {
+		return 0, false, incrmntr.ErrConflict
+	}
+	time.Sleep(time.Millisecond)
+	value, rolled := op.Apply(current)
+
+	return value, rolled, r.Memory.Set(ctx, key, value, op.Expiry)
+}
+
+// run calls the incrementer concurrently
+func run(t *testing.T, inc incrmntr.Incrmntr) {
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for k := 0; k < 20; k++ {
+				switch {
+				case k%5 == 4:
+					_, _ = inc.Get("key")
+				case w == 0 && k == 10:
+					_ = inc.Set("key", 100)
+				default:
+					_, _ = inc.AddSafe("key")
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+func TestRecorder(t *testing.T) {
+	model := Model{Rollover: 50, Initial: 1, Step: 1, Cycle: true}
+	backends := map[string]incrmntr.Backend{
+		"memory": incrmntrtest.NewMemory(),
+		"lost replies": fault.Wrap(incrmntrtest.NewMemory(), 1,
+			fault.WithLostReplies(fault.OpAdd, 0.1),
+			fault.WithLatency(fault.OpAll, 0, time.Millisecond),
+		),
+	}
+	for name, backend := range backends {
+		inc, _ := incrmntr.NewWithBackend(backend, model.Rollover, model.Initial, model.Step, model.Cycle)
+		recorder := NewRecorder()
+		run(t, recorder.Wrap(inc, model))
+
+		if res := Check(model, recorder.History()); !res.OK {
+			t.Errorf("history of %s should be linearizable, instead of %+v", name, res)
+		}
+	}
+
+	inc, _ := incrmntr.NewWithBackend(racy{incrmntrtest.NewMemory()}, model.Rollover, model.Initial, model.Step, model.Cycle)
+	recorder := NewRecorder()
+	run(t, recorder.Wrap(inc, model))
+	if res := Check(model, recorder.History()); res.OK {
+		t.Error("history of the racy backend should not be linearizable")
+	}
+}
+
+func TestAudit(t *testing.T) {
+	model := Model{Step: 1, Initial: 1}
+
+	inc, _ := incrmntr.NewWithBackend(incrmntrtest.NewMemory(), 0, 1, 1, false)
+	audit := NewAudit(model, 10, func(res Result) {
+		t.Errorf("violation: %+v", res)
+	})
+	run(t, audit.Middleware()(inc))
+	audit.Wait()
+	if stats := audit.Stats(); stats.Checked == 0 || stats.Violations != 0 {
+		t.Errorf("windows should be checked without violation, instead of %+v", stats)
+	}
+
+	inc, _ = incrmntr.NewWithBackend(racy{incrmntrtest.NewMemory()}, 0, 1, 1, false)
+	var mu sync.Mutex
+	var violations []Result
+	audit = NewAudit(model, 10, func(res Result) {
+		mu.Lock()
+		violations = append(violations, res)
+		mu.Unlock()
+	})
+	run(t, audit.Middleware()(inc))
+	audit.Wait()
+	if stats := audit.Stats(); stats.Violations == 0 || len(violations) != stats.Violations {
+		t.Errorf("violations should be reported, instead of %+v", stats)
+	}
+}
+
+func TestAuditNeverIdle(t *testing.T) {
+	model := Model{Step: 1, Initial: 1}
+	audit := NewAudit(model, 2, func(res Result) {
+		t.Errorf("violation: %+v", res)
+	})
+	addOp := func(call uint64, value int64) {
+		audit.complete(Operation{Kind: KindAdd, Key: "key", Rollover: model.Rollover, Value: value, Call: call})
+	}
+
+	// ---- the first slow add takes effect before the dropped adds,
+	// the second one between the adds of the next window
+	first := audit.invoke("key")
+	second := audit.invoke("key")
+	for v := int64(2); v <= 9; v++ {
+		addOp(audit.invoke("key"), v)
+	}
+	addOp(audit.invoke("key"), 10)
+	addOp(audit.invoke("key"), 12)
+	addOp(second, 11)
+	addOp(first, 1)
+
+	audit.Wait()
+	if stats := audit.Stats(); stats.Skipped != 1 || stats.Checked != 1 || stats.Violations != 0 {
+		t.Errorf("window after the drop should be checked, instead of %+v", stats)
+	}
+}
@@ -0,0 +1,132 @@
+// Package lincheck records the histories of the counter calls and checks
+// whether they are linearizable: whether the values returned by the
+// concurrent calls could be returned by the calls done one by one, each at
+// a point between its start and its end.
+//
+// The Recorder records every call made through its middleware, Check
+// verifies the history against the Model of the counter. The Audit
+// middleware checks the calls continuously in windows, as an opt-in
+// runtime audit. The expiry of the keys isn't part of the model.
+package lincheck
+
+import (
+	"fmt"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+)
+
+// Kind is the kind of an operation
+type Kind int
+
+const (
+	// KindAdd is an add of the key, Value is the returned value
+	KindAdd Kind = iota
+
+	// KindGet is a read of the key, Value is the returned value if Found
+	KindGet
+
+	// KindSet is a write of the key, Value is the written value
+	KindSet
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindAdd:
+		return "add"
+	case KindGet:
+		return "get"
+	case KindSet:
+		return "set"
+	}
+	return "unknown"
+}
+
+// Operation is a call of the history, Call and Return are its start and
+// end in the order of the recorded events
+type Operation struct {
+	Kind     Kind
+	Key      string
+	Rollover uint64
+	Value    int64
+	Found    bool
+	Err      error
+	Call     uint64
+	Return   uint64
+}
+
+func (op Operation) String() string {
+	var out string
+	switch {
+	case op.Err != nil:
+		out = "error " + op.Err.Error()
+	case op.Kind == KindGet && !op.Found:
+		out = "not found"
+	default:
+		out = fmt.Sprint(op.Value)
+	}
+	return fmt.Sprintf("[%d-%d] %s %s: %s", op.Call, op.Return, op.Kind, op.Key, out)
+}
+
+// ambiguous reports whether the operation may or may not have taken
+// effect: the failed writes, e.g. with a lost reply
+func (op Operation) ambiguous() bool {
+	return op.Err != nil && op.Kind != KindGet
+}
+
+// Model is the counter with rollover of the checked incrementer
+type Model struct {
+	Rollover uint64
+	Initial  int64
+	Step     uint64
+	Cycle    bool
+}
+
+// state is the state of a key in the model
+type state struct {
+	known  bool
+	exists bool
+	value  int64
+}
+
+// unknown is the state of a key without known history
+var unknown = state{}
+
+// missing is the state of a key not created yet
+var missing = state{known: true}
+
+// step applies the operation on the state, it returns the new
+// state and whether the operation is valid in the state
+func (m Model) step(s state, op Operation) (state, bool) {
+	switch op.Kind {
+	case KindGet:
+		if op.Found {
+			return state{known: true, exists: true, value: op.Value}, !s.known || (s.exists && s.value == op.Value)
+		}
+		return missing, !s.known || !s.exists
+
+	case KindSet:
+		return state{known: true, exists: true, value: op.Value}, true
+
+	case KindAdd:
+		if !s.known {
+			// ---- the value of the failed add is unknown too
+			if op.Err != nil {
+				return unknown, true
+			}
+			return state{known: true, exists: true, value: op.Value}, true
+		}
+
+		next := m.Initial
+		if s.exists {
+			next, _ = incrmntr.AddOp{
+				Delta:    m.Step,
+				Rollover: op.Rollover,
+				Initial:  m.Initial,
+				Cycle:    m.Cycle,
+			}.Apply(s.value)
+		}
+		return state{known: true, exists: true, value: next}, op.Err != nil || next == op.Value
+	}
+
+	return s, false
+}
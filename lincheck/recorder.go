@@ -0,0 +1,193 @@
+package lincheck
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+	"github.com/couchbase/gocb/v2"
+)
+
+// clock orders the call and the return events of the operations
+type clock struct {
+	seq uint64
+}
+
+func (c *clock) tick() uint64 {
+	return atomic.AddUint64(&c.seq, 1)
+}
+
+// sink receives the operations of the recording middleware
+type sink interface {
+	invoke(key string) uint64
+	complete(op Operation)
+}
+
+// Recorder records the history of the calls
+type Recorder struct {
+	clock clock
+
+	mu  sync.Mutex
+	ops []Operation
+}
+
+// NewRecorder creates an empty recorder
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Invoke records the start of an operation done without the
+// middleware, it returns the call to pass to Complete
+func (r *Recorder) Invoke() uint64 {
+	return r.invoke("")
+}
+
+// Complete records the end of the operation started with Invoke
+func (r *Recorder) Complete(call uint64, op Operation) {
+	op.Call = call
+	r.complete(op)
+}
+
+// History returns the copy of the recorded operations
+func (r *Recorder) History() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Operation(nil), r.ops...)
+}
+
+// Wrap records the calls of the incrementer
+func (r *Recorder) Wrap(inc incrmntr.Incrmntr, model Model) incrmntr.Incrmntr {
+	return r.Middleware(model)(inc)
+}
+
+// Middleware records the calls of the wrapped incrementer, the model
+// gives the rollover of the adds and the value of the resets
+func (r *Recorder) Middleware(model Model) incrmntr.Middleware {
+	return func(next incrmntr.Incrmntr) incrmntr.Incrmntr {
+		return recorded{Base: incrmntr.NewBase(next), sink: r, model: model}
+	}
+}
+
+func (r *Recorder) invoke(key string) uint64 {
+	return r.clock.tick()
+}
+
+func (r *Recorder) complete(op Operation) {
+	op.Return = r.clock.tick()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, op)
+}
+
+// recorded sends the calls of the incrementer to the sink
+type recorded struct {
+	incrmntr.Base
+
+	sink  sink
+	model Model
+}
+
+func (r recorded) Get(key string) (int64, error) {
+	return r.GetContext(context.Background(), key)
+}
+
+// GetContext is Get bound by the context
+func (r recorded) GetContext(ctx context.Context, key string) (int64, error) {
+	call := r.sink.invoke(key)
+	value, err := r.Base.GetContext(ctx, key)
+
+	op := Operation{Kind: KindGet, Key: key, Value: value, Found: err == nil, Err: err, Call: call}
+	if errors.Is(err, incrmntr.ErrKeyNotFound) || errors.Is(err, gocb.ErrDocumentNotFound) {
+		op.Err = nil
+	}
+	r.sink.complete(op)
+
+	return value, err
+}
+
+func (r recorded) Add(key string) (incrmntr.NullInt64, error) {
+	return r.AddContext(context.Background(), key)
+}
+
+// AddContext is Add bound by the context
+func (r recorded) AddContext(ctx context.Context, key string) (incrmntr.NullInt64, error) {
+	return r.add(key, r.model.Rollover, func() (incrmntr.NullInt64, error) {
+		return r.Base.AddContext(ctx, key)
+	})
+}
+
+func (r recorded) AddSafe(key string) (incrmntr.NullInt64, error) {
+	return r.AddSafeContext(context.Background(), key)
+}
+
+// AddSafeContext is AddSafe bound by the context
+func (r recorded) AddSafeContext(ctx context.Context, key string) (incrmntr.NullInt64, error) {
+	return r.add(key, r.model.Rollover, func() (incrmntr.NullInt64, error) {
+		return r.Base.AddSafeContext(ctx, key)
+	})
+}
+
+func (r recorded) AddWithRollover(key string, rollover uint64) (incrmntr.NullInt64, error) {
+	return r.AddWithRolloverContext(context.Background(), key, rollover)
+}
+
+// AddWithRolloverContext is AddWithRollover bound by the context
+func (r recorded) AddWithRolloverContext(ctx context.Context, key string, rollover uint64) (incrmntr.NullInt64, error) {
+	return r.add(key, rollover, func() (incrmntr.NullInt64, error) {
+		return r.Base.AddWithRolloverContext(ctx, key, rollover)
+	})
+}
+
+func (r recorded) AddSafeWithRollover(key string, rollover uint64) (incrmntr.NullInt64, error) {
+	return r.AddSafeWithRolloverContext(context.Background(), key, rollover)
+}
+
+// AddSafeWithRolloverContext is AddSafeWithRollover bound by the context
+func (r recorded) AddSafeWithRolloverContext(ctx context.Context, key string, rollover uint64) (incrmntr.NullInt64, error) {
+	return r.add(key, rollover, func() (incrmntr.NullInt64, error) {
+		return r.Base.AddSafeWithRolloverContext(ctx, key, rollover)
+	})
+}
+
+func (r recorded) Set(key string, value int64) error {
+	return r.SetContext(context.Background(), key, value)
+}
+
+// SetContext is Set bound by the context
+func (r recorded) SetContext(ctx context.Context, key string, value int64) error {
+	call := r.sink.invoke(key)
+	err := r.Base.SetContext(ctx, key, value)
+	r.sink.complete(Operation{Kind: KindSet, Key: key, Value: value, Err: err, Call: call})
+
+	return err
+}
+
+func (r recorded) Reset(key string) error {
+	return r.ResetContext(context.Background(), key)
+}
+
+// ResetContext is Reset bound by the context
+func (r recorded) ResetContext(ctx context.Context, key string) error {
+	call := r.sink.invoke(key)
+	err := r.Base.ResetContext(ctx, key)
+	r.sink.complete(Operation{Kind: KindSet, Key: key, Value: r.model.Initial, Err: err, Call: call})
+
+	return err
+}
+
+// add records an add with the rollover
+func (r recorded) add(key string, rollover uint64, fn func() (incrmntr.NullInt64, error)) (incrmntr.NullInt64, error) {
+	call := r.sink.invoke(key)
+	value, err := fn()
+	op := Operation{Kind: KindAdd, Key: key, Rollover: rollover, Value: value.Value, Err: err, Call: call}
+	if err == nil && !value.Valid {
+		op.Err = errors.New("error add returned null value")
+	}
+	r.sink.complete(op)
+
+	return value, err
+}
@@ -0,0 +1,133 @@
+package incrmntr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Level is the severity of the log entries
+type Level int
+
+const (
+	LevelDebug Level = iota - 1
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+
+	return "unknown"
+}
+
+// Field is a key-value pair of a log entry
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger writes the log entries of the Incrementer, the logging
+// package adapts it to log/slog, log and zap
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+// LogOption configures the logging of the Incrementer
+type LogOption func(l *logger)
+
+// WithLogLevel drops the entries below the level, LevelInfo by default
+func WithLogLevel(level Level) LogOption {
+	return func(l *logger) {
+		l.level = level
+	}
+}
+
+// WithRedactedKeys logs the truncated SHA-256 hash of the keys instead of them
+func WithRedactedKeys() LogOption {
+	return WithKeyRedactor(func(key string) string {
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:8])
+	})
+}
+
+// WithKeyRedactor logs the keys transformed by the redact function
+func WithKeyRedactor(redact func(key string) string) LogOption {
+	return func(l *logger) {
+		l.redact = redact
+	}
+}
+
+// logger filters and redacts the entries before passing to the Logger
+type logger struct {
+	Logger
+	level  Level
+	redact func(key string) string
+}
+
+// SetLogger sets the logger of the retries, lock failures, rollovers,
+// key creations and storage errors
+func (i *Incrementer) SetLogger(l Logger, opts ...LogOption) {
+	if l == nil {
+		i.logger = nil
+		return
+	}
+
+	lg := &logger{Logger: l, level: LevelInfo}
+	for _, opt := range opts {
+		opt(lg)
+	}
+	i.logger = lg
+}
+
+// log writes the entry with the key if the level is enabled
+func (i *Incrementer) log(level Level, msg string, key string, fields ...Field) {
+	if i.logger == nil || level < i.logger.level {
+		return
+	}
+	if i.logger.redact != nil {
+		key = i.logger.redact(key)
+	}
+
+	i.logger.Log(level, msg, append([]Field{{Key: "key", Value: key}}, fields...)...)
+}
+
+// logEvent writes the log entry of the event
+func (i *Incrementer) logEvent(e Event) {
+	switch e.Kind {
+	case EventRetry:
+		i.log(LevelDebug, "retrying add", e.Key, Field{Key: "attempt", Value: e.Attempt}, Field{Key: "error", Value: e.Err})
+	case EventLockWait:
+		if e.Err != nil {
+			i.log(LevelWarn, "lock failed", e.Key, Field{Key: "wait", Value: e.Duration}, Field{Key: "error", Value: e.Err})
+		}
+	case EventRollover:
+		i.log(LevelInfo, "key rolled over", e.Key)
+	case EventKeyCreated:
+		i.log(LevelInfo, "key created", e.Key)
+	}
+}
+
+// logStorageError writes the failed storage operation, the missing keys are
+// expected by initKey, the lock failures logged by the lock wait event
+// and the conflicts retried by AddSafe
+func (i *Incrementer) logStorageError(operation string, key string, err error) {
+	if err == nil || operation == "GetAndLock" || errors.Is(err, gocb.ErrDocumentNotFound) ||
+		errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrConflict) {
+		return
+	}
+
+	i.log(LevelError, "storage operation failed", key, Field{Key: "operation", Value: operation}, Field{Key: "error", Value: err})
+}
@@ -0,0 +1,71 @@
+package incrmntr
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+type recordingLogger struct {
+	entries []string
+}
+
+func (l *recordingLogger) Log(level Level, msg string, fields ...Field) {
+	l.entries = append(l.entries, fmt.Sprint(level, " ", msg, " ", fields))
+}
+
+func TestLoggerEvents(t *testing.T) {
+	l := &recordingLogger{}
+	inc := &Incrementer{}
+	inc.SetLogger(l, WithLogLevel(LevelDebug))
+
+	inc.emit(Event{Kind: EventRetry, Key: "key", Attempt: 1, Err: gocb.ErrTemporaryFailure})
+	inc.emit(Event{Kind: EventLockWait, Key: "key", Duration: time.Millisecond})
+	inc.emit(Event{Kind: EventLockWait, Key: "key", Duration: time.Millisecond, Err: gocb.ErrTemporaryFailure})
+	inc.emit(Event{Kind: EventRollover, Key: "key"})
+	inc.emit(Event{Kind: EventKeyCreated, Key: "key"})
+	inc.logStorageError("Get", "key", gocb.ErrDocumentNotFound)
+	inc.logStorageError("GetAndLock", "key", gocb.ErrTimeout)
+	inc.logStorageError("Replace", "key", errors.New("boom"))
+
+	expected := []string{
+		fmt.Sprint("debug retrying add ", []Field{{"key", "key"}, {"attempt", 1}, {"error", gocb.ErrTemporaryFailure}}),
+		fmt.Sprint("warn lock failed ", []Field{{"key", "key"}, {"wait", time.Millisecond}, {"error", gocb.ErrTemporaryFailure}}),
+		"info key rolled over [{key key}]",
+		"info key created [{key key}]",
+		"error storage operation failed [{key key} {operation Replace} {error boom}]",
+	}
+	if len(l.entries) != len(expected) {
+		t.Fatalf("Entries should be %q, instead of %q", expected, l.entries)
+	}
+	for k := range expected {
+		if l.entries[k] != expected[k] {
+			t.Errorf("Entry should be %q, instead of %q", expected[k], l.entries[k])
+		}
+	}
+}
+
+func TestLoggerLevelAndRedaction(t *testing.T) {
+	l := &recordingLogger{}
+	inc := &Incrementer{}
+	inc.SetLogger(l, WithRedactedKeys())
+
+	inc.emit(Event{Kind: EventRetry, Key: "secret", Attempt: 1})
+	inc.emit(Event{Kind: EventKeyCreated, Key: "secret"})
+
+	if len(l.entries) != 1 {
+		t.Fatalf("Debug entries should be dropped by default, instead of %q", l.entries)
+	}
+	if expected := "info key created [{key 2bb80d537b1da3e3}]"; l.entries[0] != expected {
+		t.Errorf("Entry should be %q, instead of %q", expected, l.entries[0])
+	}
+
+	inc.SetLogger(nil)
+	inc.emit(Event{Kind: EventKeyCreated, Key: "secret"})
+	if len(l.entries) != 1 {
+		t.Error("Entries shouldn't be written after the logger removed")
+	}
+}
@@ -0,0 +1,82 @@
+// Package logging adapts the common loggers to the Logger of the Incrementer.
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+)
+
+// Func is an incrmntr.Logger function
+type Func func(level incrmntr.Level, msg string, fields ...incrmntr.Field)
+
+// Log calls the function
+func (f Func) Log(level incrmntr.Level, msg string, fields ...incrmntr.Field) {
+	f(level, msg, fields...)
+}
+
+// Std writes the entries to the standard logger in logfmt
+func Std(l *log.Logger) incrmntr.Logger {
+	return Func(func(level incrmntr.Level, msg string, fields ...incrmntr.Field) {
+		var buf bytes.Buffer
+		buf.WriteString("level=" + level.String() + " msg=" + strconv.Quote(msg))
+		for _, f := range fields {
+			value := fmt.Sprint(f.Value)
+			if needsQuote(value) {
+				value = strconv.Quote(value)
+			}
+			buf.WriteString(" " + f.Key + "=" + value)
+		}
+		l.Print(buf.String())
+	})
+}
+
+// needsQuote reports whether the logfmt value has to be quoted
+func needsQuote(value string) bool {
+	if value == "" {
+		return true
+	}
+	for _, r := range value {
+		if r <= ' ' || r == '=' || r == '"' {
+			return true
+		}
+	}
+	return false
+}
+
+// SugaredLogger is implemented by *zap.SugaredLogger
+type SugaredLogger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// Sugared writes the entries to a zap sugared logger
+func Sugared(l SugaredLogger) incrmntr.Logger {
+	return Func(func(level incrmntr.Level, msg string, fields ...incrmntr.Field) {
+		kvs := keysAndValues(fields)
+		switch {
+		case level >= incrmntr.LevelError:
+			l.Errorw(msg, kvs...)
+		case level >= incrmntr.LevelWarn:
+			l.Warnw(msg, kvs...)
+		case level >= incrmntr.LevelInfo:
+			l.Infow(msg, kvs...)
+		default:
+			l.Debugw(msg, kvs...)
+		}
+	})
+}
+
+// keysAndValues flattens the fields to alternating keys and values
+func keysAndValues(fields []incrmntr.Field) []interface{} {
+	kvs := make([]interface{}, 0, 2*len(fields))
+	for _, f := range fields {
+		kvs = append(kvs, f.Key, f.Value)
+	}
+	return kvs
+}
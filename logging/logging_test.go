@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+)
+
+func TestStd(t *testing.T) {
+	var buf bytes.Buffer
+	l := Std(log.New(&buf, "", 0))
+
+	l.Log(incrmntr.LevelWarn, "lock failed", incrmntr.Field{Key: "key", Value: "orders"}, incrmntr.Field{Key: "error", Value: errors.New("document locked")})
+
+	expected := `level=warn msg="lock failed" key=orders error="document locked"` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Output should be %q, instead of %q", expected, buf.String())
+	}
+}
+
+type sugared struct {
+	entries []string
+}
+
+func (s *sugared) write(level string, msg string, kvs []interface{}) {
+	s.entries = append(s.entries, fmt.Sprint(level, " ", msg, " ", kvs))
+}
+
+func (s *sugared) Debugw(msg string, kvs ...interface{}) { s.write("debug", msg, kvs) }
+func (s *sugared) Infow(msg string, kvs ...interface{})  { s.write("info", msg, kvs) }
+func (s *sugared) Warnw(msg string, kvs ...interface{})  { s.write("warn", msg, kvs) }
+func (s *sugared) Errorw(msg string, kvs ...interface{}) { s.write("error", msg, kvs) }
+
+func TestSugared(t *testing.T) {
+	s := &sugared{}
+	l := Sugared(s)
+
+	l.Log(incrmntr.LevelDebug, "retrying add", incrmntr.Field{Key: "attempt", Value: 2})
+	l.Log(incrmntr.LevelError, "storage operation failed", incrmntr.Field{Key: "key", Value: "orders"})
+
+	expected := []string{"debug retrying add [attempt 2]", "error storage operation failed [key orders]"}
+	if strings.Join(s.entries, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Entries should be %q, instead of %q", expected, s.entries)
+	}
+}
@@ -0,0 +1,35 @@
+//go:build go1.21
+// +build go1.21
+
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+)
+
+// Slog writes the entries to the slog logger, the levels
+// mapped to the levels of slog with the same name
+func Slog(l *slog.Logger) incrmntr.Logger {
+	return Func(func(level incrmntr.Level, msg string, fields ...incrmntr.Field) {
+		attrs := make([]slog.Attr, 0, len(fields))
+		for _, f := range fields {
+			attrs = append(attrs, slog.Any(f.Key, f.Value))
+		}
+		l.LogAttrs(context.Background(), slogLevel(level), msg, attrs...)
+	})
+}
+
+func slogLevel(level incrmntr.Level) slog.Level {
+	switch {
+	case level >= incrmntr.LevelError:
+		return slog.LevelError
+	case level >= incrmntr.LevelWarn:
+		return slog.LevelWarn
+	case level >= incrmntr.LevelInfo:
+		return slog.LevelInfo
+	}
+	return slog.LevelDebug
+}
@@ -0,0 +1,34 @@
+//go:build go1.21
+// +build go1.21
+
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+)
+
+func TestSlog(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	l := Slog(slog.New(handler))
+
+	l.Log(incrmntr.LevelInfo, "key created", incrmntr.Field{Key: "key", Value: "orders"})
+	l.Log(incrmntr.LevelDebug, "retrying add", incrmntr.Field{Key: "attempt", Value: 1})
+
+	expected := "level=INFO msg=\"key created\" key=orders\nlevel=DEBUG msg=\"retrying add\" attempt=1\n"
+	if buf.String() != expected {
+		t.Errorf("Output should be %q, instead of %q", expected, buf.String())
+	}
+}
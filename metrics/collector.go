@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ prometheus.Collector = (*Metrics)(nil)
+
+// Describe sends the descriptors of the metrics, Metrics is a
+// prometheus.Collector so it can be registered in a registry
+// of the Prometheus client instead of serving the Handler
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect sends the current values of the metrics
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.operations, m.errors, m.latency, m.retries,
+		m.lockWait, m.contention, m.rollovers, m.created,
+	}
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMetricsCollector(t *testing.T) {
+	m := New("incrmntr")
+	inc := m.Wrap(&stubIncrementer{})
+	inc.Add("key")
+	inc.Add("key")
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(m); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := make(map[string]*dto.MetricFamily)
+	for _, f := range families {
+		gathered[f.GetName()] = f
+	}
+
+	operations, ok := gathered["incrmntr_operations_total"]
+	if !ok || len(operations.Metric) != 1 || operations.Metric[0].GetCounter().GetValue() != 2 {
+		t.Errorf("add operations should be 2, instead of %v", operations)
+	}
+	latency, ok := gathered["incrmntr_operation_duration_seconds"]
+	if !ok || len(latency.Metric) != 1 || latency.Metric[0].GetHistogram().GetSampleCount() != 2 {
+		t.Errorf("add latency should have 2 observations, instead of %v", latency)
+	}
+}
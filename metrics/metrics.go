@@ -0,0 +1,252 @@
+// Package metrics instruments an Incrmntr with the metrics of the
+// Prometheus client, they're served by the Handler or the Metrics
+// is registered as a Collector on a prometheus.Registerer.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+	"github.com/couchbase/gocb/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultBuckets are the upper bounds of the latency histograms in seconds
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects the metrics of the instrumented incrementers
+type Metrics struct {
+	operations *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+	latency    *prometheus.HistogramVec
+	retries    prometheus.Counter
+	lockWait   prometheus.Histogram
+	contention prometheus.Counter
+	rollovers  prometheus.Counter
+	created    prometheus.Counter
+
+	// registry holds the metrics served by the Handler
+	registry *prometheus.Registry
+}
+
+// New creates the metrics, every metric name starts with the namespace
+func New(namespace string) *Metrics {
+	m := &Metrics{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operations_total",
+			Help:      "Number of the incrementer calls.",
+		}, []string{"operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "errors_total",
+			Help:      "Number of the failed incrementer calls by error kind.",
+		}, []string{"operation", "kind"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of the incrementer calls.",
+			Buckets:   DefaultBuckets,
+		}, []string{"operation"}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retries_total",
+			Help:      "Number of the retried adds in AddSafe.",
+		}),
+		lockWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "lock_wait_seconds",
+			Help:      "Time spent on acquiring the lock of the key.",
+			Buckets:   DefaultBuckets,
+		}),
+		contention: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "lock_contention_total",
+			Help:      "Number of the lock attempts failed because the key was locked.",
+		}),
+		rollovers: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rollovers_total",
+			Help:      "Number of the keys cycled back to the initial value.",
+		}),
+		created: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "keys_created_total",
+			Help:      "Number of the initialized keys.",
+		}),
+		registry: prometheus.NewRegistry(),
+	}
+	m.registry.MustRegister(m.collectors()...)
+
+	return m
+}
+
+// observable is implemented by the incrementers emitting events
+type observable interface {
+	AddObserver(observer incrmntr.Observer)
+}
+
+// Wrap returns the instrumented incrementer, the events of the wrapped
+// one observed too if it supports them, next to its other observers
+func (m *Metrics) Wrap(inc incrmntr.Incrmntr) incrmntr.Incrmntr {
+	if o, ok := incrmntr.Lookup(inc, isObservable); ok {
+		o.(observable).AddObserver(m)
+	}
+
+	return &instrumented{Base: incrmntr.NewBase(inc), metrics: m}
+}
+
+// Middleware returns Wrap as a middleware
+func (m *Metrics) Middleware() incrmntr.Middleware {
+	return m.Wrap
+}
+
+func isObservable(inc incrmntr.Incrmntr) bool {
+	_, ok := inc.(observable)
+	return ok
+}
+
+// Observe records the internal events of the incrementer
+func (m *Metrics) Observe(e incrmntr.Event) {
+	switch e.Kind {
+	case incrmntr.EventRetry:
+		m.retries.Inc()
+	case incrmntr.EventLockWait:
+		m.lockWait.Observe(e.Duration.Seconds())
+		if errors.Is(e.Err, gocb.ErrTemporaryFailure) || errors.Is(e.Err, gocb.ErrDocumentLocked) {
+			m.contention.Inc()
+		}
+	case incrmntr.EventRollover:
+		m.rollovers.Inc()
+	case incrmntr.EventKeyCreated:
+		m.created.Inc()
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// record counts the call of the operation with the latency and the error
+func (m *Metrics) record(operation string, start time.Time, err error) {
+	m.operations.WithLabelValues(operation).Inc()
+	m.latency.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.errors.WithLabelValues(operation, ErrorKind(err)).Inc()
+	}
+}
+
+// ErrorKind classifies the error for the errors_total metric
+func ErrorKind(err error) string {
+	switch {
+	case err == nil:
+		return "none"
+	case errors.Is(err, gocb.ErrDocumentNotFound), errors.Is(err, incrmntr.ErrKeyNotFound):
+		return "not_found"
+	case errors.Is(err, gocb.ErrDocumentLocked), errors.Is(err, gocb.ErrTemporaryFailure):
+		return "locked"
+	case errors.Is(err, gocb.ErrCasMismatch), errors.Is(err, incrmntr.ErrTxnConflict), errors.Is(err, incrmntr.ErrConflict):
+		return "conflict"
+	case errors.Is(err, gocb.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, incrmntr.ErrClosed):
+		return "closed"
+	}
+
+	return "other"
+}
+
+// instrumented is the Incrmntr decorator recording the calls
+type instrumented struct {
+	incrmntr.Base
+	metrics *Metrics
+}
+
+func (i *instrumented) Get(key string) (int64, error) {
+	return i.GetContext(context.Background(), key)
+}
+
+// GetContext is Get bound by the context
+func (i *instrumented) GetContext(ctx context.Context, key string) (int64, error) {
+	start := time.Now()
+	value, err := i.Base.GetContext(ctx, key)
+	i.metrics.record("get", start, err)
+	return value, err
+}
+
+func (i *instrumented) Add(key string) (incrmntr.NullInt64, error) {
+	return i.AddContext(context.Background(), key)
+}
+
+// AddContext is Add bound by the context
+func (i *instrumented) AddContext(ctx context.Context, key string) (incrmntr.NullInt64, error) {
+	start := time.Now()
+	value, err := i.Base.AddContext(ctx, key)
+	i.metrics.record("add", start, err)
+	return value, err
+}
+
+func (i *instrumented) AddSafe(key string) (incrmntr.NullInt64, error) {
+	return i.AddSafeContext(context.Background(), key)
+}
+
+// AddSafeContext is AddSafe bound by the context
+func (i *instrumented) AddSafeContext(ctx context.Context, key string) (incrmntr.NullInt64, error) {
+	start := time.Now()
+	value, err := i.Base.AddSafeContext(ctx, key)
+	i.metrics.record("add_safe", start, err)
+	return value, err
+}
+
+func (i *instrumented) AddWithRollover(key string, rollover uint64) (incrmntr.NullInt64, error) {
+	return i.AddWithRolloverContext(context.Background(), key, rollover)
+}
+
+// AddWithRolloverContext is AddWithRollover bound by the context
+func (i *instrumented) AddWithRolloverContext(ctx context.Context, key string, rollover uint64) (incrmntr.NullInt64, error) {
+	start := time.Now()
+	value, err := i.Base.AddWithRolloverContext(ctx, key, rollover)
+	i.metrics.record("add_with_rollover", start, err)
+	return value, err
+}
+
+func (i *instrumented) AddSafeWithRollover(key string, rollover uint64) (incrmntr.NullInt64, error) {
+	return i.AddSafeWithRolloverContext(context.Background(), key, rollover)
+}
+
+// AddSafeWithRolloverContext is AddSafeWithRollover bound by the context
+func (i *instrumented) AddSafeWithRolloverContext(ctx context.Context, key string, rollover uint64) (incrmntr.NullInt64, error) {
+	start := time.Now()
+	value, err := i.Base.AddSafeWithRolloverContext(ctx, key, rollover)
+	i.metrics.record("add_safe_with_rollover", start, err)
+	return value, err
+}
+
+func (i *instrumented) Set(key string, value int64) error {
+	return i.SetContext(context.Background(), key, value)
+}
+
+// SetContext is Set bound by the context
+func (i *instrumented) SetContext(ctx context.Context, key string, value int64) error {
+	start := time.Now()
+	err := i.Base.SetContext(ctx, key, value)
+	i.metrics.record("set", start, err)
+	return err
+}
+
+func (i *instrumented) Reset(key string) error {
+	return i.ResetContext(context.Background(), key)
+}
+
+// ResetContext is Reset bound by the context
+func (i *instrumented) ResetContext(ctx context.Context, key string) error {
+	start := time.Now()
+	err := i.Base.ResetContext(ctx, key)
+	i.metrics.record("reset", start, err)
+	return err
+}
@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+	"github.com/couchbase/gocb/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// stubIncrementer returns the configured error and emits
+// the configured events on every add
+type stubIncrementer struct {
+	observers []incrmntr.Observer
+	events    []incrmntr.Event
+	err       error
+}
+
+func (s *stubIncrementer) AddObserver(observer incrmntr.Observer) {
+	s.observers = append(s.observers, observer)
+}
+
+func (s *stubIncrementer) Get(key string) (int64, error) { return 1, s.err }
+
+func (s *stubIncrementer) Add(key string) (incrmntr.NullInt64, error) {
+	return s.AddSafeWithRollover(key, 0)
+}
+
+func (s *stubIncrementer) AddSafe(key string) (incrmntr.NullInt64, error) {
+	return s.AddSafeWithRollover(key, 0)
+}
+
+func (s *stubIncrementer) AddWithRollover(key string, rollover uint64) (incrmntr.NullInt64, error) {
+	return s.AddSafeWithRollover(key, rollover)
+}
+
+func (s *stubIncrementer) AddSafeWithRollover(key string, rollover uint64) (incrmntr.NullInt64, error) {
+	for _, e := range s.events {
+		for _, o := range s.observers {
+			o.Observe(e)
+		}
+	}
+	return incrmntr.NullInt64{Valid: s.err == nil, Value: 1}, s.err
+}
+
+func (s *stubIncrementer) Set(key string, value int64) error { return s.err }
+
+func (s *stubIncrementer) Reset(key string) error { return s.err }
+
+func (s *stubIncrementer) SetTimeout(timeout time.Duration) {}
+
+func (s *stubIncrementer) Close() error { return nil }
+
+// sampleCount returns the number of the observations of the histogram
+func sampleCount(t *testing.T, h prometheus.Histogram) uint64 {
+	t.Helper()
+	var metric dto.Metric
+	if err := h.Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestMetricsWrap(t *testing.T) {
+	stub := &stubIncrementer{
+		events: []incrmntr.Event{
+			{Kind: incrmntr.EventLockWait, Key: "key", Duration: 3 * time.Millisecond, Err: gocb.ErrTemporaryFailure},
+			{Kind: incrmntr.EventRetry, Key: "key", Attempt: 1},
+			{Kind: incrmntr.EventLockWait, Key: "key", Duration: time.Millisecond},
+			{Kind: incrmntr.EventRollover, Key: "key"},
+		},
+	}
+	m := New("incrmntr")
+	inc := m.Wrap(stub)
+
+	for i := 0; i < 2; i++ {
+		if _, err := inc.AddSafe("key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stub.err = gocb.ErrDocumentNotFound
+	inc.Get("missing")
+
+	if v := testutil.ToFloat64(m.operations.WithLabelValues("add_safe")); v != 2 {
+		t.Errorf("add_safe operations should be 2, instead of %v", v)
+	}
+	if v := testutil.ToFloat64(m.retries); v != 2 {
+		t.Errorf("Retries should be 2, instead of %v", v)
+	}
+	if v := testutil.ToFloat64(m.contention); v != 2 {
+		t.Errorf("Lock contention should be 2, instead of %v", v)
+	}
+	if v := sampleCount(t, m.lockWait); v != 4 {
+		t.Errorf("Lock wait observations should be 4, instead of %v", v)
+	}
+	if v := testutil.ToFloat64(m.rollovers); v != 2 {
+		t.Errorf("Rollovers should be 2, instead of %v", v)
+	}
+	if v := testutil.ToFloat64(m.errors.WithLabelValues("get", "not_found")); v != 1 {
+		t.Errorf("get not_found errors should be 1, instead of %v", v)
+	}
+}
+
+func TestMetricsChainedObservers(t *testing.T) {
+	stub := &stubIncrementer{events: []incrmntr.Event{{Kind: incrmntr.EventRollover, Key: "key"}}}
+	first, second := New("first"), New("second")
+	inc := second.Wrap(first.Wrap(stub))
+
+	if _, err := inc.AddSafe("key"); err != nil {
+		t.Fatal(err)
+	}
+	if testutil.ToFloat64(first.rollovers) != 1 || testutil.ToFloat64(second.rollovers) != 1 {
+		t.Errorf("Both metrics should observe the rollover, instead of %v and %v", testutil.ToFloat64(first.rollovers), testutil.ToFloat64(second.rollovers))
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	m := New("incrmntr")
+	inc := m.Wrap(&stubIncrementer{})
+	inc.Add("key")
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+
+	var expected = []string{
+		"# TYPE incrmntr_operations_total counter",
+		`incrmntr_operations_total{operation="add"} 1`,
+		"# TYPE incrmntr_operation_duration_seconds histogram",
+		`incrmntr_operation_duration_seconds_bucket{operation="add",le="+Inf"} 1`,
+		`incrmntr_operation_duration_seconds_count{operation="add"} 1`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("Output should contain %q:\n%s", line, body)
+		}
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content type should be the Prometheus text format, instead of %s", ct)
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	var cases = map[error]string{
+		nil:                                     "none",
+		gocb.ErrDocumentNotFound:                "not_found",
+		gocb.ErrDocumentLocked:                  "locked",
+		fmt.Errorf("wrap: %w", gocb.ErrTimeout): "timeout",
+		incrmntr.ErrTxnConflict:                 "conflict",
+		incrmntr.ErrClosed:                      "closed",
+		errors.New("boom"):                      "other",
+	}
+	for err, kind := range cases {
+		if k := ErrorKind(err); k != kind {
+			t.Errorf("Kind of %v should be %s, instead of %s", err, kind, k)
+		}
+	}
+}
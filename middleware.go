@@ -0,0 +1,157 @@
+package incrmntr
+
+import (
+	"context"
+	"time"
+)
+
+// Middleware wraps an Incrmntr with cross-cutting behavior,
+// e.g. metrics, tracing, logging or caching
+type Middleware func(next Incrmntr) Incrmntr
+
+// Chain composes the middlewares, the first one is the outermost,
+// so Chain(a, b)(inc) is a(b(inc))
+func Chain(mws ...Middleware) Middleware {
+	return func(next Incrmntr) Incrmntr {
+		for k := len(mws) - 1; k >= 0; k-- {
+			next = mws[k](next)
+		}
+		return next
+	}
+}
+
+// Base passes every call through to the next incrementer, the
+// middlewares embed it and override only the methods they change,
+// both the plain and the Context variant of a method
+type Base struct {
+	Next Incrmntr
+}
+
+// NewBase creates the Base of the next incrementer
+func NewBase(next Incrmntr) Base {
+	return Base{Next: next}
+}
+
+// Get passes the call to the next incrementer
+func (b Base) Get(key string) (int64, error) {
+	return b.Next.Get(key)
+}
+
+// Add passes the call to the next incrementer
+func (b Base) Add(key string) (NullInt64, error) {
+	return b.Next.Add(key)
+}
+
+// AddSafe passes the call to the next incrementer
+func (b Base) AddSafe(key string) (NullInt64, error) {
+	return b.Next.AddSafe(key)
+}
+
+// AddWithRollover passes the call to the next incrementer
+func (b Base) AddWithRollover(key string, rollover uint64) (NullInt64, error) {
+	return b.Next.AddWithRollover(key, rollover)
+}
+
+// AddSafeWithRollover passes the call to the next incrementer
+func (b Base) AddSafeWithRollover(key string, rollover uint64) (NullInt64, error) {
+	return b.Next.AddSafeWithRollover(key, rollover)
+}
+
+// Set passes the call to the next incrementer
+func (b Base) Set(key string, value int64) error {
+	return b.Next.Set(key, value)
+}
+
+// Reset passes the call to the next incrementer
+func (b Base) Reset(key string) error {
+	return b.Next.Reset(key)
+}
+
+// SetTimeout passes the call to the next incrementer
+func (b Base) SetTimeout(timeout time.Duration) {
+	b.Next.SetTimeout(timeout)
+}
+
+// Close passes the call to the next incrementer
+func (b Base) Close() error {
+	return b.Next.Close()
+}
+
+// GetContext passes the call to the next incrementer, with the context if it takes it
+func (b Base) GetContext(ctx context.Context, key string) (int64, error) {
+	if next, ok := b.Next.(ContextIncrmntr); ok {
+		return next.GetContext(ctx, key)
+	}
+	return b.Next.Get(key)
+}
+
+// AddContext passes the call to the next incrementer, with the context if it takes it
+func (b Base) AddContext(ctx context.Context, key string) (NullInt64, error) {
+	if next, ok := b.Next.(ContextIncrmntr); ok {
+		return next.AddContext(ctx, key)
+	}
+	return b.Next.Add(key)
+}
+
+// AddSafeContext passes the call to the next incrementer, with the context if it takes it
+func (b Base) AddSafeContext(ctx context.Context, key string) (NullInt64, error) {
+	if next, ok := b.Next.(ContextIncrmntr); ok {
+		return next.AddSafeContext(ctx, key)
+	}
+	return b.Next.AddSafe(key)
+}
+
+// AddWithRolloverContext passes the call to the next incrementer, with the context if it takes it
+func (b Base) AddWithRolloverContext(ctx context.Context, key string, rollover uint64) (NullInt64, error) {
+	if next, ok := b.Next.(ContextIncrmntr); ok {
+		return next.AddWithRolloverContext(ctx, key, rollover)
+	}
+	return b.Next.AddWithRollover(key, rollover)
+}
+
+// AddSafeWithRolloverContext passes the call to the next incrementer, with the context if it takes it
+func (b Base) AddSafeWithRolloverContext(ctx context.Context, key string, rollover uint64) (NullInt64, error) {
+	if next, ok := b.Next.(ContextIncrmntr); ok {
+		return next.AddSafeWithRolloverContext(ctx, key, rollover)
+	}
+	return b.Next.AddSafeWithRollover(key, rollover)
+}
+
+// SetContext passes the call to the next incrementer, with the context if it takes it
+func (b Base) SetContext(ctx context.Context, key string, value int64) error {
+	if next, ok := b.Next.(ContextIncrmntr); ok {
+		return next.SetContext(ctx, key, value)
+	}
+	return b.Next.Set(key, value)
+}
+
+// ResetContext passes the call to the next incrementer, with the context if it takes it
+func (b Base) ResetContext(ctx context.Context, key string) error {
+	if next, ok := b.Next.(ContextIncrmntr); ok {
+		return next.ResetContext(ctx, key)
+	}
+	return b.Next.Reset(key)
+}
+
+// Unwrap returns the next incrementer
+func (b Base) Unwrap() Incrmntr {
+	return b.Next
+}
+
+// Lookup walks the middleware chain from the outermost incrementer
+// and returns the first one accepted by the match function, so the
+// optional features like Watch are reachable through the middlewares
+func Lookup(inc Incrmntr, match func(inc Incrmntr) bool) (Incrmntr, bool) {
+	for inc != nil {
+		if match(inc) {
+			return inc, true
+		}
+		u, ok := inc.(interface{ Unwrap() Incrmntr })
+		if !ok {
+			break
+		}
+		inc = u.Unwrap()
+	}
+
+	return nil, false
+}
@@ -0,0 +1,139 @@
+package incrmntr
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// memoryIncrementer is an in-memory Incrmntr for the middleware tests
+type memoryIncrementer struct {
+	values  map[string]int64
+	timeout time.Duration
+	closed  bool
+}
+
+func newMemoryIncrementer() *memoryIncrementer {
+	return &memoryIncrementer{values: make(map[string]int64)}
+}
+
+func (m *memoryIncrementer) Get(key string) (int64, error) { return m.values[key], nil }
+
+func (m *memoryIncrementer) Add(key string) (NullInt64, error) { return m.AddSafe(key) }
+
+func (m *memoryIncrementer) AddSafe(key string) (NullInt64, error) {
+	m.values[key]++
+	return nullInt64From(m.values[key]), nil
+}
+
+func (m *memoryIncrementer) AddWithRollover(key string, rollover uint64) (NullInt64, error) {
+	return m.AddSafeWithRollover(key, rollover)
+}
+
+func (m *memoryIncrementer) AddSafeWithRollover(key string, rollover uint64) (NullInt64, error) {
+	m.values[key]++
+	if uint64(m.values[key]) > rollover {
+		m.values[key] = 1
+	}
+	return nullInt64From(m.values[key]), nil
+}
+
+func (m *memoryIncrementer) Set(key string, value int64) error { m.values[key] = value; return nil }
+
+func (m *memoryIncrementer) Reset(key string) error { m.values[key] = 0; return nil }
+
+func (m *memoryIncrementer) SetTimeout(timeout time.Duration) { m.timeout = timeout }
+
+func (m *memoryIncrementer) Close() error { m.closed = true; return nil }
+
+func (m *memoryIncrementer) Watch(ctx context.Context, key string) (<-chan Change, error) {
+	return nil, nil
+}
+
+// tagging records the name of the middleware before every AddSafe
+type tagging struct {
+	Base
+	name  string
+	calls *[]string
+}
+
+func (t tagging) AddSafe(key string) (NullInt64, error) {
+	*t.calls = append(*t.calls, t.name)
+	return t.Next.AddSafe(key)
+}
+
+func taggingMiddleware(name string, calls *[]string) Middleware {
+	return func(next Incrmntr) Incrmntr {
+		return tagging{Base: NewBase(next), name: name, calls: calls}
+	}
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	mem := newMemoryIncrementer()
+	inc := Chain(taggingMiddleware("outer", &calls), taggingMiddleware("inner", &calls))(mem)
+
+	v, err := inc.AddSafe("key")
+	if err != nil || v.Value != 1 {
+		t.Fatalf("AddSafe should return 1, instead of %v, %v", v, err)
+	}
+	if !reflect.DeepEqual(calls, []string{"outer", "inner"}) {
+		t.Errorf("Calls should be outer then inner, instead of %v", calls)
+	}
+
+	if inc := Chain()(mem); inc != mem {
+		t.Error("Empty chain should return the incrementer")
+	}
+}
+
+func TestBasePassthrough(t *testing.T) {
+	mem := newMemoryIncrementer()
+	inc := NewBase(mem)
+
+	if err := inc.Set("key", 9); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := inc.AddWithRollover("key", 9); v.Value != 1 {
+		t.Errorf("AddWithRollover should roll over to 1, instead of %d", v.Value)
+	}
+	if v, _ := inc.Add("key"); v.Value != 2 {
+		t.Errorf("Add should return 2, instead of %d", v.Value)
+	}
+	if v, _ := inc.AddSafeWithRollover("key", 10); v.Value != 3 {
+		t.Errorf("AddSafeWithRollover should return 3, instead of %d", v.Value)
+	}
+	if err := inc.Reset("key"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := inc.Get("key"); v != 0 {
+		t.Errorf("Get should return 0 after reset, instead of %d", v)
+	}
+	inc.SetTimeout(time.Second)
+	if mem.timeout != time.Second {
+		t.Errorf("Timeout should be passed through, instead of %v", mem.timeout)
+	}
+	if err := inc.Close(); err != nil || !mem.closed {
+		t.Error("Close should be passed through")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	var calls []string
+	mem := newMemoryIncrementer()
+	inc := Chain(taggingMiddleware("outer", &calls), taggingMiddleware("inner", &calls))(mem)
+
+	w, ok := Lookup(inc, func(inc Incrmntr) bool {
+		_, ok := inc.(interface {
+			Watch(ctx context.Context, key string) (<-chan Change, error)
+		})
+		return ok
+	})
+	if !ok || w != mem {
+		t.Errorf("Lookup should find the watcher behind the middlewares, instead of %v", w)
+	}
+
+	if _, ok := Lookup(inc, func(Incrmntr) bool { return false }); ok {
+		t.Error("Lookup shouldn't find anything without match")
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/PumpkinSeed/incrmntr"
+	"github.com/PumpkinSeed/incrmntr/v2"
 	"github.com/couchbase/gocb/v2"
 	"github.com/pkg/profile"
 )
@@ -0,0 +1,277 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+)
+
+// operations of the commands
+const (
+	opGet  = "get"
+	opInit = "init"
+	opAdd  = "add"
+	opSet  = "set"
+
+	// opAddIdempotent is the add recording the result of the request,
+	// the Expiry of the command is the ttl of the record
+	opAddIdempotent = "add_idempotent"
+)
+
+// command is an operation of the backend in the log, the expiry is
+// applied by the time of the entry stamped by the leader, so it's
+// the same on every node
+type command struct {
+	Op        string         `json:"op"`
+	Key       string         `json:"key"`
+	RequestID string         `json:"request_id,omitempty"`
+	Value     int64          `json:"value,omitempty"`
+	Add       incrmntr.AddOp `json:"add,omitempty"`
+	Expiry    time.Duration  `json:"expiry,omitempty"`
+}
+
+// result is the result of an applied command
+type result struct {
+	Value    int64 `json:"value"`
+	Rolled   bool  `json:"rolled,omitempty"`
+	Created  bool  `json:"created,omitempty"`
+	NotFound bool  `json:"not_found,omitempty"`
+	Replayed bool  `json:"replayed,omitempty"`
+	Crossed  bool  `json:"crossed,omitempty"`
+}
+
+// counter is a key of the state machine
+type counter struct {
+	Value int64 `json:"value"`
+
+	// Expires is the expiry in unix nanoseconds, 0 means none
+	Expires int64 `json:"expires,omitempty"`
+}
+
+// counters is the state machine of the backend
+type counters struct {
+	mu   sync.Mutex
+	keys map[string]counter
+}
+
+// Apply applies the command of the entry at the time of the entry
+func (c *counters) Apply(entry Entry) []byte {
+	if len(entry.Data) == 0 {
+		return nil
+	}
+	var cmd command
+	if err := json.Unmarshal(entry.Data, &cmd); err != nil {
+		return nil
+	}
+	now := entry.Time
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current, ok := c.lookup(cmd.Key, now)
+
+	var res result
+	switch cmd.Op {
+	case opGet:
+		res = result{Value: current.Value, NotFound: !ok}
+	case opInit:
+		if !ok {
+			c.keys[cmd.Key] = counter{Value: cmd.Value, Expires: expires(now, cmd.Expiry)}
+		}
+		res = result{Value: c.keys[cmd.Key].Value, Created: !ok}
+	case opAdd:
+		if !ok {
+			res = result{NotFound: true}
+			break
+		}
+		if cmd.Add.Crossing(current.Value) != nil {
+			res = result{Value: current.Value, Crossed: true}
+			break
+		}
+		value, rolled := cmd.Add.Apply(current.Value)
+		c.keys[cmd.Key] = counter{Value: value, Expires: expires(now, cmd.Add.Expiry)}
+		res = result{Value: value, Rolled: rolled}
+	case opAddIdempotent:
+		recordKey := incrmntr.IdempotencyKey(cmd.Key, cmd.RequestID)
+		if record, ok := c.lookup(recordKey, now); ok {
+			res = result{Value: record.Value, Replayed: true}
+			break
+		}
+		if ok {
+			res.Value, res.Rolled = cmd.Add.Apply(current.Value)
+		} else {
+			res = result{Value: cmd.Add.Initial, Created: true}
+		}
+		c.keys[cmd.Key] = counter{Value: res.Value, Expires: expires(now, cmd.Add.Expiry)}
+		c.keys[recordKey] = counter{Value: res.Value, Expires: expires(now, cmd.Expiry)}
+	case opSet:
+		c.keys[cmd.Key] = counter{Value: cmd.Value, Expires: expires(now, cmd.Expiry)}
+		res = result{Value: cmd.Value}
+	}
+
+	out, _ := json.Marshal(res)
+	return out
+}
+
+// lookup returns the key live at the time of the command,
+// the expired key is deleted
+func (c *counters) lookup(key string, now int64) (counter, bool) {
+	e, ok := c.keys[key]
+	if ok && e.Expires != 0 && e.Expires <= now {
+		delete(c.keys, key)
+		return counter{}, false
+	}
+	return e, ok
+}
+
+// Snapshot encodes the keys
+func (c *counters) Snapshot() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return json.Marshal(c.keys)
+}
+
+// Restore decodes the keys
+func (c *counters) Restore(data []byte) error {
+	keys := make(map[string]counter)
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys = keys
+
+	return nil
+}
+
+// expires returns the expiry of the key written at now
+func expires(now int64, expiry time.Duration) int64 {
+	if expiry <= 0 {
+		return 0
+	}
+	return now + int64(expiry)
+}
+
+// Backend implements incrmntr.Backend on a node of the Raft cluster
+type Backend struct {
+	node *Node
+}
+
+// New starts the node of the cluster with the counters as state machine
+func New(cfg Config) (*Backend, error) {
+	node, err := NewNode(cfg, &counters{keys: make(map[string]counter)})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Backend{node: node}, nil
+}
+
+// Node is the node of the backend, e.g. to serve it with Handler
+func (b *Backend) Node() *Node {
+	return b.node
+}
+
+// Name is raft
+func (b *Backend) Name() string {
+	return "raft"
+}
+
+// Get returns the value of the key
+func (b *Backend) Get(ctx context.Context, key string) (int64, error) {
+	res, err := b.apply(ctx, command{Op: opGet, Key: key})
+	if err != nil {
+		return 0, err
+	}
+	if res.NotFound {
+		return 0, incrmntr.ErrKeyNotFound
+	}
+
+	return res.Value, nil
+}
+
+// Init creates the key if it doesn't exist
+func (b *Backend) Init(ctx context.Context, key string, value int64, expiry time.Duration) (bool, error) {
+	res, err := b.apply(ctx, command{Op: opInit, Key: key, Value: value, Expiry: expiry})
+	if err != nil {
+		return false, err
+	}
+
+	return res.Created, nil
+}
+
+// Add increments the key, ErrConflict returned if the key
+// expired since it was initialized, so AddSafe creates it again
+func (b *Backend) Add(ctx context.Context, key string, op incrmntr.AddOp) (int64, bool, error) {
+	res, err := b.apply(ctx, command{Op: opAdd, Key: key, Add: op})
+	if err != nil {
+		return 0, false, err
+	}
+	if res.NotFound {
+		return 0, false, incrmntr.ErrConflict
+	}
+	if res.Crossed {
+		return 0, false, &incrmntr.CrossingError{Current: res.Value}
+	}
+
+	return res.Value, res.Rolled, nil
+}
+
+// AddIdempotent increments the key and records the result of the
+// request in the same command
+func (b *Backend) AddIdempotent(ctx context.Context, key string, requestID string, op incrmntr.AddOp, ttl time.Duration) (incrmntr.IdempotentResult, error) {
+	res, err := b.apply(ctx, command{Op: opAddIdempotent, Key: key, RequestID: requestID, Add: op, Expiry: ttl})
+	if err != nil {
+		return incrmntr.IdempotentResult{}, err
+	}
+
+	return incrmntr.IdempotentResult{
+		Value:    res.Value,
+		Created:  res.Created,
+		Rolled:   res.Rolled,
+		Replayed: res.Replayed,
+	}, nil
+}
+
+// Set overwrites the value of the key
+func (b *Backend) Set(ctx context.Context, key string, value int64, expiry time.Duration) error {
+	_, err := b.apply(ctx, command{Op: opSet, Key: key, Value: value, Expiry: expiry})
+	return err
+}
+
+// Ping commits an empty entry, so it fails without a majority
+func (b *Backend) Ping(ctx context.Context) error {
+	_, err := b.node.Apply(ctx, []byte{})
+	return err
+}
+
+// Close stops the node
+func (b *Backend) Close() error {
+	b.node.Stop()
+	return nil
+}
+
+// apply proposes the command and decodes its result
+func (b *Backend) apply(ctx context.Context, cmd command) (result, error) {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return result{}, err
+	}
+
+	out, err := b.node.Apply(ctx, data)
+	if err != nil {
+		return result{}, err
+	}
+	var res result
+	if err := json.Unmarshal(out, &res); err != nil {
+		return result{}, errors.New("error invalid result of the command")
+	}
+
+	return res, nil
+}
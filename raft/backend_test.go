@@ -0,0 +1,423 @@
+package raft_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+	"github.com/PumpkinSeed/incrmntr/v2/incrmntrtest"
+	"github.com/PumpkinSeed/incrmntr/v2/raft"
+	"github.com/PumpkinSeed/incrmntr/v2/raft/rafttest"
+)
+
+// newTestCluster starts the cluster and waits for the leader
+func newTestCluster(t *testing.T, n int, opts ...rafttest.Option) *rafttest.Cluster {
+	c, err := rafttest.NewCluster(n, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Leader(5 * time.Second); err != nil {
+		c.Close()
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// newIncrementer creates the incrementer on the node, it's closed by the cluster
+func newIncrementer(t *testing.T, backend *raft.Backend, rollover uint64, cycle bool) *incrmntr.Incrementer {
+	inc, err := incrmntr.NewWithBackend(backend, rollover, 1, 1, cycle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return inc.(*incrmntr.Incrementer)
+}
+
+func TestClusterAdd(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.Close()
+
+	var incs []*incrmntr.Incrementer
+	for _, id := range c.IDs() {
+		incs = append(incs, newIncrementer(t, c.Backend(id), 3, true))
+	}
+
+	// ---- every node sees the adds of the others
+	var expected = []int64{1, 2, 3, 1, 2, 3, 1}
+	for k, e := range expected {
+		v, err := incs[k%len(incs)].AddSafe("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Value != e {
+			t.Errorf("add %d should be %d, instead of %d", k, e, v.Value)
+		}
+	}
+	for _, inc := range incs {
+		if v, err := inc.Get("key"); err != nil || v != 1 {
+			t.Errorf("value should be 1, instead of %d (%v)", v, err)
+		}
+	}
+}
+
+func TestClusterConcurrentAddSafe(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.Close()
+
+	const workers, adds = 3, 30
+	var mu sync.Mutex
+	var values []int64
+	var wg sync.WaitGroup
+	for _, id := range c.IDs() {
+		inc := newIncrementer(t, c.Backend(id), 0, false)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < adds; k++ {
+					v, err := inc.AddSafe("key")
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					mu.Lock()
+					values = append(values, v.Value)
+					mu.Unlock()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	// ---- the values are unique and contiguous
+	sort.Slice(values, func(a, b int) bool { return values[a] < values[b] })
+	if len(values) != len(c.IDs())*workers*adds {
+		t.Fatalf("should have %d values, instead of %d", len(c.IDs())*workers*adds, len(values))
+	}
+	for k, v := range values {
+		if v != int64(k+1) {
+			t.Fatalf("value %d should be %d, instead of %d", k, k+1, v)
+		}
+	}
+}
+
+func TestClusterLeaderFailure(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.Close()
+
+	old, _ := c.Leader(time.Second)
+	var follower string
+	for _, id := range c.IDs() {
+		if id != old {
+			follower = id
+		}
+	}
+	inc := newIncrementer(t, c.Backend(follower), 0, false)
+	for k := 0; k < 5; k++ {
+		if _, err := inc.AddSafe("key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// ---- the majority elects a new leader and goes on
+	c.Isolate(old)
+	leader, err := c.Leader(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leader == old {
+		t.Fatal("isolated node should not be the leader")
+	}
+	v, err := inc.AddSafe("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Value != 6 {
+		t.Errorf("value should be 6, instead of %d", v.Value)
+	}
+
+	// ---- the old leader catches up after the heal
+	c.Heal()
+	stale := newIncrementer(t, c.Backend(old), 0, false)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := stale.GetContext(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 6 {
+		t.Errorf("value should be 6, instead of %d", got)
+	}
+}
+
+func TestClusterMinority(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.Close()
+
+	ids := c.IDs()
+	c.Isolate(ids[0])
+	c.Isolate(ids[1])
+	inc := newIncrementer(t, c.Backend(ids[2]), 0, false)
+
+	// ---- the minority can't commit
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if _, err := inc.AddSafeContext(ctx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error should be deadline exceeded, instead of %v", err)
+	}
+
+	// ---- the adds go on after the heal
+	c.Heal()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := inc.AddSafeContext(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClusterRestartAndSnapshot(t *testing.T) {
+	c := newTestCluster(t, 3, rafttest.WithSnapshotThreshold(16))
+	defer c.Close()
+
+	ids := c.IDs()
+	leader, _ := c.Leader(time.Second)
+	var stopped string
+	for _, id := range ids {
+		if id != leader {
+			stopped = id
+			break
+		}
+	}
+	c.Stop(stopped)
+
+	inc := newIncrementer(t, c.Backend(leader), 0, false)
+	for k := 0; k < 100; k++ {
+		if _, err := inc.AddSafe("key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// ---- the restarted node gets the snapshot of the compacted log
+	if err := c.Start(stopped); err != nil {
+		t.Fatal(err)
+	}
+	restarted := newIncrementer(t, c.Backend(stopped), 0, false)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	v, err := restarted.GetContext(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 100 {
+		t.Errorf("value should be 100, instead of %d", v)
+	}
+
+	// ---- the whole cluster restarts from the storages
+	for _, id := range ids {
+		c.Stop(id)
+	}
+	for _, id := range ids {
+		if err := c.Start(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inc = newIncrementer(t, c.Backend(ids[0]), 0, false)
+	add, err := inc.AddSafeContext(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if add.Value != 101 {
+		t.Errorf("value should be 101, instead of %d", add.Value)
+	}
+}
+
+func TestSingleNodeExpiry(t *testing.T) {
+	backend, err := raft.New(raft.Config{ID: "node1", Peers: []string{"node1"}, ElectionTimeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inc := newIncrementer(t, backend, 0, false)
+	defer inc.Close()
+	inc.SetExpiry(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, e := range []int64{1, 2} {
+		v, err := inc.AddSafeContext(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Value != e {
+			t.Errorf("value should be %d, instead of %d", e, v.Value)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := inc.GetContext(ctx, "key"); !errors.Is(err, incrmntr.ErrKeyNotFound) {
+		t.Errorf("error should be key not found, instead of %v", err)
+	}
+	v, err := inc.AddSafeContext(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Value != 1 {
+		t.Errorf("value should be 1 after the expiry, instead of %d", v.Value)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	incrmntrtest.Run(t, func(t *testing.T) (incrmntr.Backend, func()) {
+		c := newTestCluster(t, 3)
+		leader, _ := c.Leader(time.Second)
+		for _, id := range c.IDs() {
+			// ---- the calls of the follower are forwarded to the leader
+			if id != leader {
+				return c.Backend(id), c.Close
+			}
+		}
+		return c.Backend(leader), c.Close
+	})
+}
+
+func TestHTTPTransport(t *testing.T) {
+	ids := []string{"node1", "node2", "node3"}
+	addrs := make(map[string]string)
+	handlers := make(map[string]*handler)
+	for _, id := range ids {
+		h := &handler{}
+		srv := httptest.NewServer(h)
+		defer srv.Close()
+		addrs[id] = srv.URL
+		handlers[id] = h
+	}
+
+	var backends []*raft.Backend
+	for _, id := range ids {
+		backend, err := raft.New(raft.Config{
+			ID:              id,
+			Peers:           ids,
+			Transport:       raft.NewHTTPTransport(addrs, nil),
+			ElectionTimeout: 100 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer backend.Close()
+		handlers[id].set(raft.Handler(backend.Node()))
+		backends = append(backends, backend)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for k, backend := range backends {
+		inc := newIncrementer(t, backend, 0, false)
+		v, err := inc.AddSafeContext(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Value != int64(k+1) {
+			t.Errorf("value should be %d, instead of %d", k+1, v.Value)
+		}
+	}
+}
+
+// handler serves the raft handler set after the server started
+type handler struct {
+	mu sync.Mutex
+	h  http.Handler
+}
+
+func (h *handler) set(next http.Handler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.h = next
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	next := h.h
+	h.mu.Unlock()
+	if next == nil {
+		http.Error(w, "not started", http.StatusServiceUnavailable)
+		return
+	}
+	next.ServeHTTP(w, r)
+}
+
+func TestHTTPTransportIndeterminate(t *testing.T) {
+	// ---- the connection is closed after the request was read
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer broken.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	transport := raft.NewHTTPTransport(map[string]string{"broken": broken.URL, "down": down.URL}, nil)
+	ctx := context.Background()
+	var unreachable *raft.UnreachableError
+	if _, err := transport.Propose(ctx, "broken", []byte("data")); !errors.Is(err, raft.ErrIndeterminate) || errors.As(err, &unreachable) {
+		t.Errorf("error should be indeterminate, instead of %v", err)
+	}
+	for _, to := range []string{"down", "unknown"} {
+		if _, err := transport.Propose(ctx, to, []byte("data")); !errors.As(err, &unreachable) {
+			t.Errorf("error of %s should be unreachable, instead of %v", to, err)
+		}
+	}
+}
+
+// failingStorage fails the SaveState calls when it's set
+type failingStorage struct {
+	*raft.MemoryStorage
+	mu   sync.Mutex
+	fail bool
+}
+
+func (s *failingStorage) SaveState(state raft.HardState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("disk failure")
+	}
+	return s.MemoryStorage.SaveState(state)
+}
+
+func TestFailedStorageStops(t *testing.T) {
+	storage := &failingStorage{MemoryStorage: raft.NewMemoryStorage()}
+	backend, err := raft.New(raft.Config{
+		ID:              "node1",
+		Peers:           []string{"node1", "node2"},
+		Transport:       raft.NewHTTPTransport(nil, nil),
+		Storage:         storage,
+		ElectionTimeout: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	// ---- the node doesn't answer with a term it couldn't persist
+	storage.mu.Lock()
+	storage.fail = true
+	storage.mu.Unlock()
+	node := backend.Node()
+	if _, err := node.RequestVote(context.Background(), &raft.VoteRequest{Term: 5, Candidate: "node2"}); err == nil {
+		t.Fatal("vote should fail")
+	}
+	if node.Err() == nil {
+		t.Error("node should be stopped by the storage")
+	}
+	if _, err := node.Apply(context.Background(), []byte("data")); !errors.Is(err, raft.ErrStopped) {
+		t.Errorf("error should be stopped, instead of %v", err)
+	}
+}
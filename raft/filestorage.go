@@ -0,0 +1,369 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// recordHeader is the length and the checksum of a log record
+const recordHeader = 8
+
+// maxRecord is the highest length of a log record read back
+const maxRecord = 64 << 20
+
+// FileStorage persists the node to a directory: the term and the vote
+// and the snapshot are replaced atomically, the entries are appended to
+// a log of checksummed records synced before the calls return. A torn
+// tail of the log (e.g. after a crash during an append) is dropped on
+// open, the entries in it weren't acknowledged to the leader.
+type FileStorage struct {
+	dir string
+
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	snap    Snapshot
+	entries []Entry
+	offsets []int64
+	size    int64
+}
+
+// OpenFileStorage opens the storage in the directory, it's created
+// if it doesn't exist, the directory can be used by one node only
+func OpenFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &FileStorage{dir: dir}
+
+	if err := readJSON(s.path("snapshot"), &s.snap); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(s.path("log"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	entries, offsets, valid, err := readLog(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.f = f
+	s.w = bufio.NewWriter(f)
+
+	// ---- drop the torn tail and the entries covered by the snapshot,
+	// the log is rewritten after the snapshot is saved, so it may be
+	// older than the snapshot after a crash
+	if err := s.truncate(valid); err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.entries, s.offsets = entries, offsets
+	if len(entries) > 0 && entries[0].Index <= s.snap.Index {
+		if err := s.rewrite(s.following(s.snap)); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Load returns the stored state
+func (s *FileStorage) Load() (HardState, Snapshot, []Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var state HardState
+	if err := readJSON(s.path("state"), &state); err != nil {
+		return HardState{}, Snapshot{}, nil, err
+	}
+
+	return state, s.snap, append([]Entry(nil), s.entries...), nil
+}
+
+// SaveState replaces the term and the vote
+func (s *FileStorage) SaveState(state HardState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return writeJSON(s.path("state"), state)
+}
+
+// Append appends the entries to the log, the replaced
+// entries are truncated first
+func (s *FileStorage) Append(entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// ---- keep the entries before the first appended one
+	keep := entries[0].Index - s.snap.Index - 1
+	if keep < uint64(len(s.entries)) {
+		if err := s.truncate(s.offsets[keep]); err != nil {
+			return err
+		}
+		s.entries = s.entries[:keep]
+		s.offsets = s.offsets[:keep]
+	}
+
+	start := s.size
+	offsets := make([]int64, 0, len(entries))
+	offset := start
+	for _, e := range entries {
+		n, err := writeRecord(s.w, e)
+		if err != nil {
+			// ---- the records may be partially written, they're cut off
+			_ = s.truncate(start)
+			return err
+		}
+		offsets = append(offsets, offset)
+		offset += n
+	}
+	if err := s.w.Flush(); err != nil {
+		_ = s.truncate(start)
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	s.size = offset
+	s.entries = append(s.entries, entries...)
+	s.offsets = append(s.offsets, offsets...)
+
+	return nil
+}
+
+// SaveSnapshot replaces the snapshot and rewrites the log
+// with the entries following it
+func (s *FileStorage) SaveSnapshot(snap Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := writeJSON(s.path("snapshot"), snap); err != nil {
+		return err
+	}
+	entries := s.following(snap)
+	s.snap = snap
+
+	return s.rewrite(entries)
+}
+
+// Close closes the log
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.f.Close()
+}
+
+// following returns the entries after the snapshot, none if the
+// entry at the index of the snapshot has an other term
+func (s *FileStorage) following(snap Snapshot) []Entry {
+	for k, e := range s.entries {
+		if e.Index == snap.Index && e.Term == snap.Term {
+			return append([]Entry(nil), s.entries[k+1:]...)
+		}
+	}
+
+	return nil
+}
+
+// rewrite replaces the log with the entries, the rename is atomic
+// so a crash leaves either the old or the new log
+func (s *FileStorage) rewrite(entries []Entry) error {
+	tmp := s.path("log.tmp")
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+
+	offsets := make([]int64, 0, len(entries))
+	var offset int64
+	for _, e := range entries {
+		n, err := writeRecord(w, e)
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+		offsets = append(offsets, offset)
+		offset += n
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, s.path("log")); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := syncDir(s.dir); err != nil {
+		f.Close()
+		return err
+	}
+
+	s.f.Close()
+	s.f = f
+	s.w = bufio.NewWriter(f)
+	s.size = offset
+	s.entries = entries
+	s.offsets = offsets
+
+	return nil
+}
+
+// truncate cuts the log at the offset and appends the next record there
+func (s *FileStorage) truncate(offset int64) error {
+	if err := s.f.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := s.f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	s.w.Reset(s.f)
+	s.size = offset
+
+	return nil
+}
+
+func (s *FileStorage) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// readLog reads the records of the log and returns the entries,
+// their offsets and the offset of the end of the last valid one
+func readLog(f *os.File) ([]Entry, []int64, int64, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, 0, err
+	}
+	r := bufio.NewReader(f)
+	var entries []Entry
+	var offsets []int64
+	var offset int64
+	header := make([]byte, recordHeader)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return entries, offsets, offset, nil
+		}
+		size := binary.BigEndian.Uint32(header[0:4])
+		sum := binary.BigEndian.Uint32(header[4:8])
+		if size > maxRecord {
+			return entries, offsets, offset, nil
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return entries, offsets, offset, nil
+		}
+		if crc32.ChecksumIEEE(payload) != sum {
+			return entries, offsets, offset, nil
+		}
+		var e Entry
+		if err := json.Unmarshal(payload, &e); err != nil {
+			return entries, offsets, offset, nil
+		}
+		if len(entries) > 0 && e.Index != entries[len(entries)-1].Index+1 {
+			return nil, nil, 0, errors.New("error raft log isn't contiguous")
+		}
+
+		entries = append(entries, e)
+		offsets = append(offsets, offset)
+		offset += int64(recordHeader + size)
+	}
+}
+
+// writeRecord writes the entry as a checksummed record
+// and returns the length of the record
+func writeRecord(w io.Writer, e Entry) (int64, error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	header := make([]byte, recordHeader)
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(payload))
+	if _, err := w.Write(header); err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return 0, err
+	}
+
+	return int64(recordHeader + len(payload)), nil
+}
+
+// readJSON decodes the file, a missing file leaves the value empty
+func readJSON(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// writeJSON replaces the file with the value, the rename is atomic
+// so a crash leaves either the old or the new value
+func writeJSON(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir syncs the directory, so the renames survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,170 @@
+package raft_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2/raft"
+)
+
+func openFileStorage(t *testing.T, dir string) *raft.FileStorage {
+	t.Helper()
+	s, err := raft.OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func expectLoad(t *testing.T, s *raft.FileStorage, state raft.HardState, snap raft.Snapshot, entries []raft.Entry) {
+	t.Helper()
+	st, sn, en, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != state {
+		t.Errorf("state should be %+v, instead of %+v", state, st)
+	}
+	if sn.Index != snap.Index || sn.Term != snap.Term || string(sn.Data) != string(snap.Data) {
+		t.Errorf("snapshot should be %+v, instead of %+v", snap, sn)
+	}
+	if len(en) != len(entries) || (len(en) > 0 && !reflect.DeepEqual(en, entries)) {
+		t.Errorf("entries should be %+v, instead of %+v", entries, en)
+	}
+}
+
+func TestFileStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := openFileStorage(t, dir)
+	state := raft.HardState{Term: 2, Vote: "node1"}
+	if err := s.SaveState(state); err != nil {
+		t.Fatal(err)
+	}
+	entries := []raft.Entry{
+		{Index: 1, Term: 1, Data: []byte("a"), Time: 1},
+		{Index: 2, Term: 1, Data: []byte("b"), Time: 2},
+		{Index: 3, Term: 1, Data: []byte("c"), Time: 3},
+	}
+	if err := s.Append(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	// ---- the appended entries replace the ones from their index
+	replaced := []raft.Entry{{Index: 2, Term: 2, Data: []byte("d"), Time: 4}}
+	if err := s.Append(replaced); err != nil {
+		t.Fatal(err)
+	}
+	expected := []raft.Entry{entries[0], replaced[0]}
+	expectLoad(t, s, state, raft.Snapshot{}, expected)
+	s.Close()
+
+	s = openFileStorage(t, dir)
+	expectLoad(t, s, state, raft.Snapshot{}, expected)
+
+	// ---- the snapshot drops the entries covered by it
+	if err := s.Append([]raft.Entry{{Index: 3, Term: 2, Data: []byte("e"), Time: 5}}); err != nil {
+		t.Fatal(err)
+	}
+	snap := raft.Snapshot{Index: 2, Term: 2, Time: 4, Data: []byte("snap")}
+	if err := s.SaveSnapshot(snap); err != nil {
+		t.Fatal(err)
+	}
+	expected = []raft.Entry{{Index: 3, Term: 2, Data: []byte("e"), Time: 5}}
+	s.Close()
+
+	s = openFileStorage(t, dir)
+	expectLoad(t, s, state, snap, expected)
+	s.Close()
+
+	// ---- the torn tail is dropped and the next entry is appended after
+	// the last complete one
+	f, err := os.OpenFile(filepath.Join(dir, "log"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 40, 1, 2})
+	f.Close()
+
+	s = openFileStorage(t, dir)
+	defer s.Close()
+	expectLoad(t, s, state, snap, expected)
+	next := raft.Entry{Index: 4, Term: 2, Data: []byte("f"), Time: 6}
+	if err := s.Append([]raft.Entry{next}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	s = openFileStorage(t, dir)
+	expectLoad(t, s, state, snap, append(expected, next))
+}
+
+func TestFileStorageNode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := func() (*raft.Backend, *raft.FileStorage) {
+		s := openFileStorage(t, dir)
+		backend, err := raft.New(raft.Config{
+			ID:                "node1",
+			Peers:             []string{"node1"},
+			Storage:           s,
+			ElectionTimeout:   20 * time.Millisecond,
+			SnapshotThreshold: 4,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return backend, s
+	}
+
+	// ---- the counter survives the restart of the process
+	backend, s := start()
+	inc := newIncrementer(t, backend, 0, false)
+	for k := 0; k < 10; k++ {
+		if _, err := inc.AddSafeContext(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inc.Close()
+
+	// ---- the entries are stamped by the leader in order
+	_, snap, entries, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := snap.Time
+	for _, e := range entries {
+		if e.Time < last || e.Time == 0 {
+			t.Errorf("time of the entry %d should follow %d, instead of %d", e.Index, last, e.Time)
+		}
+		last = e.Time
+	}
+	s.Close()
+
+	backend, s = start()
+	defer s.Close()
+	inc = newIncrementer(t, backend, 0, false)
+	defer inc.Close()
+	v, err := inc.AddSafeContext(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Value != 11 {
+		t.Errorf("value should be 11 after the restart, instead of %d", v.Value)
+	}
+}
@@ -0,0 +1,970 @@
+// Package raft is the Backend of the Incrementer replicating the counters
+// with the Raft consensus algorithm between the processes of the
+// application, so a small cluster serves linearizable counters without
+// external database.
+//
+// Every operation, the reads included, goes through the replicated log
+// and is applied when a majority of the nodes stored it. The followers
+// forward the operations to the leader, during an election the operations
+// are retried until their context is done, but only if they provably
+// weren't appended, otherwise their result is unknown (ErrIndeterminate).
+// The log is compacted to a snapshot of the counters when it grows past
+// the threshold, the lagging followers get the snapshot instead of the
+// compacted entries. A node stops if its storage fails.
+//
+// The peers are fixed by the config, membership changes aren't supported.
+package raft
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotLeader returned by Propose on a node which isn't the leader
+	ErrNotLeader = errors.New("raft: node is not the leader")
+
+	// ErrNoLeader returned when there is no known leader, e.g. during an election
+	ErrNoLeader = errors.New("raft: no leader")
+
+	// ErrStopped returned by the calls of a stopped node
+	ErrStopped = errors.New("raft: node is stopped")
+
+	// ErrDropped returned when the proposed entry was overwritten by an
+	// other leader, the entry wasn't applied so it can be proposed again
+	ErrDropped = errors.New("raft: proposal dropped")
+
+	// ErrIndeterminate returned when it's unknown whether the proposed
+	// entry was applied, e.g. the node stopped or the connection to the
+	// leader broke after the proposal was sent, so it isn't retried
+	ErrIndeterminate = errors.New("raft: proposal result is unknown")
+)
+
+// Entry is an entry of the replicated log, the entries without
+// data are appended by the new leaders and skipped by the state machine
+type Entry struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Data  []byte `json:"data,omitempty"`
+
+	// Time is the clock of the leader appending the entry in unix
+	// nanoseconds, it never goes back from one entry to the next
+	Time int64 `json:"time,omitempty"`
+}
+
+// HardState is the state of the node which has to survive the restarts
+type HardState struct {
+	Term uint64 `json:"term"`
+	Vote string `json:"vote"`
+}
+
+// Snapshot is the state machine at the index of the log, Time is
+// the latest time of the entries covered by it
+type Snapshot struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Time  int64  `json:"time,omitempty"`
+	Data  []byte `json:"data"`
+}
+
+// StateMachine is the replicated state, the Apply has to be deterministic,
+// so it takes the time from the entry instead of the local clock
+type StateMachine interface {
+	// Apply applies the committed entry and returns its result
+	Apply(entry Entry) []byte
+
+	// Snapshot returns the whole state
+	Snapshot() ([]byte, error)
+
+	// Restore replaces the state with the snapshot
+	Restore(data []byte) error
+}
+
+// Config is the config of a node
+type Config struct {
+	// ID is the id of the node, it has to be in the Peers
+	ID string
+
+	// Peers are the ids of all the nodes of the cluster
+	Peers []string
+
+	// Transport sends the messages to the other nodes
+	Transport Transport
+
+	// Storage persists the state of the node, a new MemoryStorage by default
+	Storage Storage
+
+	// ElectionTimeout is the minimum time without heartbeat before
+	// a follower starts an election, 300ms by default
+	ElectionTimeout time.Duration
+
+	// HeartbeatInterval is the interval of the heartbeats of the
+	// leader, ElectionTimeout/6 by default
+	HeartbeatInterval time.Duration
+
+	// SnapshotThreshold is the number of the applied entries
+	// triggering the compaction of the log, 10000 by default
+	SnapshotThreshold uint64
+}
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+// maxBatch is the highest number of the entries sent in an AppendEntries
+const maxBatch = 256
+
+// Node is a member of the Raft cluster
+type Node struct {
+	id        string
+	peers     []string
+	transport Transport
+	storage   Storage
+	sm        StateMachine
+
+	electionTimeout   time.Duration
+	heartbeatInterval time.Duration
+	threshold         uint64
+
+	mu          sync.Mutex
+	role        role
+	state       HardState
+	leader      string
+	snap        Snapshot
+	log         []Entry
+	commitIndex uint64
+	lastApplied uint64
+	lastContact time.Time
+	timeout     time.Duration
+	clock       int64
+	now         func() time.Time
+
+	// ---- leader state, reset on every election won
+	nextIndex  map[string]uint64
+	matchIndex map[string]uint64
+	lastAck    map[string]time.Time
+	triggers   map[string]chan struct{}
+	stepDown   chan struct{}
+
+	waiters map[uint64]*waiter
+	stop    chan struct{}
+	stopped bool
+	failure error
+	wg      sync.WaitGroup
+}
+
+// waiter waits for the result of a proposed entry
+type waiter struct {
+	term   uint64
+	done   chan struct{}
+	result []byte
+	err    error
+}
+
+// NewNode restores the state of the node from the storage and starts it
+func NewNode(cfg Config, sm StateMachine) (*Node, error) {
+	if cfg.Transport == nil && len(cfg.Peers) > 1 {
+		return nil, errors.New("error transport is nil")
+	}
+	if sm == nil {
+		return nil, errors.New("error state machine is nil")
+	}
+	var member bool
+	for _, p := range cfg.Peers {
+		member = member || p == cfg.ID
+	}
+	if !member {
+		return nil, errors.New("error node id is not in the peers")
+	}
+
+	n := &Node{
+		id:                cfg.ID,
+		peers:             cfg.Peers,
+		transport:         cfg.Transport,
+		storage:           cfg.Storage,
+		sm:                sm,
+		electionTimeout:   cfg.ElectionTimeout,
+		heartbeatInterval: cfg.HeartbeatInterval,
+		threshold:         cfg.SnapshotThreshold,
+		now:               time.Now,
+		waiters:           make(map[uint64]*waiter),
+		stop:              make(chan struct{}),
+	}
+	if n.storage == nil {
+		n.storage = NewMemoryStorage()
+	}
+	if n.electionTimeout <= 0 {
+		n.electionTimeout = 300 * time.Millisecond
+	}
+	if n.heartbeatInterval <= 0 {
+		n.heartbeatInterval = n.electionTimeout / 6
+	}
+	if n.threshold == 0 {
+		n.threshold = 10000
+	}
+
+	// ---- restore the persisted state
+	state, snap, entries, err := n.storage.Load()
+	if err != nil {
+		return nil, err
+	}
+	n.state = state
+	n.snap = snap
+	n.log = entries
+	n.clock = snap.Time
+	n.observe(entries)
+	if snap.Index > 0 {
+		if err := sm.Restore(snap.Data); err != nil {
+			return nil, err
+		}
+		n.commitIndex = snap.Index
+		n.lastApplied = snap.Index
+	}
+
+	n.resetTimer()
+	n.wg.Add(1)
+	go n.run()
+
+	return n, nil
+}
+
+// ID is the id of the node
+func (n *Node) ID() string {
+	return n.id
+}
+
+// Leader returns the id of the known leader, empty if there is no leader
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.leader
+}
+
+// IsLeader reports whether the node is the leader
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.role == leader
+}
+
+// Term is the current term of the node
+func (n *Node) Term() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.state.Term
+}
+
+// Err returns the error of the storage which stopped the node, nil
+// if the node is running or it was stopped by Stop
+func (n *Node) Err() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.failure
+}
+
+// Stop stops the node, the result of the waiting proposals is
+// unknown, they may be committed by the other nodes
+func (n *Node) Stop() {
+	n.mu.Lock()
+	n.halt(nil)
+	n.mu.Unlock()
+
+	n.wg.Wait()
+}
+
+// halt stops the node without waiting for its goroutines, the err is
+// the failure of the storage if any, the lock has to be held
+func (n *Node) halt(err error) {
+	if n.stopped {
+		return
+	}
+	n.stopped = true
+	n.failure = err
+	close(n.stop)
+	if n.role == leader {
+		close(n.stepDown)
+		n.triggers = nil
+	}
+	n.role = follower
+	n.leader = ""
+	for index, w := range n.waiters {
+		w.err = ErrIndeterminate
+		close(w.done)
+		delete(n.waiters, index)
+	}
+}
+
+// Apply proposes the data and returns the result of the state machine,
+// the followers forward it to the leader. Without leader it's retried
+// until the context is done.
+func (n *Node) Apply(ctx context.Context, data []byte) ([]byte, error) {
+	backoff := n.heartbeatInterval
+	for {
+		result, err := n.Propose(ctx, data)
+		if errors.Is(err, ErrNotLeader) {
+			to := n.Leader()
+			if to == "" {
+				err = ErrNoLeader
+			} else {
+				result, err = n.transport.Propose(ctx, to, data)
+				if errors.Is(err, ErrStopped) {
+					err = ErrNoLeader
+				}
+			}
+		}
+		if err == nil || !retryable(err) {
+			return result, err
+		}
+
+		// ---- wait for the election
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-n.stop:
+			return nil, ErrStopped
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// retryable reports whether the proposal wasn't appended, so it can be
+// sent again without applying it twice, the UnreachableError is returned
+// by the transports only if the proposal wasn't delivered to the leader
+func retryable(err error) bool {
+	if errors.Is(err, ErrNotLeader) || errors.Is(err, ErrNoLeader) || errors.Is(err, ErrDropped) {
+		return true
+	}
+	var unreachable *UnreachableError
+	return errors.As(err, &unreachable)
+}
+
+// Propose appends the data to the log of the leader and waits for
+// its result, it returns ErrNotLeader on the followers
+func (n *Node) Propose(ctx context.Context, data []byte) ([]byte, error) {
+	if data == nil {
+		data = []byte{}
+	}
+
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return nil, ErrStopped
+	}
+	if n.role != leader {
+		n.mu.Unlock()
+		return nil, ErrNotLeader
+	}
+	entry, err := n.appendLocked(data)
+	if err != nil {
+		n.mu.Unlock()
+		return nil, err
+	}
+	w := &waiter{term: entry.Term, done: make(chan struct{})}
+	n.waiters[entry.Index] = w
+	n.advanceCommit()
+	n.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return w.result, w.err
+	case <-ctx.Done():
+		n.mu.Lock()
+		delete(n.waiters, entry.Index)
+		n.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// appendLocked appends a new entry to the log of the leader and
+// triggers the replication, the caller advances the commit
+func (n *Node) appendLocked(data []byte) (Entry, error) {
+	// ---- the time of the entry is never behind the earlier entries,
+	// even if the clock of the previous leader was ahead
+	now := n.now().UnixNano()
+	if now < n.clock {
+		now = n.clock
+	}
+	entry := Entry{Index: n.lastIndex() + 1, Term: n.state.Term, Data: data, Time: now}
+	if err := n.storage.Append([]Entry{entry}); err != nil {
+		n.halt(err)
+		return Entry{}, err
+	}
+	n.log = append(n.log, entry)
+	n.clock = now
+	n.matchIndex[n.id] = entry.Index
+	for _, trigger := range n.triggers {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+
+	return entry, nil
+}
+
+// run starts the elections when the leader is silent
+func (n *Node) run() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.electionTimeout / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+
+		n.mu.Lock()
+		switch {
+		case n.role == leader && !n.hasQuorum():
+			// ---- a leader cut off from the majority can't commit, its
+			// followers look for the new leader
+			n.becomeFollower(n.state.Term, "")
+			n.resetTimer()
+		case n.role != leader && time.Since(n.lastContact) > n.timeout:
+			n.startElection()
+		}
+		n.mu.Unlock()
+	}
+}
+
+// hasQuorum reports whether the majority of the peers answered
+// the leader within the election timeout
+func (n *Node) hasQuorum() bool {
+	count := 1
+	for peer, ack := range n.lastAck {
+		if peer != n.id && time.Since(ack) < n.electionTimeout {
+			count++
+		}
+	}
+	return count > len(n.peers)/2
+}
+
+// resetTimer postpones the next election by a random timeout
+func (n *Node) resetTimer() {
+	n.lastContact = time.Now()
+	n.timeout = n.electionTimeout + time.Duration(rand.Int63n(int64(n.electionTimeout)))
+}
+
+// startElection votes for itself and requests the votes of the peers
+func (n *Node) startElection() {
+	previous := n.state
+	n.state = HardState{Term: previous.Term + 1, Vote: n.id}
+	if err := n.storage.SaveState(n.state); err != nil {
+		n.state = previous
+		n.halt(err)
+		return
+	}
+	n.role = candidate
+	n.leader = ""
+	n.resetTimer()
+
+	term := n.state.Term
+	req := &VoteRequest{
+		Term:      term,
+		Candidate: n.id,
+		LastIndex: n.lastIndex(),
+		LastTerm:  n.lastTerm(),
+	}
+	votes := 1
+	if votes > len(n.peers)/2 {
+		n.becomeLeader()
+		return
+	}
+
+	for _, peer := range n.peers {
+		if peer == n.id {
+			continue
+		}
+		n.wg.Add(1)
+		go func(peer string) {
+			defer n.wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), n.electionTimeout)
+			defer cancel()
+			resp, err := n.transport.RequestVote(ctx, peer, req)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if n.stopped {
+				return
+			}
+			if resp.Term > n.state.Term {
+				n.becomeFollower(resp.Term, "")
+				return
+			}
+			if n.role != candidate || n.state.Term != term || !resp.Granted {
+				return
+			}
+			votes++
+			if votes > len(n.peers)/2 {
+				n.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+// becomeFollower steps down to follower in the term, the node
+// stops if the new term can't be persisted
+func (n *Node) becomeFollower(term uint64, leaderID string) error {
+	if term > n.state.Term {
+		// ---- the term has to be persisted before answering with it
+		state := HardState{Term: term}
+		if err := n.storage.SaveState(state); err != nil {
+			n.halt(err)
+			return err
+		}
+		n.state = state
+	}
+	if n.role == leader {
+		close(n.stepDown)
+		n.triggers = nil
+	}
+	n.role = follower
+	n.leader = leaderID
+
+	return nil
+}
+
+// becomeLeader starts the replication to the peers and appends an
+// empty entry, the entries of the previous terms are committed with it
+func (n *Node) becomeLeader() {
+	n.role = leader
+	n.leader = n.id
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.lastAck = make(map[string]time.Time)
+	n.triggers = make(map[string]chan struct{})
+	n.stepDown = make(chan struct{})
+
+	for _, peer := range n.peers {
+		if peer == n.id {
+			continue
+		}
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.lastAck[peer] = time.Now()
+		n.triggers[peer] = make(chan struct{}, 1)
+		n.wg.Add(1)
+		go n.replicate(peer, n.state.Term, n.triggers[peer], n.stepDown)
+	}
+
+	if _, err := n.appendLocked(nil); err != nil {
+		return
+	}
+	n.advanceCommit()
+}
+
+// replicate sends the entries and the heartbeats to the peer
+// until the leader steps down
+func (n *Node) replicate(peer string, term uint64, trigger <-chan struct{}, stepDown <-chan struct{}) {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		more := n.send(peer, term)
+		if more {
+			select {
+			case <-stepDown:
+				return
+			case <-n.stop:
+				return
+			default:
+				continue
+			}
+		}
+
+		select {
+		case <-stepDown:
+			return
+		case <-n.stop:
+			return
+		case <-trigger:
+		case <-ticker.C:
+		}
+	}
+}
+
+// send sends the next batch of entries or the snapshot to the peer,
+// it reports whether there are more entries to send
+func (n *Node) send(peer string, term uint64) bool {
+	n.mu.Lock()
+	if n.role != leader || n.state.Term != term {
+		n.mu.Unlock()
+		return false
+	}
+
+	next := n.nextIndex[peer]
+	if next <= n.snap.Index {
+		req := &SnapshotRequest{Term: term, Leader: n.id, Snapshot: n.snap}
+		n.mu.Unlock()
+		return n.sendSnapshot(peer, req)
+	}
+
+	prev := next - 1
+	prevTerm, _ := n.term(prev)
+	entries := make([]Entry, 0, maxBatch)
+	for index := next; index <= n.lastIndex() && len(entries) < maxBatch; index++ {
+		entries = append(entries, n.entry(index))
+	}
+	req := &AppendRequest{
+		Term:      term,
+		Leader:    n.id,
+		PrevIndex: prev,
+		PrevTerm:  prevTerm,
+		Entries:   entries,
+		Commit:    n.commitIndex,
+	}
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.electionTimeout)
+	defer cancel()
+	resp, err := n.transport.AppendEntries(ctx, peer, req)
+	if err != nil {
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if resp.Term > n.state.Term {
+		n.becomeFollower(resp.Term, "")
+		return false
+	}
+	if n.role != leader || n.state.Term != term {
+		return false
+	}
+	n.lastAck[peer] = time.Now()
+
+	if resp.Success {
+		match := prev + uint64(len(entries))
+		if match > n.matchIndex[peer] {
+			n.matchIndex[peer] = match
+		}
+		n.nextIndex[peer] = n.matchIndex[peer] + 1
+		n.advanceCommit()
+	} else {
+		// ---- step back to the end of the log of the follower
+		next := prev
+		if resp.LastIndex+1 < next {
+			next = resp.LastIndex + 1
+		}
+		if next < 1 {
+			next = 1
+		}
+		n.nextIndex[peer] = next
+	}
+
+	return n.nextIndex[peer] <= n.lastIndex()
+}
+
+// sendSnapshot sends the snapshot to the peer lagging behind the compacted log
+func (n *Node) sendSnapshot(peer string, req *SnapshotRequest) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), n.electionTimeout)
+	defer cancel()
+	resp, err := n.transport.InstallSnapshot(ctx, peer, req)
+	if err != nil {
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if resp.Term > n.state.Term {
+		n.becomeFollower(resp.Term, "")
+		return false
+	}
+	if n.role != leader || n.state.Term != req.Term {
+		return false
+	}
+	n.lastAck[peer] = time.Now()
+	if req.Snapshot.Index > n.matchIndex[peer] {
+		n.matchIndex[peer] = req.Snapshot.Index
+	}
+	n.nextIndex[peer] = n.matchIndex[peer] + 1
+
+	return n.nextIndex[peer] <= n.lastIndex()
+}
+
+// advanceCommit commits the highest entry of the term stored by
+// the majority, the entries of the previous terms are committed with it
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if term, _ := n.term(index); term != n.state.Term {
+			break
+		}
+		count := 0
+		for _, peer := range n.peers {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count > len(n.peers)/2 {
+			n.commitIndex = index
+			n.applyCommitted()
+			return
+		}
+	}
+}
+
+// applyCommitted applies the committed entries to the state machine
+// and answers the waiting proposals
+func (n *Node) applyCommitted() {
+	for n.lastApplied < n.commitIndex {
+		n.lastApplied++
+		entry := n.entry(n.lastApplied)
+
+		var result []byte
+		if entry.Data != nil {
+			result = n.sm.Apply(entry)
+		}
+		if w, ok := n.waiters[entry.Index]; ok {
+			if w.term == entry.Term {
+				w.result = result
+			} else {
+				w.err = ErrDropped
+			}
+			close(w.done)
+			delete(n.waiters, entry.Index)
+		}
+	}
+
+	if n.lastApplied-n.snap.Index >= n.threshold {
+		n.compact()
+	}
+}
+
+// compact replaces the applied entries with the snapshot of the state machine
+func (n *Node) compact() {
+	data, err := n.sm.Snapshot()
+	if err != nil {
+		return
+	}
+	term, _ := n.term(n.lastApplied)
+	snap := Snapshot{Index: n.lastApplied, Term: term, Time: n.snap.Time, Data: data}
+	if n.lastApplied > n.snap.Index {
+		snap.Time = n.entry(n.lastApplied).Time
+	}
+	if err := n.storage.SaveSnapshot(snap); err != nil {
+		n.halt(err)
+		return
+	}
+	n.log = append([]Entry(nil), n.log[snap.Index-n.snap.Index:]...)
+	n.snap = snap
+}
+
+// RequestVote handles the vote request of a candidate
+func (n *Node) RequestVote(ctx context.Context, req *VoteRequest) (*VoteResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return nil, ErrStopped
+	}
+	if req.Term > n.state.Term {
+		if err := n.becomeFollower(req.Term, ""); err != nil {
+			return nil, err
+		}
+	}
+	resp := &VoteResponse{Term: n.state.Term}
+	if req.Term < n.state.Term {
+		return resp, nil
+	}
+
+	// ---- the candidate's log has to be at least as up-to-date as ours
+	upToDate := req.LastTerm > n.lastTerm() ||
+		(req.LastTerm == n.lastTerm() && req.LastIndex >= n.lastIndex())
+	if (n.state.Vote == "" || n.state.Vote == req.Candidate) && upToDate {
+		n.state.Vote = req.Candidate
+		if err := n.storage.SaveState(n.state); err != nil {
+			n.state.Vote = ""
+			n.halt(err)
+			return nil, err
+		}
+		n.resetTimer()
+		resp.Granted = true
+	}
+
+	return resp, nil
+}
+
+// AppendEntries handles the entries and the heartbeats of the leader
+func (n *Node) AppendEntries(ctx context.Context, req *AppendRequest) (*AppendResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return nil, ErrStopped
+	}
+	resp := &AppendResponse{Term: n.state.Term, LastIndex: n.lastIndex()}
+	if req.Term < n.state.Term {
+		return resp, nil
+	}
+	if err := n.becomeFollower(req.Term, req.Leader); err != nil {
+		return nil, err
+	}
+	n.resetTimer()
+	resp.Term = n.state.Term
+
+	// ---- the log has to contain the previous entry of the leader
+	if req.PrevIndex > n.lastIndex() {
+		return resp, nil
+	}
+	if req.PrevIndex > n.snap.Index {
+		if term, _ := n.term(req.PrevIndex); term != req.PrevTerm {
+			resp.LastIndex = req.PrevIndex - 1
+			return resp, nil
+		}
+	}
+
+	// ---- skip the matching entries, truncate the log at the first conflict
+	entries := req.Entries
+	for len(entries) > 0 {
+		e := entries[0]
+		if e.Index <= n.snap.Index {
+			entries = entries[1:]
+			continue
+		}
+		if e.Index > n.lastIndex() {
+			break
+		}
+		if term, _ := n.term(e.Index); term != e.Term {
+			n.log = n.log[:e.Index-n.snap.Index-1]
+			break
+		}
+		entries = entries[1:]
+	}
+	if len(entries) > 0 {
+		if err := n.storage.Append(entries); err != nil {
+			// ---- the log in the memory may be truncated already
+			n.halt(err)
+			return nil, err
+		}
+		n.log = append(n.log, entries...)
+		n.observe(entries)
+	}
+
+	last := req.PrevIndex + uint64(len(req.Entries))
+	if req.Commit > n.commitIndex {
+		commit := req.Commit
+		if last < commit {
+			commit = last
+		}
+		if commit > n.commitIndex {
+			n.commitIndex = commit
+			n.applyCommitted()
+		}
+	}
+	resp.Success = true
+	resp.LastIndex = last
+
+	return resp, nil
+}
+
+// InstallSnapshot handles the snapshot of the leader
+func (n *Node) InstallSnapshot(ctx context.Context, req *SnapshotRequest) (*AppendResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return nil, ErrStopped
+	}
+	resp := &AppendResponse{Term: n.state.Term}
+	if req.Term < n.state.Term {
+		return resp, nil
+	}
+	if err := n.becomeFollower(req.Term, req.Leader); err != nil {
+		return nil, err
+	}
+	n.resetTimer()
+	resp.Term = n.state.Term
+
+	snap := req.Snapshot
+	if snap.Index <= n.commitIndex {
+		resp.Success = true
+		resp.LastIndex = n.lastIndex()
+		return resp, nil
+	}
+	if err := n.storage.SaveSnapshot(snap); err != nil {
+		n.halt(err)
+		return nil, err
+	}
+	if err := n.sm.Restore(snap.Data); err != nil {
+		// ---- the stored snapshot is restored on the next start
+		n.halt(err)
+		return nil, err
+	}
+
+	// ---- keep the log following the snapshot if it matches
+	if term, ok := n.term(snap.Index); ok && snap.Index <= n.lastIndex() && term == snap.Term {
+		n.log = append([]Entry(nil), n.log[snap.Index-n.snap.Index:]...)
+	} else {
+		n.log = nil
+	}
+	n.snap = snap
+	if snap.Time > n.clock {
+		n.clock = snap.Time
+	}
+	n.commitIndex = snap.Index
+	n.lastApplied = snap.Index
+	for index, w := range n.waiters {
+		if index <= snap.Index {
+			w.err = ErrIndeterminate
+			close(w.done)
+			delete(n.waiters, index)
+		}
+	}
+	resp.Success = true
+	resp.LastIndex = n.lastIndex()
+
+	return resp, nil
+}
+
+// observe moves the clock to the latest time of the entries
+func (n *Node) observe(entries []Entry) {
+	for _, e := range entries {
+		if e.Time > n.clock {
+			n.clock = e.Time
+		}
+	}
+}
+
+// lastIndex is the index of the last entry of the log
+func (n *Node) lastIndex() uint64 {
+	return n.snap.Index + uint64(len(n.log))
+}
+
+// lastTerm is the term of the last entry of the log
+func (n *Node) lastTerm() uint64 {
+	term, _ := n.term(n.lastIndex())
+	return term
+}
+
+// term returns the term of the entry, false if it's compacted or missing
+func (n *Node) term(index uint64) (uint64, bool) {
+	switch {
+	case index == n.snap.Index:
+		return n.snap.Term, true
+	case index < n.snap.Index || index > n.lastIndex():
+		return 0, false
+	}
+	return n.log[index-n.snap.Index-1].Term, true
+}
+
+// entry returns the entry of the log after the snapshot
+func (n *Node) entry(index uint64) Entry {
+	return n.log[index-n.snap.Index-1]
+}
@@ -0,0 +1,213 @@
+// Package rafttest runs the nodes of a Raft cluster in the same process
+// for the tests, the messages are delivered in memory and the nodes
+// can be isolated from the others, stopped and restarted.
+package rafttest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2/raft"
+)
+
+// ErrUnreachable is the error of the messages to the isolated or stopped nodes
+var ErrUnreachable = errors.New("node is isolated or stopped")
+
+// Option configures the Cluster
+type Option func(c *Cluster)
+
+// WithElectionTimeout sets the election timeout of the nodes, 100ms by default
+func WithElectionTimeout(timeout time.Duration) Option {
+	return func(c *Cluster) {
+		c.electionTimeout = timeout
+	}
+}
+
+// WithSnapshotThreshold sets the snapshot threshold of the nodes
+func WithSnapshotThreshold(threshold uint64) Option {
+	return func(c *Cluster) {
+		c.threshold = threshold
+	}
+}
+
+// Cluster is a cluster of the raft backends in memory
+type Cluster struct {
+	ids             []string
+	electionTimeout time.Duration
+	threshold       uint64
+
+	mu       sync.Mutex
+	backends map[string]*raft.Backend
+	storages map[string]*raft.MemoryStorage
+	isolated map[string]bool
+}
+
+// NewCluster starts the cluster of n nodes with the ids node1, node2, ...
+func NewCluster(n int, opts ...Option) (*Cluster, error) {
+	c := &Cluster{
+		electionTimeout: 100 * time.Millisecond,
+		backends:        make(map[string]*raft.Backend),
+		storages:        make(map[string]*raft.MemoryStorage),
+		isolated:        make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	for k := 1; k <= n; k++ {
+		c.ids = append(c.ids, fmt.Sprintf("node%d", k))
+	}
+
+	for _, id := range c.ids {
+		c.storages[id] = raft.NewMemoryStorage()
+		if err := c.Start(id); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// IDs are the ids of the nodes
+func (c *Cluster) IDs() []string {
+	return c.ids
+}
+
+// Backend returns the backend of the node, nil if it's stopped
+func (c *Cluster) Backend(id string) *raft.Backend {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.backends[id]
+}
+
+// Start starts the stopped node with its previous storage
+func (c *Cluster) Start(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.backends[id] != nil {
+		return nil
+	}
+	backend, err := raft.New(raft.Config{
+		ID:                id,
+		Peers:             c.ids,
+		Transport:         transport{c: c, from: id},
+		Storage:           c.storages[id],
+		ElectionTimeout:   c.electionTimeout,
+		SnapshotThreshold: c.threshold,
+	})
+	if err != nil {
+		return err
+	}
+	c.backends[id] = backend
+
+	return nil
+}
+
+// Stop stops the node, its storage is kept for Start
+func (c *Cluster) Stop(id string) {
+	c.mu.Lock()
+	backend := c.backends[id]
+	delete(c.backends, id)
+	c.mu.Unlock()
+
+	if backend != nil {
+		backend.Close()
+	}
+}
+
+// Isolate drops the messages from and to the node
+func (c *Cluster) Isolate(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.isolated[id] = true
+}
+
+// Heal delivers the messages of all the nodes again
+func (c *Cluster) Heal() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.isolated = make(map[string]bool)
+}
+
+// Leader waits until a running and not isolated node is the leader
+func (c *Cluster) Leader(timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		for id, backend := range c.backends {
+			if !c.isolated[id] && backend.Node().IsLeader() {
+				c.mu.Unlock()
+				return id, nil
+			}
+		}
+		c.mu.Unlock()
+		time.Sleep(c.electionTimeout / 10)
+	}
+
+	return "", errors.New("no leader elected")
+}
+
+// Close stops all the nodes
+func (c *Cluster) Close() {
+	for _, id := range c.ids {
+		c.Stop(id)
+	}
+}
+
+// node returns the node receiving the message
+func (c *Cluster) node(from string, to string) (*raft.Node, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	backend := c.backends[to]
+	if backend == nil || c.isolated[from] || c.isolated[to] {
+		return nil, &raft.UnreachableError{Node: to, Err: ErrUnreachable}
+	}
+
+	return backend.Node(), nil
+}
+
+// transport delivers the messages of a node in memory
+type transport struct {
+	c    *Cluster
+	from string
+}
+
+func (t transport) RequestVote(ctx context.Context, to string, req *raft.VoteRequest) (*raft.VoteResponse, error) {
+	n, err := t.c.node(t.from, to)
+	if err != nil {
+		return nil, err
+	}
+	return n.RequestVote(ctx, req)
+}
+
+func (t transport) AppendEntries(ctx context.Context, to string, req *raft.AppendRequest) (*raft.AppendResponse, error) {
+	n, err := t.c.node(t.from, to)
+	if err != nil {
+		return nil, err
+	}
+	return n.AppendEntries(ctx, req)
+}
+
+func (t transport) InstallSnapshot(ctx context.Context, to string, req *raft.SnapshotRequest) (*raft.AppendResponse, error) {
+	n, err := t.c.node(t.from, to)
+	if err != nil {
+		return nil, err
+	}
+	return n.InstallSnapshot(ctx, req)
+}
+
+func (t transport) Propose(ctx context.Context, to string, data []byte) ([]byte, error) {
+	n, err := t.c.node(t.from, to)
+	if err != nil {
+		return nil, err
+	}
+	return n.Propose(ctx, data)
+}
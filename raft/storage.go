@@ -0,0 +1,89 @@
+package raft
+
+import "sync"
+
+// Storage persists the state, the log and the snapshot of a node,
+// the node calls it before answering the other nodes
+type Storage interface {
+	// Load returns the persisted state, the snapshot and the entries following it
+	Load() (HardState, Snapshot, []Entry, error)
+
+	// SaveState persists the term and the vote
+	SaveState(state HardState) error
+
+	// Append persists the entries, the existing entries from
+	// the index of the first one are replaced
+	Append(entries []Entry) error
+
+	// SaveSnapshot persists the snapshot and drops the entries covered
+	// by it, the following entries are dropped too if the entry at the
+	// index of the snapshot has an other term
+	SaveSnapshot(snap Snapshot) error
+}
+
+// MemoryStorage keeps the state in memory, it survives the restart of
+// the node in the same process only (e.g. in the tests)
+type MemoryStorage struct {
+	mu      sync.Mutex
+	state   HardState
+	snap    Snapshot
+	entries []Entry
+}
+
+// NewMemoryStorage creates an empty storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+// Load returns the copy of the stored state
+func (s *MemoryStorage) Load() (HardState, Snapshot, []Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state, s.snap, append([]Entry(nil), s.entries...), nil
+}
+
+// SaveState stores the term and the vote
+func (s *MemoryStorage) SaveState(state HardState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state = state
+	return nil
+}
+
+// Append stores the entries
+func (s *MemoryStorage) Append(entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// ---- keep the entries before the first appended one
+	keep := entries[0].Index - s.snap.Index - 1
+	if keep < uint64(len(s.entries)) {
+		s.entries = s.entries[:keep]
+	}
+	s.entries = append(s.entries, entries...)
+
+	return nil
+}
+
+// SaveSnapshot stores the snapshot
+func (s *MemoryStorage) SaveSnapshot(snap Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []Entry
+	for k, e := range s.entries {
+		if e.Index == snap.Index && e.Term == snap.Term {
+			entries = append(entries, s.entries[k+1:]...)
+			break
+		}
+	}
+	s.entries = entries
+	s.snap = snap
+
+	return nil
+}
@@ -0,0 +1,231 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// VoteRequest is sent by the candidates
+type VoteRequest struct {
+	Term      uint64 `json:"term"`
+	Candidate string `json:"candidate"`
+	LastIndex uint64 `json:"last_index"`
+	LastTerm  uint64 `json:"last_term"`
+}
+
+// VoteResponse is the answer of a VoteRequest
+type VoteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+// AppendRequest is sent by the leader with the entries or as heartbeat
+type AppendRequest struct {
+	Term      uint64  `json:"term"`
+	Leader    string  `json:"leader"`
+	PrevIndex uint64  `json:"prev_index"`
+	PrevTerm  uint64  `json:"prev_term"`
+	Entries   []Entry `json:"entries"`
+	Commit    uint64  `json:"commit"`
+}
+
+// AppendResponse is the answer of an AppendRequest or a SnapshotRequest,
+// the LastIndex is the last index of the log of the follower
+type AppendResponse struct {
+	Term      uint64 `json:"term"`
+	Success   bool   `json:"success"`
+	LastIndex uint64 `json:"last_index"`
+}
+
+// SnapshotRequest is sent by the leader to the followers lagging behind its log
+type SnapshotRequest struct {
+	Term     uint64   `json:"term"`
+	Leader   string   `json:"leader"`
+	Snapshot Snapshot `json:"snapshot"`
+}
+
+// Transport delivers the messages to the other nodes
+type Transport interface {
+	RequestVote(ctx context.Context, to string, req *VoteRequest) (*VoteResponse, error)
+	AppendEntries(ctx context.Context, to string, req *AppendRequest) (*AppendResponse, error)
+	InstallSnapshot(ctx context.Context, to string, req *SnapshotRequest) (*AppendResponse, error)
+
+	// Propose calls Propose of the node, it's used by the followers
+	// to forward the proposals to the leader. It has to return an
+	// UnreachableError only if the proposal wasn't delivered, because
+	// those are retried, and ErrIndeterminate if it may have been.
+	Propose(ctx context.Context, to string, data []byte) ([]byte, error)
+}
+
+// UnreachableError returned by the transports when the message
+// provably wasn't delivered to the node, e.g. the connection
+// couldn't be dialed
+type UnreachableError struct {
+	Node string
+	Err  error
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("raft: node %s is unreachable: %v", e.Node, e.Err)
+}
+
+func (e *UnreachableError) Unwrap() error {
+	return e.Err
+}
+
+// errs are the errors of the nodes restored by the HTTP transport
+var errs = []error{ErrNotLeader, ErrNoLeader, ErrStopped, ErrDropped, ErrIndeterminate}
+
+// HTTPTransport delivers the messages as JSON over HTTP to the
+// Handler of the nodes
+type HTTPTransport struct {
+	addrs  map[string]string
+	client *http.Client
+}
+
+// NewHTTPTransport creates the transport with the base URLs of the
+// nodes by their ids (e.g. http://10.0.0.2:7000), the default client
+// is used if the client is nil
+func NewHTTPTransport(addrs map[string]string, client *http.Client) *HTTPTransport {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &HTTPTransport{addrs: addrs, client: client}
+}
+
+// RequestVote sends the vote request
+func (t *HTTPTransport) RequestVote(ctx context.Context, to string, req *VoteRequest) (*VoteResponse, error) {
+	var resp VoteResponse
+	err := t.post(ctx, to, "vote", req, &resp)
+	return &resp, err
+}
+
+// AppendEntries sends the entries
+func (t *HTTPTransport) AppendEntries(ctx context.Context, to string, req *AppendRequest) (*AppendResponse, error) {
+	var resp AppendResponse
+	err := t.post(ctx, to, "append", req, &resp)
+	return &resp, err
+}
+
+// InstallSnapshot sends the snapshot
+func (t *HTTPTransport) InstallSnapshot(ctx context.Context, to string, req *SnapshotRequest) (*AppendResponse, error) {
+	var resp AppendResponse
+	err := t.post(ctx, to, "snapshot", req, &resp)
+	return &resp, err
+}
+
+// Propose forwards the proposal
+func (t *HTTPTransport) Propose(ctx context.Context, to string, data []byte) ([]byte, error) {
+	var resp []byte
+	err := t.post(ctx, to, "propose", data, &resp)
+	return resp, err
+}
+
+// post sends the request and decodes the response or the error of the node
+func (t *HTTPTransport) post(ctx context.Context, to string, path string, req interface{}, resp interface{}) error {
+	addr, ok := t.addrs[to]
+	if !ok {
+		return &UnreachableError{Node: to, Err: errors.New("unknown node")}
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	r, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(addr, "/")+"/raft/"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	res, err := t.client.Do(r.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var op *net.OpError
+		if errors.As(err, &op) && op.Op == "dial" {
+			return &UnreachableError{Node: to, Err: err}
+		}
+		// ---- the request may have been handled by the node
+		return fmt.Errorf("%w: node %s: %v", ErrIndeterminate, to, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&e); err != nil {
+			return fmt.Errorf("%w: node %s: status %d", ErrIndeterminate, to, res.StatusCode)
+		}
+		for _, known := range errs {
+			if known.Error() == e.Error {
+				return known
+			}
+		}
+		return errors.New(e.Error)
+	}
+
+	return json.NewDecoder(res.Body).Decode(resp)
+}
+
+// Handler serves the messages of the HTTP transport on the /raft/ paths
+func Handler(n *Node) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/raft/vote", func(w http.ResponseWriter, r *http.Request) {
+		var req VoteRequest
+		serve(w, r, &req, func() (interface{}, error) {
+			return n.RequestVote(r.Context(), &req)
+		})
+	})
+	mux.HandleFunc("/raft/append", func(w http.ResponseWriter, r *http.Request) {
+		var req AppendRequest
+		serve(w, r, &req, func() (interface{}, error) {
+			return n.AppendEntries(r.Context(), &req)
+		})
+	})
+	mux.HandleFunc("/raft/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		var req SnapshotRequest
+		serve(w, r, &req, func() (interface{}, error) {
+			return n.InstallSnapshot(r.Context(), &req)
+		})
+	})
+	mux.HandleFunc("/raft/propose", func(w http.ResponseWriter, r *http.Request) {
+		var data []byte
+		serve(w, r, &data, func() (interface{}, error) {
+			return n.Propose(r.Context(), data)
+		})
+	})
+
+	return mux
+}
+
+// serve decodes the request, calls the node and encodes its answer
+func serve(w http.ResponseWriter, r *http.Request, req interface{}, fn func() (interface{}, error)) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	resp, err := fn()
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
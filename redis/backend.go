@@ -0,0 +1,470 @@
+// Package redis is the Backend of the Incrementer over the Redis protocol.
+//
+// The adds run in a Lua script which checks the key, increments it with
+// INCRBY or resets it on the rollover in one atomic call, the expired key
+// fails with incrmntr.ErrConflict, so AddSafe initializes it again. The
+// idempotent adds run in a WATCH/MULTI/EXEC transaction of the key and
+// the record of the request. The keys are initialized with SET NX.
+package redis
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+)
+
+// Option configures the Backend
+type Option func(b *Backend)
+
+// WithPassword authenticates the connections with AUTH
+func WithPassword(password string) Option {
+	return func(b *Backend) {
+		b.password = password
+	}
+}
+
+// WithDB selects the database of the connections with SELECT
+func WithDB(db int) Option {
+	return func(b *Backend) {
+		b.db = db
+	}
+}
+
+// WithPoolSize sets the highest number of the open connections, 10 by default
+func WithPoolSize(size int) Option {
+	return func(b *Backend) {
+		if size > 0 {
+			b.size = size
+		}
+	}
+}
+
+// Backend implements incrmntr.Backend with a Redis server
+type Backend struct {
+	addr     string
+	password string
+	db       int
+	size     int
+
+	mu     sync.Mutex
+	idle   []*conn
+	sem    chan struct{}
+	closed bool
+}
+
+// New creates the Backend and checks the server with PING
+func New(ctx context.Context, addr string, opts ...Option) (*Backend, error) {
+	b := &Backend{addr: addr, size: 10}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.sem = make(chan struct{}, b.size)
+
+	if err := b.Ping(ctx); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Name is the name of the backend in the spans
+func (b *Backend) Name() string {
+	return "redis"
+}
+
+// Get returns the value of the key
+func (b *Backend) Get(ctx context.Context, key string) (int64, error) {
+	var value int64
+	err := b.with(ctx, func(c *conn) error {
+		reply, err := c.do(ctx, "GET", key)
+		if err != nil {
+			return err
+		}
+		value, err = parseValue(reply)
+		return err
+	})
+
+	return value, err
+}
+
+// Init creates the key with SET NX
+func (b *Backend) Init(ctx context.Context, key string, value int64, expiry time.Duration) (bool, error) {
+	var created bool
+	err := b.with(ctx, func(c *conn) error {
+		reply, err := c.do(ctx, setArgs(key, value, expiry, "NX")...)
+		if err != nil {
+			return err
+		}
+		if err, ok := reply.(Error); ok {
+			return err
+		}
+		created = reply != nil
+		return nil
+	})
+
+	return created, err
+}
+
+// Add increments the key with the add script, an INCRBY alone
+// would create the expired key with the delta as value
+func (b *Backend) Add(ctx context.Context, key string, op incrmntr.AddOp) (int64, bool, error) {
+	var value int64
+	var rolled bool
+	err := b.with(ctx, func(c *conn) error {
+		var err error
+		value, rolled, err = add(ctx, c, key, op)
+		return err
+	})
+
+	return value, rolled, err
+}
+
+// addScript increments the existing key with INCRBY or resets it to the
+// initial value on the rollover in one atomic call. The values are compared
+// as decimal strings, the Lua numbers lose the precision above 2^53.
+//
+// KEYS[1] is the key, ARGV the delta, the highest value not rolling over
+// (empty without cycle), the initial value, the expiry in milliseconds
+// (0 to persist) and 1 if the batch crossing the rollover is refused.
+// It returns the status (0 missing, 1 added, 2 rolled, 3 crossing) and
+// the value.
+const addScript = `
+local function greater(a, b)
+	local na, nb = a:sub(1, 1) == "-", b:sub(1, 1) == "-"
+	if na ~= nb then return nb end
+	if na then a, b = b:sub(2), a:sub(2) end
+	if #a ~= #b then return #a > #b end
+	return a > b
+end
+
+local current = redis.call("GET", KEYS[1])
+if not current then return {0} end
+if #current > 20 or (current ~= "0" and not current:match("^-?[1-9]%d*$")) then
+	return redis.error_reply("error counter value is not a number")
+end
+
+if ARGV[2] ~= "" and greater(current, ARGV[2]) then
+	if ARGV[5] == "1" then return {3, current} end
+	if ARGV[4] ~= "0" then
+		redis.call("SET", KEYS[1], ARGV[3], "PX", ARGV[4])
+	else
+		redis.call("SET", KEYS[1], ARGV[3])
+	end
+	return {2, ARGV[3]}
+end
+
+redis.call("INCRBY", KEYS[1], ARGV[1])
+if ARGV[4] ~= "0" then
+	redis.call("PEXPIRE", KEYS[1], ARGV[4])
+else
+	redis.call("PERSIST", KEYS[1])
+end
+return {1, redis.call("GET", KEYS[1])}
+`
+
+// addScriptSHA is the digest of the add script for EVALSHA
+var addScriptSHA = func() string {
+	sum := sha1.Sum([]byte(addScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+// add runs the add script with EVALSHA, or EVAL if the server doesn't
+// have it cached yet, the status 0 of the missing key means a conflict
+func add(ctx context.Context, c *conn, key string, op incrmntr.AddOp) (int64, bool, error) {
+	threshold := ""
+	if op.Cycle && op.Rollover <= math.MaxInt64 {
+		threshold = strconv.FormatInt(int64(op.Rollover)-int64(op.Delta), 10)
+	}
+	refuse := "0"
+	if op.Crossing(int64(op.Rollover)) != nil {
+		// ---- a batch of the coalesced adds, Crossing refuses it above the threshold
+		refuse = "1"
+	}
+	args := []string{"1", key,
+		strconv.FormatInt(int64(op.Delta), 10),
+		threshold,
+		strconv.FormatInt(op.Initial, 10),
+		strconv.FormatInt(op.Expiry.Milliseconds(), 10),
+		refuse,
+	}
+
+	reply, err := c.do(ctx, append([]string{"EVALSHA", addScriptSHA}, args...)...)
+	if err != nil {
+		return 0, false, err
+	}
+	if e, ok := reply.(Error); ok && strings.HasPrefix(string(e), "NOSCRIPT") {
+		if reply, err = c.do(ctx, append([]string{"EVAL", addScript}, args...)...); err != nil {
+			return 0, false, err
+		}
+	}
+	if err, ok := reply.(Error); ok {
+		return 0, false, err
+	}
+
+	items, ok := reply.([]interface{})
+	if !ok || len(items) == 0 {
+		return 0, false, errProtocol
+	}
+	status, ok := items[0].(int64)
+	if !ok {
+		return 0, false, errProtocol
+	}
+	if status == 0 {
+		// ---- expired after the init, AddSafe initializes it again
+		return 0, false, incrmntr.ErrConflict
+	}
+	if len(items) != 2 {
+		return 0, false, errProtocol
+	}
+	value, err := parseValue(items[1])
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch status {
+	case 1:
+		return value, false, nil
+	case 2:
+		return value, true, nil
+	case 3:
+		return 0, false, &incrmntr.CrossingError{Current: value}
+	}
+
+	return 0, false, errProtocol
+}
+
+// AddIdempotent increments the key and records the result of the request
+// in a WATCH/MULTI/EXEC transaction of both keys, the nil reply of EXEC
+// means a conflict, e.g. a concurrent attempt of the request
+func (b *Backend) AddIdempotent(ctx context.Context, key string, requestID string, op incrmntr.AddOp, ttl time.Duration) (incrmntr.IdempotentResult, error) {
+	var res incrmntr.IdempotentResult
+	err := b.with(ctx, func(c *conn) error {
+		recordKey := incrmntr.IdempotencyKey(key, requestID)
+		if _, err := c.do(ctx, "WATCH", key, recordKey); err != nil {
+			return err
+		}
+
+		// ---- the result of an earlier attempt
+		reply, err := c.do(ctx, "GET", recordKey)
+		if err != nil {
+			return err
+		}
+		recorded, err := parseValue(reply)
+		if err == nil {
+			_, _ = c.do(ctx, "UNWATCH")
+			res = incrmntr.IdempotentResult{Value: recorded, Replayed: true}
+			return nil
+		}
+		if !errors.Is(err, incrmntr.ErrKeyNotFound) {
+			_, _ = c.do(ctx, "UNWATCH")
+			return err
+		}
+
+		reply, err = c.do(ctx, "GET", key)
+		if err != nil {
+			return err
+		}
+		current, err := parseValue(reply)
+		switch {
+		case err == nil:
+			res.Value, res.Rolled = op.Apply(current)
+		case errors.Is(err, incrmntr.ErrKeyNotFound):
+			res.Value, res.Created = op.Initial, true
+		default:
+			_, _ = c.do(ctx, "UNWATCH")
+			return err
+		}
+
+		if _, err := c.do(ctx, "MULTI"); err != nil {
+			return err
+		}
+		if _, err := c.do(ctx, setArgs(key, res.Value, op.Expiry)...); err != nil {
+			return err
+		}
+		if _, err := c.do(ctx, setArgs(recordKey, res.Value, ttl)...); err != nil {
+			return err
+		}
+		reply, err = c.do(ctx, "EXEC")
+		if err != nil {
+			return err
+		}
+		if err, ok := reply.(Error); ok {
+			return err
+		}
+		if reply == nil {
+			return incrmntr.ErrConflict
+		}
+		return nil
+	})
+
+	return res, err
+}
+
+// Set overwrites the value of the key
+func (b *Backend) Set(ctx context.Context, key string, value int64, expiry time.Duration) error {
+	return b.with(ctx, func(c *conn) error {
+		reply, err := c.do(ctx, setArgs(key, value, expiry)...)
+		if err != nil {
+			return err
+		}
+		if err, ok := reply.(Error); ok {
+			return err
+		}
+		return nil
+	})
+}
+
+// Ping checks the server with PING
+func (b *Backend) Ping(ctx context.Context) error {
+	return b.with(ctx, func(c *conn) error {
+		reply, err := c.do(ctx, "PING")
+		if err != nil {
+			return err
+		}
+		if err, ok := reply.(Error); ok {
+			return err
+		}
+		return nil
+	})
+}
+
+// Close closes the idle connections, the ones in use
+// closed when they're given back
+func (b *Backend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for _, c := range b.idle {
+		_ = c.Close()
+	}
+	b.idle = nil
+
+	return nil
+}
+
+// with runs the function on a pooled connection, the connection
+// is dropped if the function failed with other than a server error
+func (b *Backend) with(ctx context.Context, fn func(c *conn) error) error {
+	c, err := b.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = fn(c)
+	var serverErr Error
+	var crossing *incrmntr.CrossingError
+	b.put(c, err != nil && !errors.As(err, &serverErr) && !errors.As(err, &crossing) &&
+		!errors.Is(err, incrmntr.ErrKeyNotFound) && !errors.Is(err, incrmntr.ErrConflict))
+
+	return err
+}
+
+// get returns an idle connection or dials a new one
+func (b *Backend) get(ctx context.Context) (*conn, error) {
+	select {
+	case b.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		<-b.sem
+		return nil, errors.New("error redis backend is closed")
+	}
+	if n := len(b.idle); n > 0 {
+		c := b.idle[n-1]
+		b.idle = b.idle[:n-1]
+		b.mu.Unlock()
+		return c, nil
+	}
+	b.mu.Unlock()
+
+	c, err := b.dial(ctx)
+	if err != nil {
+		<-b.sem
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// dial opens an authenticated connection on the database
+func (b *Backend) dial(ctx context.Context) (*conn, error) {
+	c, err := dial(ctx, b.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var setup [][]string
+	if b.password != "" {
+		setup = append(setup, []string{"AUTH", b.password})
+	}
+	if b.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(b.db)})
+	}
+	for _, args := range setup {
+		reply, err := c.do(ctx, args...)
+		if err == nil {
+			if e, ok := reply.(Error); ok {
+				err = e
+			}
+		}
+		if err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// put gives back the connection to the pool
+func (b *Backend) put(c *conn, broken bool) {
+	b.mu.Lock()
+	if broken || b.closed {
+		_ = c.Close()
+	} else {
+		b.idle = append(b.idle, c)
+	}
+	b.mu.Unlock()
+	<-b.sem
+}
+
+// parseValue converts the reply of GET to the counter value
+func parseValue(reply interface{}) (int64, error) {
+	switch v := reply.(type) {
+	case nil:
+		return 0, incrmntr.ErrKeyNotFound
+	case Error:
+		return 0, v
+	case string:
+		value, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, errors.New("error counter value is not a number")
+		}
+		return value, nil
+	}
+
+	return 0, errProtocol
+}
+
+// setArgs is the SET command of the value with the expiry and the flags
+func setArgs(key string, value int64, expiry time.Duration, flags ...string) []string {
+	args := []string{"SET", key, strconv.FormatInt(value, 10)}
+	if expiry > 0 {
+		args = append(args, "PX", strconv.FormatInt(expiry.Milliseconds(), 10))
+	}
+
+	return append(args, flags...)
+}
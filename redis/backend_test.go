@@ -0,0 +1,207 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+	"github.com/PumpkinSeed/incrmntr/v2/incrmntrtest"
+	"github.com/PumpkinSeed/incrmntr/v2/redis/redistest"
+)
+
+// newTestIncrementer creates the incrementer on a stand-in server,
+// the returned function closes both of them
+func newTestIncrementer(t *testing.T, rollover uint64, initial int64, cycle bool) (incrmntr.Incrmntr, *redistest.Server, func()) {
+	srv, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err := New(context.Background(), srv.Addr(), WithPoolSize(4), WithPassword("secret"), WithDB(1))
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	inc, _ := incrmntr.NewWithBackend(backend, rollover, initial, 1, cycle)
+
+	return inc, srv, func() {
+		inc.Close()
+		srv.Close()
+	}
+}
+
+func TestBackendAdd(t *testing.T) {
+	inc, srv, closeAll := newTestIncrementer(t, 3, 1, true)
+	defer closeAll()
+
+	var expected = []int64{1, 2, 3, 1, 2}
+	for k, e := range expected {
+		var v incrmntr.NullInt64
+		var err error
+		if k%2 == 0 {
+			v, err = inc.Add("key")
+		} else {
+			v, err = inc.AddSafe("key")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Value != e {
+			t.Errorf("Add %d should return %d, instead of %d", k, e, v.Value)
+		}
+	}
+	if raw, _ := srv.Value("key"); raw != "2" {
+		t.Errorf("Stored value should be 2, instead of %s", raw)
+	}
+
+	if v, _ := inc.AddSafeWithRollover("key", 2); v.Value != 1 {
+		t.Errorf("Custom rollover should cycle back to 1, instead of %d", v.Value)
+	}
+}
+
+func TestBackendWithoutCycle(t *testing.T) {
+	inc, _, closeAll := newTestIncrementer(t, 2, 5, false)
+	defer closeAll()
+
+	for _, e := range []int64{5, 6, 7} {
+		v, err := inc.AddSafe("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Value != e {
+			t.Errorf("AddSafe should return %d, instead of %d", e, v.Value)
+		}
+	}
+}
+
+func TestBackendGetSetReset(t *testing.T) {
+	inc, _, closeAll := newTestIncrementer(t, 999, 1, true)
+	defer closeAll()
+
+	if _, err := inc.Get("missing"); !errors.Is(err, incrmntr.ErrKeyNotFound) {
+		t.Errorf("Get of missing key should fail with ErrKeyNotFound, instead of %v", err)
+	}
+	if err := inc.Set("key", 41); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := inc.AddSafe("key"); v.Value != 42 {
+		t.Errorf("AddSafe should return 42, instead of %d", v.Value)
+	}
+	if err := inc.Reset("key"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := inc.Get("key"); v != 1 {
+		t.Errorf("Get should return 1 after reset, instead of %d", v)
+	}
+}
+
+func TestBackendExpired(t *testing.T) {
+	inc, srv, closeAll := newTestIncrementer(t, 999, 1, true)
+	defer closeAll()
+
+	inc.AddSafe("key")
+	inc.AddSafe("key")
+	srv.Expire("key")
+
+	if v, err := inc.AddSafe("key"); err != nil || v.Value != 1 {
+		t.Errorf("Expired key should be initialized again, instead of %v, %v", v, err)
+	}
+}
+
+func TestBackendExpiredWithoutCycle(t *testing.T) {
+	inc, srv, closeAll := newTestIncrementer(t, 999, 5, false)
+	defer closeAll()
+
+	// ---- the expired key is initialized again, not created with the delta
+	inc.AddSafe("key")
+	inc.AddSafe("key")
+	srv.Expire("key")
+
+	if v, err := inc.AddSafe("key"); err != nil || v.Value != 5 {
+		t.Errorf("Expired key should be initialized again, instead of %v, %v", v, err)
+	}
+}
+
+func TestBackendConcurrentAddSafe(t *testing.T) {
+	inc, _, closeAll := newTestIncrementer(t, 1000000, 1, true)
+	defer closeAll()
+
+	const workers, adds = 8, 50
+	var mu sync.Mutex
+	seen := make(map[int64]bool)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < adds; k++ {
+				v, err := inc.AddSafe("key")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				if seen[v.Value] {
+					t.Errorf("Value %d returned twice", v.Value)
+				}
+				seen[v.Value] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, _ := inc.Get("key"); v != workers*adds {
+		t.Errorf("Value should be %d, instead of %d", workers*adds, v)
+	}
+}
+
+func TestBackendLargeValues(t *testing.T) {
+	// ---- above 2^53 the Lua numbers would lose the last digits
+	inc, srv, closeAll := newTestIncrementer(t, math.MaxInt64, 1, true)
+	defer closeAll()
+
+	if err := inc.Set("key", math.MaxInt64-2); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []int64{math.MaxInt64 - 1, math.MaxInt64, 1} {
+		v, err := inc.AddSafe("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Value != e {
+			t.Errorf("AddSafe should return %d, instead of %d", e, v.Value)
+		}
+	}
+	if raw, _ := srv.Value("key"); raw != "1" {
+		t.Errorf("Stored value should be 1, instead of %s", raw)
+	}
+}
+
+func TestBackendPing(t *testing.T) {
+	inc, _, closeAll := newTestIncrementer(t, 999, 1, true)
+	defer closeAll()
+
+	report, err := inc.(incrmntr.Pinger).Ping(context.Background())
+	if err != nil || !report.Healthy || len(report.Nodes) != 1 || report.Nodes[0].ID != "redis" {
+		t.Errorf("Ping should be healthy with the redis node, instead of %+v, %v", report, err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	incrmntrtest.Run(t, func(t *testing.T) (incrmntr.Backend, func()) {
+		srv, err := redistest.NewServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := New(context.Background(), srv.Addr(), WithPoolSize(4))
+		if err != nil {
+			srv.Close()
+			t.Fatal(err)
+		}
+		return b, func() { srv.Close() }
+	})
+}
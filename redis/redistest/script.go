@@ -0,0 +1,143 @@
+package redistest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// eval runs the script with EVAL or EVALSHA, the lock of the server held,
+// so the script is atomic like on Redis. The scripts run in Lua 5.1 with
+// redis.call, redis.error_reply and redis.status_reply.
+func (s *Server) eval(name string, args []string) interface{} {
+	if len(args) < 2 {
+		return wrongArgs(name)
+	}
+	script := args[0]
+	if name == "EVALSHA" {
+		var ok bool
+		if script, ok = s.scripts[strings.ToLower(args[0])]; !ok {
+			return errorReply("NOSCRIPT No matching script. Please use EVAL.")
+		}
+	} else {
+		sum := sha1.Sum([]byte(script))
+		s.scripts[hex.EncodeToString(sum[:])] = script
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 0 || n > len(args)-2 {
+		return errorReply("ERR Number of keys can't be greater than number of args")
+	}
+
+	L := lua.NewState()
+	defer L.Close()
+	L.SetGlobal("KEYS", stringTable(L, args[2:2+n]))
+	L.SetGlobal("ARGV", stringTable(L, args[2+n:]))
+	redis := L.NewTable()
+	L.SetField(redis, "call", L.NewFunction(func(L *lua.LState) int {
+		call := make([]string, L.GetTop())
+		for k := range call {
+			call[k] = L.ToString(k + 1)
+		}
+		if len(call) == 0 {
+			L.RaiseError("Please specify at least one argument for redis.call()")
+		}
+		reply := s.command(strings.ToUpper(call[0]), call[1:])
+		if e, ok := reply.(errorReply); ok {
+			L.RaiseError("%s", string(e))
+		}
+		L.Push(toLua(L, reply))
+		return 1
+	}))
+	L.SetField(redis, "error_reply", L.NewFunction(func(L *lua.LState) int {
+		t := L.NewTable()
+		L.SetField(t, "err", lua.LString(L.CheckString(1)))
+		L.Push(t)
+		return 1
+	}))
+	L.SetField(redis, "status_reply", L.NewFunction(func(L *lua.LState) int {
+		t := L.NewTable()
+		L.SetField(t, "ok", lua.LString(L.CheckString(1)))
+		L.Push(t)
+		return 1
+	}))
+	L.SetGlobal("redis", redis)
+
+	if err := L.DoString(script); err != nil {
+		return errorReply("ERR Error running script: " + err.Error())
+	}
+	if L.GetTop() == 0 {
+		return nil
+	}
+
+	return fromLua(L.Get(-1))
+}
+
+func stringTable(L *lua.LState, values []string) *lua.LTable {
+	t := L.NewTable()
+	for _, v := range values {
+		t.Append(lua.LString(v))
+	}
+
+	return t
+}
+
+// toLua converts the reply of a command like Redis: the integers to
+// numbers, the nil to false, the status and the arrays to tables
+func toLua(L *lua.LState, reply interface{}) lua.LValue {
+	switch v := reply.(type) {
+	case int64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case statusReply:
+		t := L.NewTable()
+		L.SetField(t, "ok", lua.LString(v))
+		return t
+	case []interface{}:
+		t := L.NewTable()
+		for _, item := range v {
+			t.Append(toLua(L, item))
+		}
+		return t
+	}
+
+	return lua.LFalse
+}
+
+// fromLua converts the value returned by the script like Redis: the
+// numbers truncated to integers, the tables to arrays up to the first
+// nil, or to a status or an error reply if they have an ok or err field
+func fromLua(value lua.LValue) interface{} {
+	switch v := value.(type) {
+	case lua.LNumber:
+		return int64(v)
+	case lua.LString:
+		return string(v)
+	case lua.LBool:
+		if v {
+			return int64(1)
+		}
+		return nil
+	case *lua.LTable:
+		if e, ok := v.RawGetString("err").(lua.LString); ok {
+			return errorReply(e)
+		}
+		if s, ok := v.RawGetString("ok").(lua.LString); ok {
+			return statusReply(s)
+		}
+		var items []interface{}
+		for k := 1; ; k++ {
+			item := v.RawGetInt(k)
+			if item == lua.LNil {
+				break
+			}
+			items = append(items, fromLua(item))
+		}
+		return items
+	}
+
+	return nil
+}
@@ -0,0 +1,450 @@
+// Package redistest is an in-process stand-in of a Redis server for
+// the tests, it implements only the commands used by the redis backend:
+// PING, AUTH, SELECT, GET, SET (NX, XX, EX, PX), INCRBY, PEXPIRE, PERSIST,
+// DEL, EVAL, EVALSHA, WATCH, UNWATCH, MULTI, EXEC, DISCARD and FLUSHALL.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is the stand-in server listening on a random local port
+type Server struct {
+	ln net.Listener
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	data     map[string]entry
+	versions map[string]uint64
+	scripts  map[string]string
+	conns    map[net.Conn]struct{}
+	closed   bool
+	now      func() time.Time
+}
+
+type entry struct {
+	value   string
+	expires time.Time
+}
+
+// NewServer starts the server
+func NewServer() (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		ln:       ln,
+		data:     make(map[string]entry),
+		versions: make(map[string]uint64),
+		scripts:  make(map[string]string),
+		conns:    make(map[net.Conn]struct{}),
+		now:      time.Now,
+	}
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+// Addr is the address of the server
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Close stops the server and closes the connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.conns {
+		_ = c.Close()
+	}
+	s.mu.Unlock()
+
+	err := s.ln.Close()
+	s.wg.Wait()
+
+	return err
+}
+
+// Value returns the raw value of the key
+func (s *Server) Value(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.lookup(key)
+	return e.value, ok
+}
+
+// Expire expires the key immediately, as if its expiry passed
+func (s *Server) Expire(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data[key]; ok {
+		delete(s.data, key)
+		s.versions[key]++
+	}
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = c.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+// session is the state of the transaction of a connection
+type session struct {
+	watched map[string]uint64
+	multi   bool
+	queued  [][]string
+}
+
+func (s *Server) handle(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		_ = c.Close()
+	}()
+
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	sess := &session{}
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		writeReply(w, s.exec(sess, args))
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// errorReply is an error reply
+type errorReply string
+
+// statusReply is a simple string reply
+type statusReply string
+
+// nullArray is the reply of the aborted EXEC
+type nullArray struct{}
+
+// exec runs the command in the session, the reply is int64, string,
+// statusReply, errorReply, nullArray, nil or []interface{}
+func (s *Server) exec(sess *session, args []string) interface{} {
+	if len(args) == 0 {
+		return errorReply("ERR empty command")
+	}
+	name := strings.ToUpper(args[0])
+
+	switch name {
+	case "MULTI":
+		if sess.multi {
+			return errorReply("ERR MULTI calls can not be nested")
+		}
+		sess.multi = true
+		return statusReply("OK")
+	case "DISCARD":
+		if !sess.multi {
+			return errorReply("ERR DISCARD without MULTI")
+		}
+		sess.multi, sess.queued, sess.watched = false, nil, nil
+		return statusReply("OK")
+	case "EXEC":
+		if !sess.multi {
+			return errorReply("ERR EXEC without MULTI")
+		}
+		return s.commit(sess)
+	case "WATCH":
+		if sess.multi {
+			return errorReply("ERR WATCH inside MULTI is not allowed")
+		}
+		s.mu.Lock()
+		if sess.watched == nil {
+			sess.watched = make(map[string]uint64)
+		}
+		for _, key := range args[1:] {
+			s.lookup(key)
+			sess.watched[key] = s.versions[key]
+		}
+		s.mu.Unlock()
+		return statusReply("OK")
+	case "UNWATCH":
+		sess.watched = nil
+		return statusReply("OK")
+	}
+
+	if sess.multi {
+		sess.queued = append(sess.queued, args)
+		return statusReply("QUEUED")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.command(name, args[1:])
+}
+
+// commit runs the queued commands if none of the watched keys changed
+func (s *Server) commit(sess *session) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queued, watched := sess.queued, sess.watched
+	sess.multi, sess.queued, sess.watched = false, nil, nil
+	for key, version := range watched {
+		s.lookup(key)
+		if s.versions[key] != version {
+			return nullArray{}
+		}
+	}
+
+	replies := make([]interface{}, 0, len(queued))
+	for _, args := range queued {
+		replies = append(replies, s.command(strings.ToUpper(args[0]), args[1:]))
+	}
+
+	return replies
+}
+
+// command runs a single data command, the lock of the server held
+func (s *Server) command(name string, args []string) interface{} {
+	switch name {
+	case "PING":
+		return statusReply("PONG")
+	case "AUTH", "SELECT":
+		return statusReply("OK")
+	case "FLUSHALL":
+		for key := range s.data {
+			s.versions[key]++
+		}
+		s.data = make(map[string]entry)
+		return statusReply("OK")
+	case "GET":
+		if len(args) != 1 {
+			return wrongArgs(name)
+		}
+		if e, ok := s.lookup(args[0]); ok {
+			return e.value
+		}
+		return nil
+	case "SET":
+		return s.set(args)
+	case "INCRBY":
+		if len(args) != 2 {
+			return wrongArgs(name)
+		}
+		delta, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return errorReply("ERR value is not an integer or out of range")
+		}
+		e, _ := s.lookup(args[0])
+		current := int64(0)
+		if e.value != "" {
+			if current, err = strconv.ParseInt(e.value, 10, 64); err != nil {
+				return errorReply("ERR value is not an integer or out of range")
+			}
+		}
+		e.value = strconv.FormatInt(current+delta, 10)
+		s.write(args[0], e)
+		return current + delta
+	case "PEXPIRE":
+		if len(args) != 2 {
+			return wrongArgs(name)
+		}
+		ms, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return errorReply("ERR value is not an integer or out of range")
+		}
+		e, ok := s.lookup(args[0])
+		if !ok {
+			return int64(0)
+		}
+		e.expires = s.now().Add(time.Duration(ms) * time.Millisecond)
+		s.write(args[0], e)
+		return int64(1)
+	case "PERSIST":
+		if len(args) != 1 {
+			return wrongArgs(name)
+		}
+		e, ok := s.lookup(args[0])
+		if !ok || e.expires.IsZero() {
+			return int64(0)
+		}
+		e.expires = time.Time{}
+		s.write(args[0], e)
+		return int64(1)
+	case "EVAL", "EVALSHA":
+		return s.eval(name, args)
+	case "DEL":
+		var n int64
+		for _, key := range args {
+			if _, ok := s.lookup(key); ok {
+				delete(s.data, key)
+				s.versions[key]++
+				n++
+			}
+		}
+		return n
+	}
+
+	return errorReply(fmt.Sprintf("ERR unknown command '%s'", name))
+}
+
+// set is the SET command with the NX, XX, EX and PX options
+func (s *Server) set(args []string) interface{} {
+	if len(args) < 2 {
+		return wrongArgs("SET")
+	}
+	key, e := args[0], entry{value: args[1]}
+
+	var nx, xx bool
+	for k := 2; k < len(args); k++ {
+		switch strings.ToUpper(args[k]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if k+1 >= len(args) {
+				return errorReply("ERR syntax error")
+			}
+			n, err := strconv.ParseInt(args[k+1], 10, 64)
+			if err != nil || n <= 0 {
+				return errorReply("ERR invalid expire time in 'set' command")
+			}
+			unit := time.Millisecond
+			if strings.ToUpper(args[k]) == "EX" {
+				unit = time.Second
+			}
+			e.expires = s.now().Add(time.Duration(n) * unit)
+			k++
+		default:
+			return errorReply("ERR syntax error")
+		}
+	}
+
+	_, exists := s.lookup(key)
+	if (nx && exists) || (xx && !exists) {
+		return nil
+	}
+	s.write(key, e)
+
+	return statusReply("OK")
+}
+
+// lookup returns the key, the expired keys deleted first
+func (s *Server) lookup(key string) (entry, bool) {
+	e, ok := s.data[key]
+	if ok && !e.expires.IsZero() && !s.now().Before(e.expires) {
+		delete(s.data, key)
+		s.versions[key]++
+		return entry{}, false
+	}
+
+	return e, ok
+}
+
+func (s *Server) write(key string, e entry) {
+	s.data[key] = e
+	s.versions[key]++
+}
+
+func wrongArgs(name string) errorReply {
+	return errorReply(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+}
+
+// readCommand reads an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, n)
+	for k := 0; k < n; k++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("expected bulk string, got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case nullArray:
+		w.WriteString("*-1\r\n")
+	case statusReply:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case errorReply:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	}
+}
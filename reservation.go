@@ -0,0 +1,303 @@
+package incrmntr
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+var (
+	// ErrReservationNotFound returned when the token doesn't belong to
+	// a pending reservation, it's committed, released or expired already
+	ErrReservationNotFound = errors.New("reservation not found")
+
+	// ErrInvalidToken returned when the reservation token is malformed
+	ErrInvalidToken = errors.New("invalid reservation token")
+)
+
+// reservationLockTime is the lock duration of the reservation document
+const reservationLockTime = 500 * time.Millisecond
+
+// Backoff of the retries of the locked reservation document
+const (
+	reservationMinBackoff = 5 * time.Millisecond
+	reservationMaxBackoff = 200 * time.Millisecond
+)
+
+// Reservation is a number reserved from the counter, it isn't used until
+// committed and goes back to the free list if released or expired
+type Reservation struct {
+	Value   int64
+	Token   string
+	Expires time.Time
+}
+
+// reservationState is the document persisted next to the counter key,
+// High is the last number allocated, so a number is allocated and
+// reserved in the same write of the document
+type reservationState struct {
+	Free    []int64                       `json:"free"`
+	Pending map[string]pendingReservation `json:"pending"`
+	High    int64                         `json:"high"`
+	Seeded  bool                          `json:"seeded"`
+}
+
+type pendingReservation struct {
+	Value   int64 `json:"value"`
+	Expires int64 `json:"expires"`
+}
+
+// Reserver hands out gap-free numbers of the counter keys. The numbers
+// of released and expired reservations are reused first by Reserve, so
+// every number allocated eventually gets committed. The numbers are
+// allocated in the reservations document of the key, the counter key is
+// read only once to start from its value, so it shouldn't be incremented
+// besides the Reserver.
+type Reserver struct {
+	inc *Incrementer
+	ttl time.Duration
+	now func() time.Time
+}
+
+// NewReserver creates a Reserver on the incrementer, reservations not
+// committed in ttl returned to the free list
+func NewReserver(inc *Incrementer, ttl time.Duration) *Reserver {
+	return &Reserver{
+		inc: inc,
+		ttl: ttl,
+		now: time.Now,
+	}
+}
+
+// Reserve returns the lowest free number of the key, or the next
+// number of the counter if there is no free one
+func (r *Reserver) Reserve(key string) (Reservation, error) {
+	return r.ReserveContext(context.Background(), key)
+}
+
+// ReserveContext is Reserve bound by the context
+func (r *Reserver) ReserveContext(ctx context.Context, key string) (Reservation, error) {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return Reservation{}, err
+	}
+	token := hex.EncodeToString(id) + ":" + key
+	expires := r.now().Add(r.ttl)
+
+	var value int64
+	err := r.update(ctx, key, func(state *reservationState) error {
+		value = r.allocate(state)
+		state.Pending[token] = pendingReservation{Value: value, Expires: expires.UnixNano()}
+		return nil
+	})
+	if err != nil {
+		return Reservation{}, err
+	}
+
+	return Reservation{Value: value, Token: token, Expires: expires}, nil
+}
+
+// allocate takes the lowest free number or the next one after the high
+// water mark, the number is allocated by the write of the state
+func (r *Reserver) allocate(state *reservationState) int64 {
+	if len(state.Free) > 0 {
+		value := state.Free[0]
+		state.Free = state.Free[1:]
+		return value
+	}
+	state.High, _ = AddOp{
+		Delta:    r.inc.inc,
+		Rollover: r.inc.rollover,
+		Initial:  r.inc.initial,
+		Cycle:    r.inc.cycle,
+	}.Apply(state.High)
+
+	return state.High
+}
+
+// Commit finalises the reservation, the number won't be handed out again
+func (r *Reserver) Commit(token string) error {
+	return r.CommitContext(context.Background(), token)
+}
+
+// CommitContext is Commit bound by the context
+func (r *Reserver) CommitContext(ctx context.Context, token string) error {
+	key, err := reservationKey(token)
+	if err != nil {
+		return err
+	}
+
+	return r.update(ctx, key, func(state *reservationState) error {
+		if _, ok := state.Pending[token]; !ok {
+			return ErrReservationNotFound
+		}
+		delete(state.Pending, token)
+		return nil
+	})
+}
+
+// Release gives back the reserved number to the free list
+func (r *Reserver) Release(token string) error {
+	return r.ReleaseContext(context.Background(), token)
+}
+
+// ReleaseContext is Release bound by the context
+func (r *Reserver) ReleaseContext(ctx context.Context, token string) error {
+	key, err := reservationKey(token)
+	if err != nil {
+		return err
+	}
+
+	return r.update(ctx, key, func(state *reservationState) error {
+		pending, ok := state.Pending[token]
+		if !ok {
+			return ErrReservationNotFound
+		}
+		delete(state.Pending, token)
+		state.Free = append(state.Free, pending.Value)
+		return nil
+	})
+}
+
+// update locks the reservation document of the key, moves the expired
+// reservations to the free list and writes back the modified state with
+// the CAS of the lock, the locked document and the lost CAS are retried
+// with backoff until the context is done
+func (r *Reserver) update(ctx context.Context, key string, fn func(state *reservationState) error) error {
+	if err := r.inc.acquire(); err != nil {
+		return err
+	}
+	defer r.inc.release()
+
+	if r.inc.bucket == nil {
+		return errors.New("error bucket is nil")
+	}
+	collection := r.inc.bucket.DefaultCollection()
+	stateKey := key + "::reservations"
+
+	backoff := reservationMinBackoff
+	retry := func() error {
+		timer := time.NewTimer(backoff)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > reservationMaxBackoff {
+			backoff = reservationMaxBackoff
+		}
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		res, err := collection.GetAndLock(stateKey, reservationLockTime, &gocb.GetAndLockOptions{
+			Timeout: r.inc.GetTimeout(),
+		})
+		if errors.Is(err, gocb.ErrDocumentNotFound) {
+			// ---- create the empty state, the loser of the race retries
+			_, err = collection.Insert(stateKey, reservationState{Free: []int64{}, Pending: map[string]pendingReservation{}}, &gocb.InsertOptions{
+				Timeout: r.inc.GetTimeout(),
+			})
+			if err != nil && !errors.Is(err, gocb.ErrDocumentExists) {
+				return err
+			}
+			continue
+		}
+		if errors.Is(err, gocb.ErrTemporaryFailure) || errors.Is(err, gocb.ErrDocumentLocked) {
+			if err := retry(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		var state reservationState
+		if err := res.Content(&state); err != nil {
+			_ = collection.Unlock(stateKey, res.Cas(), nil)
+			return err
+		}
+		if state.Pending == nil {
+			state.Pending = map[string]pendingReservation{}
+		}
+		if !state.Seeded {
+			if err := r.seed(ctx, key, &state); err != nil {
+				_ = collection.Unlock(stateKey, res.Cas(), nil)
+				return err
+			}
+		}
+		r.expire(&state)
+
+		if err := fn(&state); err != nil {
+			_ = collection.Unlock(stateKey, res.Cas(), nil)
+			return err
+		}
+
+		// ---- the lock expired before the write, nothing is written
+		// or allocated, so the whole update is retried
+		_, err = collection.Replace(stateKey, state, &gocb.ReplaceOptions{
+			Cas:     res.Cas(),
+			Timeout: r.inc.GetTimeout(),
+		})
+		if errors.Is(err, gocb.ErrCasMismatch) || errors.Is(err, gocb.ErrDocumentLocked) || errors.Is(err, gocb.ErrTemporaryFailure) {
+			if err := retry(); err != nil {
+				return err
+			}
+			continue
+		}
+		return err
+	}
+}
+
+// seed starts the allocation of the state from the value of the counter
+// key, the first number of a missing key is the initial value
+func (r *Reserver) seed(ctx context.Context, key string, state *reservationState) error {
+	value, err := r.inc.GetContext(ctx, key)
+	switch {
+	case errors.Is(err, gocb.ErrDocumentNotFound):
+		state.High = r.inc.initial - int64(r.inc.inc)
+	case err != nil:
+		return err
+	default:
+		state.High = value
+	}
+	state.Seeded = true
+
+	return nil
+}
+
+// expire moves the expired reservations to the free list,
+// the free list kept sorted so the lowest number reused first
+func (r *Reserver) expire(state *reservationState) {
+	now := r.now().UnixNano()
+	for token, pending := range state.Pending {
+		if pending.Expires <= now {
+			delete(state.Pending, token)
+			state.Free = append(state.Free, pending.Value)
+		}
+	}
+	sort.Slice(state.Free, func(a, b int) bool {
+		return state.Free[a] < state.Free[b]
+	})
+}
+
+// reservationKey extracts the counter key from the token
+func reservationKey(token string) (string, error) {
+	sep := strings.IndexByte(token, ':')
+	if sep <= 0 || sep == len(token)-1 {
+		return "", ErrInvalidToken
+	}
+
+	return token[sep+1:], nil
+}
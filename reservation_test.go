@@ -0,0 +1,102 @@
+package incrmntr
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+func TestReserverExpire(t *testing.T) {
+	var now = time.Unix(1000, 0)
+	r := &Reserver{now: func() time.Time { return now }}
+	state := reservationState{
+		Free: []int64{9},
+		Pending: map[string]pendingReservation{
+			"a:key": {Value: 4, Expires: now.Add(-time.Second).UnixNano()},
+			"b:key": {Value: 5, Expires: now.Add(time.Second).UnixNano()},
+		},
+	}
+
+	r.expire(&state)
+	if len(state.Free) != 2 || state.Free[0] != 4 || state.Free[1] != 9 {
+		t.Errorf("Free list should be [4 9], instead of %v", state.Free)
+	}
+	if _, ok := state.Pending["b:key"]; !ok || len(state.Pending) != 1 {
+		t.Errorf("Only the living reservation should be pending, instead of %v", state.Pending)
+	}
+}
+
+func TestReserverAllocate(t *testing.T) {
+	r := &Reserver{inc: &Incrementer{rollover: 3, initial: 1, inc: 1, cycle: true}}
+	state := reservationState{Free: []int64{2}, High: 1}
+
+	// ---- the free number first, then the next ones after the high water mark
+	for _, expected := range []int64{2, 2, 3, 1} {
+		if value := r.allocate(&state); value != expected {
+			t.Fatalf("Value should be %d, instead of %d", expected, value)
+		}
+	}
+	if state.High != 1 || len(state.Free) != 0 {
+		t.Errorf("High water mark should be 1, instead of %d", state.High)
+	}
+}
+
+func TestReservationKey(t *testing.T) {
+	key, err := reservationKey("0a1b:invoice:2026")
+	if err != nil {
+		t.Error(err)
+	}
+	if key != "invoice:2026" {
+		t.Errorf("Key should be invoice:2026, instead of %s", key)
+	}
+	for _, token := range []string{"", "abc", ":key", "abc:"} {
+		if _, err := reservationKey(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Token %q should be invalid, instead of %v", token, err)
+		}
+	}
+}
+
+func TestReserver(t *testing.T) {
+	var key = xid.New().String()
+
+	bucket, closeCluster := getBucket()
+	defer closeCluster(nil)
+
+	inc, err := New(bucket, 999, 1, 1, false)
+	if err != nil {
+		t.Error(err)
+	}
+	r := NewReserver(inc.(*Incrementer), time.Minute)
+
+	first, err := r.Reserve(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := r.Reserve(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Release(first.Token); err != nil {
+		t.Error(err)
+	}
+	if err := r.Commit(second.Token); err != nil {
+		t.Error(err)
+	}
+	if err := r.Commit(first.Token); !errors.Is(err, ErrReservationNotFound) {
+		t.Errorf("Error should be ErrReservationNotFound, instead of %v", err)
+	}
+
+	// ---- the released number reused before a new one allocated
+	third, err := r.Reserve(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Value != first.Value {
+		t.Errorf("Value should be %d, instead of %d", first.Value, third.Value)
+	}
+	if err := r.Commit(third.Token); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,334 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: incrmntr.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Counter_Next_FullMethodName  = "/incrmntr.Counter/Next"
+	Counter_NextN_FullMethodName = "/incrmntr.Counter/NextN"
+	Counter_Get_FullMethodName   = "/incrmntr.Counter/Get"
+	Counter_Set_FullMethodName   = "/incrmntr.Counter/Set"
+	Counter_Reset_FullMethodName = "/incrmntr.Counter/Reset"
+	Counter_Watch_FullMethodName = "/incrmntr.Counter/Watch"
+)
+
+// CounterClient is the client API for Counter service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CounterClient interface {
+	// Next increments the key with AddSafe, rollover 0 means the default
+	Next(ctx context.Context, in *NextRequest, opts ...grpc.CallOption) (*ValueResponse, error)
+	// NextN increments the key n times and returns every value
+	NextN(ctx context.Context, in *NextNRequest, opts ...grpc.CallOption) (*ValuesResponse, error)
+	// Get returns the current value of the key
+	Get(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*ValueResponse, error)
+	// Set overwrites the value of the key
+	Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*ValueResponse, error)
+	// Reset puts back the key to the initial value
+	Reset(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*ValueResponse, error)
+	// Watch streams the value changes of the key
+	Watch(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (Counter_WatchClient, error)
+}
+
+type counterClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCounterClient(cc grpc.ClientConnInterface) CounterClient {
+	return &counterClient{cc}
+}
+
+func (c *counterClient) Next(ctx context.Context, in *NextRequest, opts ...grpc.CallOption) (*ValueResponse, error) {
+	out := new(ValueResponse)
+	err := c.cc.Invoke(ctx, Counter_Next_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *counterClient) NextN(ctx context.Context, in *NextNRequest, opts ...grpc.CallOption) (*ValuesResponse, error) {
+	out := new(ValuesResponse)
+	err := c.cc.Invoke(ctx, Counter_NextN_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *counterClient) Get(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*ValueResponse, error) {
+	out := new(ValueResponse)
+	err := c.cc.Invoke(ctx, Counter_Get_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *counterClient) Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*ValueResponse, error) {
+	out := new(ValueResponse)
+	err := c.cc.Invoke(ctx, Counter_Set_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *counterClient) Reset(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*ValueResponse, error) {
+	out := new(ValueResponse)
+	err := c.cc.Invoke(ctx, Counter_Reset_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *counterClient) Watch(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (Counter_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Counter_ServiceDesc.Streams[0], Counter_Watch_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &counterWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Counter_WatchClient interface {
+	Recv() (*ChangeEvent, error)
+	grpc.ClientStream
+}
+
+type counterWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *counterWatchClient) Recv() (*ChangeEvent, error) {
+	m := new(ChangeEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// CounterServer is the server API for Counter service.
+// All implementations must embed UnimplementedCounterServer
+// for forward compatibility
+type CounterServer interface {
+	// Next increments the key with AddSafe, rollover 0 means the default
+	Next(context.Context, *NextRequest) (*ValueResponse, error)
+	// NextN increments the key n times and returns every value
+	NextN(context.Context, *NextNRequest) (*ValuesResponse, error)
+	// Get returns the current value of the key
+	Get(context.Context, *KeyRequest) (*ValueResponse, error)
+	// Set overwrites the value of the key
+	Set(context.Context, *SetRequest) (*ValueResponse, error)
+	// Reset puts back the key to the initial value
+	Reset(context.Context, *KeyRequest) (*ValueResponse, error)
+	// Watch streams the value changes of the key
+	Watch(*KeyRequest, Counter_WatchServer) error
+	mustEmbedUnimplementedCounterServer()
+}
+
+// UnimplementedCounterServer must be embedded to have forward compatible implementations.
+type UnimplementedCounterServer struct {
+}
+
+func (UnimplementedCounterServer) Next(context.Context, *NextRequest) (*ValueResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Next not implemented")
+}
+func (UnimplementedCounterServer) NextN(context.Context, *NextNRequest) (*ValuesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NextN not implemented")
+}
+func (UnimplementedCounterServer) Get(context.Context, *KeyRequest) (*ValueResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedCounterServer) Set(context.Context, *SetRequest) (*ValueResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Set not implemented")
+}
+func (UnimplementedCounterServer) Reset(context.Context, *KeyRequest) (*ValueResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reset not implemented")
+}
+func (UnimplementedCounterServer) Watch(*KeyRequest, Counter_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedCounterServer) mustEmbedUnimplementedCounterServer() {}
+
+// UnsafeCounterServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CounterServer will
+// result in compilation errors.
+type UnsafeCounterServer interface {
+	mustEmbedUnimplementedCounterServer()
+}
+
+func RegisterCounterServer(s grpc.ServiceRegistrar, srv CounterServer) {
+	s.RegisterService(&Counter_ServiceDesc, srv)
+}
+
+func _Counter_Next_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NextRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CounterServer).Next(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Counter_Next_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CounterServer).Next(ctx, req.(*NextRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Counter_NextN_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NextNRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CounterServer).NextN(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Counter_NextN_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CounterServer).NextN(ctx, req.(*NextNRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Counter_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CounterServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Counter_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CounterServer).Get(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Counter_Set_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CounterServer).Set(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Counter_Set_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CounterServer).Set(ctx, req.(*SetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Counter_Reset_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CounterServer).Reset(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Counter_Reset_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CounterServer).Reset(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Counter_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(KeyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CounterServer).Watch(m, &counterWatchServer{stream})
+}
+
+type Counter_WatchServer interface {
+	Send(*ChangeEvent) error
+	grpc.ServerStream
+}
+
+type counterWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *counterWatchServer) Send(m *ChangeEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Counter_ServiceDesc is the grpc.ServiceDesc for Counter service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Counter_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "incrmntr.Counter",
+	HandlerType: (*CounterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Next",
+			Handler:    _Counter_Next_Handler,
+		},
+		{
+			MethodName: "NextN",
+			Handler:    _Counter_NextN_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _Counter_Get_Handler,
+		},
+		{
+			MethodName: "Set",
+			Handler:    _Counter_Set_Handler,
+		},
+		{
+			MethodName: "Reset",
+			Handler:    _Counter_Reset_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _Counter_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "incrmntr.proto",
+}
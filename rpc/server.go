@@ -0,0 +1,260 @@
+// Package rpc is the gRPC interface of the counters, the service
+// defined in incrmntr.proto and mapped onto the Incrmntr methods.
+// The messages and the service stubs are generated from the proto.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative incrmntr.proto
+
+import (
+	"context"
+	"errors"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+	"github.com/couchbase/gocb/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxNextN is the highest n of a NextN call
+const maxNextN = 1000
+
+// watcher is implemented by the incrementers supporting Watch
+type watcher interface {
+	Watch(ctx context.Context, key string) (<-chan incrmntr.Change, error)
+}
+
+func isWatcher(inc incrmntr.Incrmntr) bool {
+	_, ok := inc.(watcher)
+	return ok
+}
+
+// Server implements CounterServer with an Incrmntr
+type Server struct {
+	UnimplementedCounterServer
+
+	inc incrmntr.Incrmntr
+}
+
+// NewServer creates the service implementation on the incrementer
+func NewServer(inc incrmntr.Incrmntr) *Server {
+	return &Server{inc: inc}
+}
+
+// Next increments the key with AddSafe
+func (s *Server) Next(ctx context.Context, req *NextRequest) (*ValueResponse, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is empty")
+	}
+
+	var value incrmntr.NullInt64
+	err := call(ctx, func() error {
+		var err error
+		value, err = s.next(ctx, req.Key, req.Rollover)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ValueResponse{Key: req.Key, Value: value.Value}, nil
+}
+
+// NextN increments the key n times, stops at the first error, the values
+// given before the error are used up and returned in the details of the
+// status, see PartialValues
+func (s *Server) NextN(ctx context.Context, req *NextNRequest) (*ValuesResponse, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is empty")
+	}
+	if req.N < 1 || req.N > maxNextN {
+		return nil, status.Errorf(codes.InvalidArgument, "n should be between 1 and %d", maxNextN)
+	}
+
+	values := make([]int64, 0, req.N)
+	for i := uint32(0); i < req.N; i++ {
+		var value incrmntr.NullInt64
+		err := call(ctx, func() error {
+			var err error
+			value, err = s.next(ctx, req.Key, req.Rollover)
+			return err
+		})
+		if err != nil {
+			return nil, withValues(err, req.Key, values)
+		}
+		values = append(values, value.Value)
+	}
+
+	return &ValuesResponse{Key: req.Key, Values: values}, nil
+}
+
+// Get returns the current value of the key
+func (s *Server) Get(ctx context.Context, req *KeyRequest) (*ValueResponse, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is empty")
+	}
+
+	var value int64
+	err := call(ctx, func() error {
+		var err error
+		value, err = s.get(ctx, req.Key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ValueResponse{Key: req.Key, Value: value}, nil
+}
+
+// Set overwrites the value of the key
+func (s *Server) Set(ctx context.Context, req *SetRequest) (*ValueResponse, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is empty")
+	}
+
+	err := call(ctx, func() error {
+		if c, ok := s.inc.(incrmntr.ContextIncrmntr); ok {
+			return c.SetContext(ctx, req.Key, req.Value)
+		}
+		return s.inc.Set(req.Key, req.Value)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ValueResponse{Key: req.Key, Value: req.Value}, nil
+}
+
+// Reset puts back the key to the initial value
+func (s *Server) Reset(ctx context.Context, req *KeyRequest) (*ValueResponse, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is empty")
+	}
+
+	var value int64
+	err := call(ctx, func() error {
+		var err error
+		if c, ok := s.inc.(incrmntr.ContextIncrmntr); ok {
+			err = c.ResetContext(ctx, req.Key)
+		} else {
+			err = s.inc.Reset(req.Key)
+		}
+		if err != nil {
+			return err
+		}
+		value, err = s.get(ctx, req.Key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ValueResponse{Key: req.Key, Value: value}, nil
+}
+
+// Watch streams the changes of the key until the client cancels
+func (s *Server) Watch(req *KeyRequest, stream Counter_WatchServer) error {
+	if req.Key == "" {
+		return status.Error(codes.InvalidArgument, "key is empty")
+	}
+	w, ok := incrmntr.Lookup(s.inc, isWatcher)
+	if !ok {
+		return status.Error(codes.Unimplemented, "incrementer doesn't support watch")
+	}
+
+	changes, err := w.(watcher).Watch(stream.Context(), req.Key)
+	if err != nil {
+		return statusError(err)
+	}
+	for change := range changes {
+		err := stream.Send(&ChangeEvent{
+			Key:      change.Key,
+			Value:    change.Value,
+			Previous: change.Previous,
+			Rollover: change.Rollover,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return statusError(stream.Context().Err())
+}
+
+// next calls AddSafe or AddSafeWithRollover if rollover set
+func (s *Server) next(ctx context.Context, key string, rollover uint64) (incrmntr.NullInt64, error) {
+	c, ok := s.inc.(incrmntr.ContextIncrmntr)
+	switch {
+	case ok && rollover > 0:
+		return c.AddSafeWithRolloverContext(ctx, key, rollover)
+	case ok:
+		return c.AddSafeContext(ctx, key)
+	case rollover > 0:
+		return s.inc.AddSafeWithRollover(key, rollover)
+	}
+	return s.inc.AddSafe(key)
+}
+
+// get calls Get with the context if the incrementer takes it
+func (s *Server) get(ctx context.Context, key string) (int64, error) {
+	if c, ok := s.inc.(incrmntr.ContextIncrmntr); ok {
+		return c.GetContext(ctx, key)
+	}
+	return s.inc.Get(key)
+}
+
+// call runs the incrementer call if the request isn't done yet, the call
+// is bound by the request context if the incrementer takes it, otherwise
+// by the timeout of the incrementer
+func call(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return statusError(err)
+	}
+
+	return statusError(fn())
+}
+
+// withValues attaches the values given before the error
+// to the details of the status
+func withValues(err error, key string, values []int64) error {
+	if len(values) == 0 {
+		return err
+	}
+	st, err2 := status.Convert(err).WithDetails(&ValuesResponse{Key: key, Values: values})
+	if err2 != nil {
+		return err
+	}
+
+	return st.Err()
+}
+
+// PartialValues returns the values given by a failed NextN call
+func PartialValues(err error) []int64 {
+	for _, detail := range status.Convert(err).Details() {
+		if v, ok := detail.(*ValuesResponse); ok {
+			return v.Values
+		}
+	}
+
+	return nil
+}
+
+// statusError maps the errors of the library to gRPC status codes
+func statusError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, gocb.ErrTimeout):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, gocb.ErrDocumentNotFound), errors.Is(err, incrmntr.ErrKeyNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, gocb.ErrDocumentLocked), errors.Is(err, gocb.ErrTemporaryFailure), errors.Is(err, incrmntr.ErrClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, incrmntr.ErrTxnConflict), errors.Is(err, gocb.ErrCasMismatch), errors.Is(err, incrmntr.ErrConflict):
+		return status.Error(codes.Aborted, err.Error())
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,316 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+	"github.com/PumpkinSeed/incrmntr/v2/cache"
+	"github.com/couchbase/gocb/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// memoryIncrementer is an in-memory Incrmntr for the service tests
+type memoryIncrementer struct {
+	sync.Mutex
+	rollover uint64
+	initial  int64
+	values   map[string]int64
+	changes  chan incrmntr.Change
+	block    chan struct{}
+
+	// failAfter fails the adds after the given number of adds if it's set
+	failAfter int
+	adds      int
+}
+
+func newMemoryIncrementer() *memoryIncrementer {
+	return &memoryIncrementer{
+		rollover: 999,
+		initial:  1,
+		values:   map[string]int64{},
+		changes:  make(chan incrmntr.Change, 10),
+	}
+}
+
+func (m *memoryIncrementer) Get(key string) (int64, error) {
+	m.Lock()
+	defer m.Unlock()
+	v, ok := m.values[key]
+	if !ok {
+		return 0, gocb.ErrDocumentNotFound
+	}
+	return v, nil
+}
+
+func (m *memoryIncrementer) Add(key string) (incrmntr.NullInt64, error) {
+	return m.AddWithRollover(key, m.rollover)
+}
+
+func (m *memoryIncrementer) AddSafe(key string) (incrmntr.NullInt64, error) {
+	return m.AddWithRollover(key, m.rollover)
+}
+
+func (m *memoryIncrementer) AddWithRollover(key string, rollover uint64) (incrmntr.NullInt64, error) {
+	return m.AddSafeWithRolloverContext(context.Background(), key, rollover)
+}
+
+func (m *memoryIncrementer) AddSafeWithRolloverContext(ctx context.Context, key string, rollover uint64) (incrmntr.NullInt64, error) {
+	if m.block != nil {
+		select {
+		case <-m.block:
+		case <-ctx.Done():
+			return incrmntr.NullInt64{}, ctx.Err()
+		}
+	}
+	m.Lock()
+	defer m.Unlock()
+	if m.failAfter > 0 && m.adds >= m.failAfter {
+		return incrmntr.NullInt64{}, gocb.ErrDocumentLocked
+	}
+	m.adds++
+	v, ok := m.values[key]
+	if !ok {
+		v = m.initial - 1
+	}
+	v++
+	if v > int64(rollover) {
+		v = m.initial
+	}
+	m.values[key] = v
+	return incrmntr.NullInt64{Valid: true, Value: v}, nil
+}
+
+func (m *memoryIncrementer) AddSafeWithRollover(key string, rollover uint64) (incrmntr.NullInt64, error) {
+	return m.AddWithRollover(key, rollover)
+}
+
+func (m *memoryIncrementer) AddContext(ctx context.Context, key string) (incrmntr.NullInt64, error) {
+	return m.AddSafeWithRolloverContext(ctx, key, m.rollover)
+}
+
+func (m *memoryIncrementer) AddWithRolloverContext(ctx context.Context, key string, rollover uint64) (incrmntr.NullInt64, error) {
+	return m.AddSafeWithRolloverContext(ctx, key, rollover)
+}
+
+func (m *memoryIncrementer) AddSafeContext(ctx context.Context, key string) (incrmntr.NullInt64, error) {
+	return m.AddSafeWithRolloverContext(ctx, key, m.rollover)
+}
+
+func (m *memoryIncrementer) GetContext(ctx context.Context, key string) (int64, error) {
+	return m.Get(key)
+}
+
+func (m *memoryIncrementer) SetContext(ctx context.Context, key string, value int64) error {
+	return m.Set(key, value)
+}
+
+func (m *memoryIncrementer) ResetContext(ctx context.Context, key string) error {
+	return m.Reset(key)
+}
+
+func (m *memoryIncrementer) Set(key string, value int64) error {
+	m.Lock()
+	defer m.Unlock()
+	m.values[key] = value
+	return nil
+}
+
+func (m *memoryIncrementer) Reset(key string) error {
+	return m.Set(key, m.initial)
+}
+
+func (m *memoryIncrementer) SetTimeout(timeout time.Duration) {}
+
+func (m *memoryIncrementer) Close() error { return nil }
+
+func (m *memoryIncrementer) Watch(ctx context.Context, key string) (<-chan incrmntr.Change, error) {
+	out := make(chan incrmntr.Change)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case change := <-m.changes:
+				select {
+				case out <- change:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// dial starts the service on an in-process listener and returns the client
+func dial(t *testing.T, inc incrmntr.Incrmntr) (CounterClient, func()) {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	RegisterCounterServer(srv, NewServer(inc))
+	go srv.Serve(lis)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewCounterClient(conn), func() {
+		conn.Close()
+		srv.Stop()
+	}
+}
+
+func TestServerNext(t *testing.T) {
+	client, stop := dial(t, newMemoryIncrementer())
+	defer stop()
+	ctx := context.Background()
+
+	resp, err := client.Next(ctx, &NextRequest{Key: "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Value != 1 {
+		t.Errorf("Value should be 1, instead of %d", resp.Value)
+	}
+
+	values, err := client.NextN(ctx, &NextNRequest{Key: "orders", N: 3, Rollover: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values.Values) != 3 || values.Values[0] != 2 || values.Values[1] != 3 || values.Values[2] != 1 {
+		t.Errorf("Values should be [2 3 1], instead of %v", values.Values)
+	}
+
+	if _, err := client.Set(ctx, &SetRequest{Key: "orders", Value: 41}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = client.Next(ctx, &NextRequest{Key: "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Value != 42 {
+		t.Errorf("Value should be 42, instead of %d", resp.Value)
+	}
+
+	resp, err = client.Reset(ctx, &KeyRequest{Key: "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Value != 1 {
+		t.Errorf("Value should be 1, instead of %d", resp.Value)
+	}
+}
+
+func TestServerStatusCodes(t *testing.T) {
+	inc := newMemoryIncrementer()
+	client, stop := dial(t, inc)
+	defer stop()
+
+	_, err := client.Get(context.Background(), &KeyRequest{Key: "missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Code should be NotFound, instead of %s", status.Code(err))
+	}
+
+	_, err = client.NextN(context.Background(), &NextNRequest{Key: "key"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Code should be InvalidArgument, instead of %s", status.Code(err))
+	}
+
+	// ---- the blocked call returns when the deadline exceeded,
+	// the incrementer gets the context of the request
+	inc.block = make(chan struct{})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.Next(ctx, &NextRequest{Key: "key"})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Code should be DeadlineExceeded, instead of %s", status.Code(err))
+	}
+	_, err = NewServer(inc).Next(ctx, &NextRequest{Key: "key"})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Code of the server should be DeadlineExceeded, instead of %s", status.Code(err))
+	}
+}
+
+func TestServerWrappedDeadline(t *testing.T) {
+	inc := newMemoryIncrementer()
+	inc.block = make(chan struct{})
+	defer close(inc.block)
+	wrapped := cache.New(inc, 0, 0)
+	client, stop := dial(t, wrapped)
+	defer stop()
+
+	// ---- the middleware passes the context of the request through,
+	// so the blocked call of the server returns at the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := client.Next(ctx, &NextRequest{Key: "key"})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Code should be DeadlineExceeded, instead of %s", status.Code(err))
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := NewServer(wrapped).Next(ctx, &NextRequest{Key: "key"})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if status.Code(err) != codes.DeadlineExceeded {
+			t.Errorf("Code of the server should be DeadlineExceeded, instead of %s", status.Code(err))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("call of the server should return at the deadline")
+	}
+}
+
+func TestServerNextNPartial(t *testing.T) {
+	inc := newMemoryIncrementer()
+	inc.failAfter = 2
+	client, stop := dial(t, inc)
+	defer stop()
+
+	// ---- the values given before the error are in the details
+	_, err := client.NextN(context.Background(), &NextNRequest{Key: "orders", N: 5})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Code should be Unavailable, instead of %s", status.Code(err))
+	}
+	if values := PartialValues(err); len(values) != 2 || values[0] != 1 || values[1] != 2 {
+		t.Errorf("Partial values should be [1 2], instead of %v", values)
+	}
+}
+
+func TestServerWatch(t *testing.T) {
+	inc := newMemoryIncrementer()
+	client, stop := dial(t, inc)
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Watch(ctx, &KeyRequest{Key: "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inc.changes <- incrmntr.Change{Key: "orders", Value: 1, Previous: 999, Rollover: true}
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Value != 1 || event.Previous != 999 || !event.Rollover {
+		t.Errorf("Event should be a rollover 999 -> 1, instead of %v", event)
+	}
+}
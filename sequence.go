@@ -0,0 +1,420 @@
+package incrmntr
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidTemplate returned when the sequence template can't be compiled
+	ErrInvalidTemplate = errors.New("invalid sequence template")
+
+	// ErrInvalidIdentifier returned when an identifier doesn't match the template
+	ErrInvalidIdentifier = errors.New("identifier doesn't match the template")
+
+	// ErrCheckDigit returned when the check digit of an identifier is wrong
+	ErrCheckDigit = errors.New("check digit mismatch")
+)
+
+// Alphabets of the supported number encodings
+const (
+	alphabetDecimal   = "0123456789"
+	alphabetBase36    = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	alphabetBase62    = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	alphabetCrockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+)
+
+var encodings = map[string]string{
+	"dec":       alphabetDecimal,
+	"base36":    alphabetBase36,
+	"base62":    alphabetBase62,
+	"crockford": alphabetCrockford,
+}
+
+// segment kinds of a compiled template
+const (
+	segLiteral = iota
+	segNumber
+	segDate
+	segCheck
+)
+
+type segment struct {
+	kind     int
+	literal  string
+	width    int
+	alphabet string
+	layout   string
+	check    string
+}
+
+// Template is a compiled identifier template. The pattern is literal text
+// with tokens in curly braces:
+//
+//	{N}                the value in decimal
+//	{N:6}              the value zero padded to 6 characters
+//	{N:6:base36}       padded value with encoding (dec, base36, base62, crockford)
+//	{YYYY} {YY} {MM} {DD}  date of the formatting
+//	{CHECK:luhn}       check digit (luhn, damm, mod97) of the decimal value
+//
+// Every template must contain exactly one {N} token.
+// For example "INV-{YYYY}-{N:6}-{CHECK:luhn}" gives INV-2026-000123-0.
+type Template struct {
+	pattern  string
+	segments []segment
+	number   int
+}
+
+// NewTemplate compiles the pattern into a Template
+func NewTemplate(pattern string) (*Template, error) {
+	t := &Template{pattern: pattern, number: -1}
+
+	rest := pattern
+	for len(rest) > 0 {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			t.segments = append(t.segments, segment{kind: segLiteral, literal: rest})
+			break
+		}
+		if open > 0 {
+			t.segments = append(t.segments, segment{kind: segLiteral, literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("%w: unclosed token in %q", ErrInvalidTemplate, pattern)
+		}
+		seg, err := parseToken(rest[open+1 : open+end])
+		if err != nil {
+			return nil, err
+		}
+		if seg.kind == segNumber {
+			if t.number >= 0 {
+				return nil, fmt.Errorf("%w: more than one {N} token in %q", ErrInvalidTemplate, pattern)
+			}
+			t.number = len(t.segments)
+		}
+		t.segments = append(t.segments, seg)
+		rest = rest[open+end+1:]
+	}
+
+	if t.number < 0 {
+		return nil, fmt.Errorf("%w: missing {N} token in %q", ErrInvalidTemplate, pattern)
+	}
+
+	return t, nil
+}
+
+// parseToken compiles a single token without the braces
+func parseToken(token string) (segment, error) {
+	parts := strings.Split(token, ":")
+	switch parts[0] {
+	case "N":
+		seg := segment{kind: segNumber, alphabet: alphabetDecimal}
+		if len(parts) > 3 {
+			return seg, fmt.Errorf("%w: too many arguments in {%s}", ErrInvalidTemplate, token)
+		}
+		if len(parts) > 1 && parts[1] != "" {
+			width, err := strconv.Atoi(parts[1])
+			if err != nil || width < 0 {
+				return seg, fmt.Errorf("%w: invalid width in {%s}", ErrInvalidTemplate, token)
+			}
+			seg.width = width
+		}
+		if len(parts) > 2 {
+			alphabet, ok := encodings[parts[2]]
+			if !ok {
+				return seg, fmt.Errorf("%w: unknown encoding in {%s}", ErrInvalidTemplate, token)
+			}
+			seg.alphabet = alphabet
+		}
+		return seg, nil
+	case "YYYY":
+		return segment{kind: segDate, layout: "2006", width: 4}, nil
+	case "YY":
+		return segment{kind: segDate, layout: "06", width: 2}, nil
+	case "MM":
+		return segment{kind: segDate, layout: "01", width: 2}, nil
+	case "DD":
+		return segment{kind: segDate, layout: "02", width: 2}, nil
+	case "CHECK":
+		if len(parts) != 2 {
+			return segment{}, fmt.Errorf("%w: missing algorithm in {%s}", ErrInvalidTemplate, token)
+		}
+		switch parts[1] {
+		case "luhn", "damm":
+			return segment{kind: segCheck, check: parts[1], width: 1}, nil
+		case "mod97":
+			return segment{kind: segCheck, check: parts[1], width: 2}, nil
+		}
+		return segment{}, fmt.Errorf("%w: unknown check algorithm in {%s}", ErrInvalidTemplate, token)
+	}
+
+	return segment{}, fmt.Errorf("%w: unknown token {%s}", ErrInvalidTemplate, token)
+}
+
+// String returns the pattern of the template
+func (t *Template) String() string {
+	return t.pattern
+}
+
+// Format renders the value with the template, the date tokens filled from at
+func (t *Template) Format(value int64, at time.Time) (string, error) {
+	if value < 0 {
+		return "", fmt.Errorf("%w: negative value %d", ErrInvalidIdentifier, value)
+	}
+
+	var b strings.Builder
+	for _, seg := range t.segments {
+		switch seg.kind {
+		case segLiteral:
+			b.WriteString(seg.literal)
+		case segNumber:
+			encoded := encode(value, seg.alphabet)
+			for i := len(encoded); i < seg.width; i++ {
+				b.WriteByte('0')
+			}
+			b.WriteString(encoded)
+		case segDate:
+			b.WriteString(at.Format(seg.layout))
+		case segCheck:
+			b.WriteString(checkDigit(seg.check, strconv.FormatInt(value, 10)))
+		}
+	}
+
+	return b.String(), nil
+}
+
+// Parse validates the identifier against the template and
+// extracts the numeric value of it
+func (t *Template) Parse(id string) (int64, error) {
+	// ---- everything around the {N} token has fixed width, so the
+	// prefix consumed from the front and the suffix from the back
+	offsets := make([]int, len(t.segments)+1)
+	for k, seg := range t.segments[:t.number] {
+		n, err := matchFixed(seg, id[offsets[k]:])
+		if err != nil {
+			return 0, err
+		}
+		offsets[k+1] = offsets[k] + n
+	}
+
+	offsets[len(t.segments)] = len(id)
+	for k := len(t.segments) - 1; k > t.number; k-- {
+		start := offsets[k+1] - fixedWidth(t.segments[k])
+		if start < offsets[t.number] {
+			return 0, fmt.Errorf("%w: %q is too short", ErrInvalidIdentifier, id)
+		}
+		if _, err := matchFixed(t.segments[k], id[start:offsets[k+1]]); err != nil {
+			return 0, err
+		}
+		offsets[k] = start
+	}
+
+	number := id[offsets[t.number]:offsets[t.number+1]]
+	if number == "" {
+		return 0, fmt.Errorf("%w: missing number in %q", ErrInvalidIdentifier, id)
+	}
+	value, err := decode(number, t.segments[t.number].alphabet)
+	if err != nil {
+		return 0, err
+	}
+
+	// ---- verify the check digits with the extracted value
+	digits := strconv.FormatInt(value, 10)
+	for k, seg := range t.segments {
+		if seg.kind == segCheck && id[offsets[k]:offsets[k+1]] != checkDigit(seg.check, digits) {
+			return 0, fmt.Errorf("%w: %q", ErrCheckDigit, id)
+		}
+	}
+
+	return value, nil
+}
+
+// fixedWidth returns the width of a non-number segment
+func fixedWidth(seg segment) int {
+	if seg.kind == segLiteral {
+		return len(seg.literal)
+	}
+	return seg.width
+}
+
+// matchFixed checks the beginning of s against the fixed width
+// segment and returns the consumed length
+func matchFixed(seg segment, s string) (int, error) {
+	width := fixedWidth(seg)
+	if len(s) < width {
+		return 0, fmt.Errorf("%w: unexpected end of identifier", ErrInvalidIdentifier)
+	}
+	part := s[:width]
+	if seg.kind == segLiteral {
+		if part != seg.literal {
+			return 0, fmt.Errorf("%w: expected %q instead of %q", ErrInvalidIdentifier, seg.literal, part)
+		}
+		return width, nil
+	}
+	for k := 0; k < len(part); k++ {
+		if part[k] < '0' || part[k] > '9' {
+			return 0, fmt.Errorf("%w: expected digits instead of %q", ErrInvalidIdentifier, part)
+		}
+	}
+
+	return width, nil
+}
+
+// encode writes the value with the given alphabet
+func encode(value int64, alphabet string) string {
+	if value == 0 {
+		return alphabet[:1]
+	}
+	base := int64(len(alphabet))
+	var buf [64]byte
+	i := len(buf)
+	for value > 0 {
+		i--
+		buf[i] = alphabet[value%base]
+		value /= base
+	}
+
+	return string(buf[i:])
+}
+
+// decode reads the value written with the given alphabet, crockford
+// decoding is case insensitive and accepts the I, L and O aliases
+func decode(s string, alphabet string) (int64, error) {
+	if alphabet == alphabetCrockford {
+		s = strings.NewReplacer("I", "1", "L", "1", "O", "0").Replace(strings.ToUpper(s))
+	} else if alphabet == alphabetBase36 {
+		s = strings.ToUpper(s)
+	}
+
+	base := int64(len(alphabet))
+	var value int64
+	for k := 0; k < len(s); k++ {
+		digit := strings.IndexByte(alphabet, s[k])
+		if digit < 0 {
+			return 0, fmt.Errorf("%w: invalid character %q in number", ErrInvalidIdentifier, s[k])
+		}
+		if value > (1<<63-1-int64(digit))/base {
+			return 0, fmt.Errorf("%w: number %q overflows", ErrInvalidIdentifier, s)
+		}
+		value = value*base + int64(digit)
+	}
+
+	return value, nil
+}
+
+// checkDigit calculates the check digits of the decimal digits
+func checkDigit(algorithm string, digits string) string {
+	switch algorithm {
+	case "luhn":
+		return strconv.Itoa(luhn(digits))
+	case "damm":
+		return strconv.Itoa(damm(digits))
+	case "mod97":
+		return fmt.Sprintf("%02d", mod97(digits))
+	}
+
+	return ""
+}
+
+// luhn calculates the Luhn check digit
+func luhn(digits string) int {
+	sum := 0
+	double := true
+	for k := len(digits) - 1; k >= 0; k-- {
+		d := int(digits[k] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+
+	return (10 - sum%10) % 10
+}
+
+var dammTable = [10][10]int{
+	{0, 3, 1, 7, 5, 9, 8, 6, 4, 2},
+	{7, 0, 9, 2, 1, 5, 4, 8, 6, 3},
+	{4, 2, 0, 6, 8, 7, 1, 3, 5, 9},
+	{1, 7, 5, 0, 9, 8, 3, 4, 2, 6},
+	{6, 1, 2, 3, 0, 4, 5, 9, 7, 8},
+	{3, 6, 7, 4, 2, 0, 9, 5, 8, 1},
+	{5, 8, 6, 9, 7, 2, 0, 1, 3, 4},
+	{8, 9, 4, 5, 3, 6, 2, 0, 1, 7},
+	{9, 4, 3, 8, 6, 1, 7, 2, 0, 5},
+	{2, 5, 8, 1, 4, 3, 6, 7, 9, 0},
+}
+
+// damm calculates the Damm check digit
+func damm(digits string) int {
+	interim := 0
+	for k := 0; k < len(digits); k++ {
+		interim = dammTable[interim][digits[k]-'0']
+	}
+
+	return interim
+}
+
+// mod97 calculates the ISO 7064 MOD 97-10 check digits
+func mod97(digits string) int {
+	rem := 0
+	for k := 0; k < len(digits); k++ {
+		rem = (rem*10 + int(digits[k]-'0')) % 97
+	}
+	rem = rem * 100 % 97
+
+	return 98 - rem
+}
+
+// Sequence generates formatted identifiers from a counter key
+type Sequence struct {
+	inc      Incrmntr
+	key      string
+	template *Template
+	now      func() time.Time
+}
+
+// NewSequence creates a new Sequence on the key of the incrementer
+func NewSequence(inc Incrmntr, key string, pattern string) (*Sequence, error) {
+	template, err := NewTemplate(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sequence{
+		inc:      inc,
+		key:      key,
+		template: template,
+		now:      time.Now,
+	}, nil
+}
+
+// Next increments the key with AddSafe and returns the formatted identifier
+func (s *Sequence) Next() (string, error) {
+	value, err := s.inc.AddSafe(s.key)
+	if err != nil {
+		return "", err
+	}
+	if !value.Valid {
+		return "", errors.New("error invalid value")
+	}
+
+	return s.template.Format(value.Value, s.now())
+}
+
+// Parse validates the identifier and returns the numeric value of it
+func (s *Sequence) Parse(id string) (int64, error) {
+	return s.template.Parse(id)
+}
+
+// Template returns the compiled template of the sequence
+func (s *Sequence) Template() *Template {
+	return s.template
+}
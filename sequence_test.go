@@ -0,0 +1,121 @@
+package incrmntr
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+func TestTemplateFormat(t *testing.T) {
+	var at = time.Date(2026, 3, 7, 0, 0, 0, 0, time.UTC)
+	var cases = []struct {
+		pattern string
+		value   int64
+		id      string
+	}{
+		{"INV-{YYYY}-{N:6}-{CHECK:luhn}", 123, "INV-2026-000123-0"},
+		{"{N}", 0, "0"},
+		{"T{YY}{MM}{DD}/{N:4}", 42, "T260307/0042"},
+		{"{N::base36}", 35, "Z"},
+		{"{N:3:base62}", 61, "00z"},
+		{"C-{N:8:crockford}", 1234567, "C-00015NM7"},
+		{"{N}{CHECK:damm}", 572, "5724"},
+		{"RF{CHECK:mod97}{N}", 539007547034, "RF78539007547034"},
+	}
+
+	for _, c := range cases {
+		tmpl, err := NewTemplate(c.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := tmpl.Format(c.value, at)
+		if err != nil {
+			t.Error(err)
+		}
+		if id != c.id {
+			t.Errorf("Format of %d with %s should be %s, instead of %s", c.value, c.pattern, c.id, id)
+		}
+		value, err := tmpl.Parse(id)
+		if err != nil {
+			t.Error(err)
+		}
+		if value != c.value {
+			t.Errorf("Parse of %s should be %d, instead of %d", id, c.value, value)
+		}
+	}
+}
+
+func TestTemplateParseErrors(t *testing.T) {
+	tmpl, err := NewTemplate("INV-{YYYY}-{N:6}-{CHECK:luhn}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = map[string]error{
+		"INV-2026-000123-1": ErrCheckDigit,
+		"INX-2026-000123-0": ErrInvalidIdentifier,
+		"INV-20X6-000123-0": ErrInvalidIdentifier,
+		"INV-2026--0":       ErrInvalidIdentifier,
+		"INV-2026-00A123-0": ErrInvalidIdentifier,
+		"INV":               ErrInvalidIdentifier,
+	}
+	for id, expected := range cases {
+		if _, err := tmpl.Parse(id); !errors.Is(err, expected) {
+			t.Errorf("Parse of %s should fail with %v, instead of %v", id, expected, err)
+		}
+	}
+}
+
+func TestNewTemplateErrors(t *testing.T) {
+	var patterns = []string{
+		"INV-{YYYY}",
+		"{N}-{N}",
+		"{N:x}",
+		"{N:2:hex}",
+		"{N}{CHECK}",
+		"{N}{CHECK:verhoeff}",
+		"{N}{XX}",
+		"{N",
+	}
+	for _, pattern := range patterns {
+		if _, err := NewTemplate(pattern); !errors.Is(err, ErrInvalidTemplate) {
+			t.Errorf("Template %s should be invalid, instead of %v", pattern, err)
+		}
+	}
+}
+
+func TestSequence(t *testing.T) {
+	var key = xid.New().String()
+
+	bucket, closeCluster := getBucket()
+	defer closeCluster(nil)
+
+	inc, err := New(bucket, 999, 1, 1, false)
+	if err != nil {
+		t.Error(err)
+	}
+
+	seq, err := NewSequence(inc, key, "INV-{N:6}-{CHECK:luhn}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id string
+	for i := 0; i < 3; i++ {
+		id, err = seq.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if id != "INV-000003-4" {
+		t.Errorf("Identifier should be INV-000003-4, instead of %s", id)
+	}
+	value, err := seq.Parse(id)
+	if err != nil {
+		t.Error(err)
+	}
+	if value != 3 {
+		t.Errorf("Value should be 3, instead of %d", value)
+	}
+}
@@ -0,0 +1,296 @@
+package incrmntr
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Bit layout of the generated IDs: 41 bits of milliseconds since
+// IDEpoch, 10 bits of node and 12 bits of sequence in the millisecond
+const (
+	idNodeBits     = 10
+	idSequenceBits = 12
+
+	// MaxNodeID is the highest node ID can be leased
+	MaxNodeID = 1<<idNodeBits - 1
+
+	maxSequence = 1<<idSequenceBits - 1
+	maxIDTime   = 1<<41 - 1
+)
+
+// IDEpoch is the zero time of the generated IDs
+var IDEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	// ErrNoNodeAvailable returned when every node ID is leased
+	ErrNoNodeAvailable = errors.New("no node id available")
+
+	// ErrLeaseLost returned when the node ID lease expired or
+	// taken over by another process
+	ErrLeaseLost = errors.New("node id lease lost")
+
+	// ErrClockBackwards returned when the clock moved back more than
+	// the generator willing to wait
+	ErrClockBackwards = errors.New("clock moved backwards")
+)
+
+// maxClockDrift is the longest clock step back the generator waits out
+const maxClockDrift = 100 * time.Millisecond
+
+// leaseRenewals is the number of the renewals in a lease ttl, the lease
+// is considered lost a renewal interval before it expires, so the
+// generator stops minting before an other process can take the node ID
+const leaseRenewals = 5
+
+// nodeLease is the document stored for the leased node ID
+type nodeLease struct {
+	Owner string `json:"owner"`
+	Node  int64  `json:"node"`
+}
+
+// IDGenerator mints k-ordered 64 bit IDs locally. The incrementer key is
+// used only to lease the node ID of the process at startup, the lease
+// renewed in the background and taken over by others after it expires.
+type IDGenerator struct {
+	sync.Mutex
+
+	inc      *Incrementer
+	leaseKey string
+	leaseTTL time.Duration
+	owner    string
+	cas      gocb.Cas
+	node     int64
+	lost     bool
+
+	// validUntil is the end of the lease known to be held, minus the
+	// safety margin, it's extended by every successful renewal
+	validUntil time.Time
+
+	lastTime int64
+	sequence int64
+	now      func() time.Time
+
+	stop chan struct{}
+	done chan struct{}
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewIDGenerator leases a node ID with the incrementer key and returns
+// the generator minting IDs with it. The lease lives for leaseTTL and
+// renewed at the fifth of it until Close called, Next fails with
+// ErrLeaseLost if the renewals fail until the end of the lease.
+func NewIDGenerator(inc *Incrementer, key string, leaseTTL time.Duration) (*IDGenerator, error) {
+	if inc.bucket == nil {
+		return nil, errors.New("error bucket is nil")
+	}
+	if inc.Closed() {
+		return nil, ErrClosed
+	}
+	if leaseTTL < 3*time.Second {
+		return nil, errors.New("error lease ttl should be at least 3 seconds")
+	}
+
+	owner := make([]byte, 12)
+	if _, err := rand.Read(owner); err != nil {
+		return nil, err
+	}
+
+	g := &IDGenerator{
+		inc:      inc,
+		leaseKey: key,
+		leaseTTL: leaseTTL,
+		owner:    hex.EncodeToString(owner),
+		now:      time.Now,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := g.lease(); err != nil {
+		return nil, err
+	}
+	go g.renew()
+
+	return g, nil
+}
+
+// lease picks the starting candidate with the incrementer and takes
+// the first node ID without a living lease document
+func (g *IDGenerator) lease() error {
+	start, err := g.inc.AddSafe(g.leaseKey)
+	if err != nil {
+		return err
+	}
+
+	for k := int64(0); k <= MaxNodeID; k++ {
+		node := (start.Value + k) % (MaxNodeID + 1)
+		leased := time.Now()
+		res, err := g.inc.bucket.DefaultCollection().Insert(g.nodeKey(node), nodeLease{Owner: g.owner, Node: node}, &gocb.InsertOptions{
+			Expiry:  g.leaseTTL,
+			Timeout: g.inc.GetTimeout(),
+		})
+		if errors.Is(err, gocb.ErrDocumentExists) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		g.node = node
+		g.cas = res.Cas()
+		g.validUntil = g.leaseEnd(leased)
+		return nil
+	}
+
+	return ErrNoNodeAvailable
+}
+
+// renew extends the lease until the generator closed
+func (g *IDGenerator) renew() {
+	defer close(g.done)
+
+	ticker := time.NewTicker(g.leaseTTL / leaseRenewals)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+			// ---- only this goroutine writes the cas until Close,
+			// so the lock held just for the result
+			renewed := time.Now()
+			res, err := g.inc.bucket.DefaultCollection().Replace(g.nodeKey(g.node), nodeLease{Owner: g.owner, Node: g.node}, &gocb.ReplaceOptions{
+				Cas:     g.cas,
+				Expiry:  g.leaseTTL,
+				Timeout: g.inc.GetTimeout(),
+			})
+			g.Lock()
+			if errors.Is(err, gocb.ErrCasMismatch) || errors.Is(err, gocb.ErrDocumentNotFound) {
+				g.lost = true
+			} else if err == nil {
+				g.cas = res.Cas()
+				g.validUntil = g.leaseEnd(renewed)
+			} else if !time.Now().Before(g.validUntil) {
+				g.lost = true
+			}
+			lost := g.lost
+			g.Unlock()
+			if lost {
+				return
+			}
+		}
+	}
+}
+
+// leaseEnd returns the end of the lease written at the time minus
+// the safety margin of a renewal interval
+func (g *IDGenerator) leaseEnd(written time.Time) time.Time {
+	return written.Add(g.leaseTTL - g.leaseTTL/leaseRenewals)
+}
+
+// nodeKey is the key of the lease document of the node
+func (g *IDGenerator) nodeKey(node int64) string {
+	return fmt.Sprintf("%s::node::%d", g.leaseKey, node)
+}
+
+// NodeID returns the leased node ID
+func (g *IDGenerator) NodeID() int64 {
+	return g.node
+}
+
+// Next mints the next ID without calling the storage, it fails
+// closed with ErrLeaseLost once the lease isn't known to be held
+func (g *IDGenerator) Next() (int64, error) {
+	g.Lock()
+	defer g.Unlock()
+
+	if !g.lost && !time.Now().Before(g.validUntil) {
+		g.lost = true
+	}
+	if g.lost {
+		return 0, ErrLeaseLost
+	}
+
+	return g.mint()
+}
+
+// mint calculates the next ID from the clock and the sequence
+func (g *IDGenerator) mint() (int64, error) {
+	now := g.since()
+	if now < g.lastTime {
+		// ---- wait out the small steps back of the clock
+		if time.Duration(g.lastTime-now)*time.Millisecond > maxClockDrift {
+			return 0, ErrClockBackwards
+		}
+		for now < g.lastTime {
+			time.Sleep(time.Duration(g.lastTime-now) * time.Millisecond)
+			now = g.since()
+		}
+	}
+
+	if now == g.lastTime {
+		g.sequence = (g.sequence + 1) & maxSequence
+		if g.sequence == 0 {
+			// ---- sequence exhausted in this millisecond
+			for now <= g.lastTime {
+				time.Sleep(100 * time.Microsecond)
+				now = g.since()
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+	if now > maxIDTime {
+		return 0, errors.New("error id time space exhausted")
+	}
+	g.lastTime = now
+
+	return now<<(idNodeBits+idSequenceBits) | g.node<<idSequenceBits | g.sequence, nil
+}
+
+// since returns the milliseconds elapsed since IDEpoch
+func (g *IDGenerator) since() int64 {
+	return g.now().Sub(IDEpoch).Nanoseconds() / int64(time.Millisecond)
+}
+
+// Close stops the renewal and releases the node ID lease, the later
+// calls return the result of the first one
+func (g *IDGenerator) Close() error {
+	g.closeOnce.Do(func() {
+		g.closeErr = g.close()
+	})
+
+	return g.closeErr
+}
+
+func (g *IDGenerator) close() error {
+	close(g.stop)
+	<-g.done
+
+	g.Lock()
+	defer g.Unlock()
+	if g.lost {
+		return nil
+	}
+	g.lost = true
+	_, err := g.inc.bucket.DefaultCollection().Remove(g.nodeKey(g.node), &gocb.RemoveOptions{
+		Cas:     g.cas,
+		Timeout: g.inc.GetTimeout(),
+	})
+
+	return err
+}
+
+// DecomposeID splits the ID to the time, node and sequence parts
+func DecomposeID(id int64) (time.Time, int64, int64) {
+	ms := id >> (idNodeBits + idSequenceBits)
+	node := id >> idSequenceBits & MaxNodeID
+	sequence := id & maxSequence
+
+	return IDEpoch.Add(time.Duration(ms) * time.Millisecond), node, sequence
+}
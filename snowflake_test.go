@@ -0,0 +1,154 @@
+package incrmntr
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+func TestIDGeneratorMint(t *testing.T) {
+	var clock = IDEpoch.Add(time.Hour)
+	g := &IDGenerator{
+		node:       37,
+		validUntil: time.Now().Add(time.Hour),
+		now: func() time.Time {
+			return clock
+		},
+	}
+
+	var last int64
+	for i := 0; i < 10; i++ {
+		id, err := g.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id <= last {
+			t.Errorf("ID %d should be greater than %d", id, last)
+		}
+		last = id
+	}
+
+	at, node, sequence := DecomposeID(last)
+	if !at.Equal(clock) {
+		t.Errorf("Time should be %s, instead of %s", clock, at)
+	}
+	if node != 37 {
+		t.Errorf("Node should be 37, instead of %d", node)
+	}
+	if sequence != 9 {
+		t.Errorf("Sequence should be 9, instead of %d", sequence)
+	}
+
+	clock = clock.Add(time.Millisecond)
+	id, err := g.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, sequence := DecomposeID(id); sequence != 0 {
+		t.Errorf("Sequence should restart from 0, instead of %d", sequence)
+	}
+
+	clock = clock.Add(-time.Second)
+	if _, err := g.Next(); !errors.Is(err, ErrClockBackwards) {
+		t.Errorf("Error should be ErrClockBackwards, instead of %v", err)
+	}
+}
+
+func TestIDGeneratorSequenceOverflow(t *testing.T) {
+	var calls int
+	g := &IDGenerator{
+		validUntil: time.Now().Add(time.Hour),
+		now: func() time.Time {
+			calls++
+			// ---- the clock steps only after the sequence exhausted
+			if calls > maxSequence+1 {
+				return IDEpoch.Add(2 * time.Millisecond)
+			}
+			return IDEpoch.Add(time.Millisecond)
+		},
+	}
+
+	var seen = make(map[int64]bool)
+	for i := 0; i < maxSequence+2; i++ {
+		id, err := g.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[id] {
+			t.Fatalf("ID %d generated twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestIDGeneratorCloseTwice(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+	g := &IDGenerator{lost: true, stop: make(chan struct{}), done: done}
+
+	// ---- the second Close returns the first result instead of panicking
+	for k := 0; k < 2; k++ {
+		if err := g.Close(); err != nil {
+			t.Errorf("Close %d should succeed, instead of %v", k, err)
+		}
+	}
+}
+
+func TestIDGeneratorLeaseExpired(t *testing.T) {
+	g := &IDGenerator{
+		leaseTTL:   5 * time.Second,
+		validUntil: time.Now().Add(-time.Millisecond),
+		now:        time.Now,
+	}
+	if _, err := g.Next(); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Error should be ErrLeaseLost, instead of %v", err)
+	}
+
+	// ---- the lost lease isn't revived by a late renewal
+	g.validUntil = g.leaseEnd(time.Now())
+	if _, err := g.Next(); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Error should stay ErrLeaseLost, instead of %v", err)
+	}
+	if end := g.leaseEnd(time.Unix(0, 0)); end != time.Unix(4, 0) {
+		t.Errorf("Lease should be valid for 4s, instead of until %s", end)
+	}
+}
+
+func TestIDGeneratorLease(t *testing.T) {
+	var key = xid.New().String()
+
+	bucket, closeCluster := getBucket()
+	defer closeCluster(nil)
+
+	inc, err := New(bucket, 999, 1, 1, false)
+	if err != nil {
+		t.Error(err)
+	}
+
+	first, err := NewIDGenerator(inc.(*Incrementer), key, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewIDGenerator(inc.(*Incrementer), key, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.NodeID() == second.NodeID() {
+		t.Errorf("Node IDs should differ, both are %d", first.NodeID())
+	}
+
+	if err := first.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err := first.Next(); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Error should be ErrLeaseLost, instead of %v", err)
+	}
+	if err := second.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := second.Close(); err != nil {
+		t.Errorf("Second Close should return the first result, instead of %v", err)
+	}
+}
@@ -0,0 +1,331 @@
+// Package sqldb is the Backend of the Incrementer on a database/sql database,
+// PostgreSQL, MySQL and SQLite dialects are provided.
+//
+// The counters are rows of a table created on demand. The add is a single
+// UPDATE ... RETURNING where the dialect supports it, otherwise a
+// transaction of SELECT ... FOR UPDATE and UPDATE, so it's atomic in
+// both cases.
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+)
+
+// DefaultTable is the name of the table of the counters
+const DefaultTable = "incrmntr_counters"
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// Option configures the Backend
+type Option func(b *Backend)
+
+// WithTable sets the name of the table
+func WithTable(table string) Option {
+	return func(b *Backend) {
+		b.table = table
+	}
+}
+
+// querier is the database or the transaction running the statements
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Backend implements incrmntr.Backend with a database/sql database
+type Backend struct {
+	db      *sql.DB
+	dialect Dialect
+	table   string
+	now     func() time.Time
+
+	mu     sync.Mutex
+	schema bool
+}
+
+// New creates the Backend on the database, the table
+// created by the first operation if it doesn't exist
+func New(db *sql.DB, dialect Dialect, opts ...Option) (*Backend, error) {
+	b := &Backend{db: db, dialect: dialect, table: DefaultTable, now: time.Now}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if !tableName.MatchString(b.table) {
+		return nil, fmt.Errorf("error invalid table name %q", b.table)
+	}
+
+	return b, nil
+}
+
+// Name is the name of the backend in the spans
+func (b *Backend) Name() string {
+	return b.dialect.Name
+}
+
+// ensureSchema creates the table once, a failed attempt is retried by the next call
+func (b *Backend) ensureSchema(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.schema {
+		return nil
+	}
+	if _, err := b.db.ExecContext(ctx, fmt.Sprintf(createTable, b.table)); err != nil {
+		return err
+	}
+	b.schema = true
+
+	return nil
+}
+
+// Get returns the value of the key
+func (b *Backend) Get(ctx context.Context, key string) (int64, error) {
+	if err := b.ensureSchema(ctx); err != nil {
+		return 0, err
+	}
+
+	var value int64
+	err := b.db.QueryRowContext(ctx, b.dialect.statement(selectValue, b.table, 2), key, b.now().UnixNano()).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, incrmntr.ErrKeyNotFound
+	}
+
+	return value, err
+}
+
+// Init creates the key with the value if it doesn't exist,
+// the expired row of the key deleted first
+func (b *Backend) Init(ctx context.Context, key string, value int64, expiry time.Duration) (bool, error) {
+	if err := b.ensureSchema(ctx); err != nil {
+		return false, err
+	}
+
+	return b.init(ctx, b.db, key, value, expiry)
+}
+
+// init deletes the expired row of the key and inserts it if it doesn't exist
+func (b *Backend) init(ctx context.Context, q querier, key string, value int64, expiry time.Duration) (bool, error) {
+	now := b.now()
+	if _, err := q.ExecContext(ctx, b.dialect.statement(deleteExpired, b.table, 2), key, now.UnixNano()); err != nil {
+		return false, err
+	}
+	res, err := q.ExecContext(ctx, b.dialect.statement(b.dialect.InsertIgnore, b.table, 3), key, value, expiresAt(now, expiry))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// Add increments the key atomically
+func (b *Backend) Add(ctx context.Context, key string, op incrmntr.AddOp) (int64, bool, error) {
+	if err := b.ensureSchema(ctx); err != nil {
+		return 0, false, err
+	}
+	if b.dialect.Returning {
+		return b.addReturning(ctx, b.db, key, op)
+	}
+
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	value, rolled, err := b.addForUpdate(ctx, tx, key, op)
+	if err != nil {
+		return 0, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, false, err
+	}
+
+	return value, rolled, nil
+}
+
+// addReturning increments the key with a single UPDATE ... RETURNING
+func (b *Backend) addReturning(ctx context.Context, q querier, key string, op incrmntr.AddOp) (int64, bool, error) {
+	// ---- a batch crosses from the rollover, so the op is a batch
+	if op.Crossing(int64(op.Rollover)) != nil {
+		return b.addBatchReturning(ctx, q, key, op)
+	}
+
+	// ---- the value rolls over if it's higher than the threshold, it's
+	// compared instead of value + delta, so the sum can't overflow
+	threshold := int64(math.MaxInt64)
+	if op.Cycle && op.Rollover <= math.MaxInt64 {
+		threshold = int64(op.Rollover) - int64(op.Delta)
+	}
+
+	now := b.now()
+	var value int64
+	err := q.QueryRowContext(ctx, b.dialect.statement(updateReturning, b.table, 6),
+		threshold, op.Initial, int64(op.Delta), expiresAt(now, op.Expiry), key, now.UnixNano(),
+	).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		// ---- expired after the init, AddSafe initializes it again
+		return 0, false, incrmntr.ErrConflict
+	}
+
+	return value, rolledTo(op, value), err
+}
+
+// addBatchReturning increments the key by the batch with a single
+// UPDATE ... RETURNING guarded by the threshold, if no row is updated
+// the value is read to tell the expired key from the crossing batch
+func (b *Backend) addBatchReturning(ctx context.Context, q querier, key string, op incrmntr.AddOp) (int64, bool, error) {
+	now := b.now()
+	var value int64
+	err := q.QueryRowContext(ctx, b.dialect.statement(updateBatchReturning, b.table, 5),
+		int64(op.Delta), expiresAt(now, op.Expiry), key, now.UnixNano(), int64(op.Rollover)-int64(op.Delta),
+	).Scan(&value)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return value, false, err
+	}
+
+	var current int64
+	err = q.QueryRowContext(ctx, b.dialect.statement(selectValue, b.table, 2), key, now.UnixNano()).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		// ---- expired after the init, AddSafe initializes it again
+		return 0, false, incrmntr.ErrConflict
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if err := op.Crossing(current); err != nil {
+		return 0, false, err
+	}
+
+	// ---- modified between the statements, AddSafe retries it
+	return 0, false, incrmntr.ErrConflict
+}
+
+// rolledTo reports whether the add returning the value cycled back, the
+// old value isn't returned, so the initial value is taken as rolled: an
+// add reaches it only from a value set below the initial, and then it
+// reports a rollover which didn't happen, but never misses one
+func rolledTo(op incrmntr.AddOp, value int64) bool {
+	return op.Cycle && op.Rollover <= math.MaxInt64 && value == op.Initial
+}
+
+// addForUpdate increments the key in the transaction locking the row
+func (b *Backend) addForUpdate(ctx context.Context, tx *sql.Tx, key string, op incrmntr.AddOp) (int64, bool, error) {
+	now := b.now()
+	var current int64
+	err := tx.QueryRowContext(ctx, b.dialect.statement(selectValue+b.dialect.LockRow, b.table, 2), key, now.UnixNano()).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, incrmntr.ErrConflict
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if err := op.Crossing(current); err != nil {
+		return 0, false, err
+	}
+
+	value, rolled := op.Apply(current)
+	if _, err := tx.ExecContext(ctx, b.dialect.statement(updateValue, b.table, 3), value, expiresAt(now, op.Expiry), key); err != nil {
+		return 0, false, err
+	}
+
+	return value, rolled, nil
+}
+
+// AddIdempotent increments the key and records the result of the request
+// in a transaction, the row of the request is inserted first, so a
+// concurrent attempt of the request waits for the transaction and
+// returns the recorded value
+func (b *Backend) AddIdempotent(ctx context.Context, key string, requestID string, op incrmntr.AddOp, ttl time.Duration) (incrmntr.IdempotentResult, error) {
+	if err := b.ensureSchema(ctx); err != nil {
+		return incrmntr.IdempotentResult{}, err
+	}
+
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return incrmntr.IdempotentResult{}, err
+	}
+	defer tx.Rollback()
+
+	// ---- claim the request or return the result of an earlier attempt
+	recordKey := incrmntr.IdempotencyKey(key, requestID)
+	claimed, err := b.init(ctx, tx, recordKey, 0, ttl)
+	if err != nil {
+		return incrmntr.IdempotentResult{}, err
+	}
+	if !claimed {
+		var value int64
+		err := tx.QueryRowContext(ctx, b.dialect.statement(selectValue, b.table, 2), recordKey, b.now().UnixNano()).Scan(&value)
+		if errors.Is(err, sql.ErrNoRows) {
+			return incrmntr.IdempotentResult{}, incrmntr.ErrConflict
+		}
+		return incrmntr.IdempotentResult{Value: value, Replayed: true}, err
+	}
+
+	var res incrmntr.IdempotentResult
+	res.Created, err = b.init(ctx, tx, key, op.Initial, op.Expiry)
+	switch {
+	case err != nil:
+		return incrmntr.IdempotentResult{}, err
+	case res.Created:
+		res.Value = op.Initial
+	case b.dialect.Returning:
+		res.Value, res.Rolled, err = b.addReturning(ctx, tx, key, op)
+	default:
+		res.Value, res.Rolled, err = b.addForUpdate(ctx, tx, key, op)
+	}
+	if err != nil {
+		return incrmntr.IdempotentResult{}, err
+	}
+
+	// ---- record the value in the row of the request
+	_, err = tx.ExecContext(ctx, b.dialect.statement(updateValue, b.table, 3), res.Value, expiresAt(b.now(), ttl), recordKey)
+	if err != nil {
+		return incrmntr.IdempotentResult{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return incrmntr.IdempotentResult{}, err
+	}
+
+	return res, nil
+}
+
+// Set overwrites the value of the key
+func (b *Backend) Set(ctx context.Context, key string, value int64, expiry time.Duration) error {
+	if err := b.ensureSchema(ctx); err != nil {
+		return err
+	}
+
+	_, err := b.db.ExecContext(ctx, b.dialect.statement(b.dialect.Upsert, b.table, 3), key, value, expiresAt(b.now(), expiry))
+	return err
+}
+
+// Ping checks the connection of the database
+func (b *Backend) Ping(ctx context.Context) error {
+	return b.db.PingContext(ctx)
+}
+
+// Close does nothing, the database is closed by the caller who opened it
+func (b *Backend) Close() error {
+	return nil
+}
+
+// expiresAt is the expiry in unix nanoseconds, 0 if the key doesn't expire
+func expiresAt(now time.Time, expiry time.Duration) int64 {
+	if expiry <= 0 {
+		return 0
+	}
+	return now.Add(expiry).UnixNano()
+}
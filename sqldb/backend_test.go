@@ -0,0 +1,198 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+	"github.com/PumpkinSeed/incrmntr/v2/incrmntrtest"
+	_ "modernc.org/sqlite"
+)
+
+// withoutReturning is SQLite with the transactional add of the dialects
+// without UPDATE ... RETURNING, SQLite locks the whole database on write.
+// The MySQL dialect is tested only this way and by its statements, not
+// on a MySQL server, so the row locking of FOR UPDATE isn't covered
+var withoutReturning = Dialect{
+	Name:         "sqlite",
+	Placeholder:  dollar,
+	InsertIgnore: SQLite.InsertIgnore,
+	Upsert:       SQLite.Upsert,
+}
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ---- every connection of :memory: is a separate database
+	db.SetMaxOpenConns(1)
+
+	return db
+}
+
+func testDialects(t *testing.T, fn func(t *testing.T, b *Backend)) {
+	for name, dialect := range map[string]Dialect{"returning": SQLite, "for_update": withoutReturning} {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t)
+			defer db.Close()
+
+			b, err := New(db, dialect, WithTable("counters"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fn(t, b)
+		})
+	}
+}
+
+func TestBackendAdd(t *testing.T) {
+	testDialects(t, func(t *testing.T, b *Backend) {
+		inc, _ := incrmntr.NewWithBackend(b, 3, 1, 1, true)
+		defer inc.Close()
+
+		for k, e := range []int64{1, 2, 3, 1, 2} {
+			v, err := inc.AddSafe("key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Value != e {
+				t.Errorf("AddSafe %d should return %d, instead of %d", k, e, v.Value)
+			}
+		}
+		if v, _ := inc.AddWithRollover("key", 2); v.Value != 1 {
+			t.Errorf("Custom rollover should cycle back to 1, instead of %d", v.Value)
+		}
+	})
+}
+
+func TestBackendWithoutCycle(t *testing.T) {
+	testDialects(t, func(t *testing.T, b *Backend) {
+		inc, _ := incrmntr.NewWithBackend(b, 2, 5, 10, false)
+		defer inc.Close()
+
+		for _, e := range []int64{5, 15, 25} {
+			if v, _ := inc.AddSafe("key"); v.Value != e {
+				t.Errorf("AddSafe should return %d, instead of %d", e, v.Value)
+			}
+		}
+	})
+}
+
+func TestBackendGetSetReset(t *testing.T) {
+	testDialects(t, func(t *testing.T, b *Backend) {
+		inc, _ := incrmntr.NewWithBackend(b, 999, 1, 1, true)
+		defer inc.Close()
+
+		if _, err := inc.Get("missing"); !errors.Is(err, incrmntr.ErrKeyNotFound) {
+			t.Errorf("Get of missing key should fail with ErrKeyNotFound, instead of %v", err)
+		}
+		if err := inc.Set("key", 41); err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := inc.AddSafe("key"); v.Value != 42 {
+			t.Errorf("AddSafe should return 42, instead of %d", v.Value)
+		}
+		if err := inc.Reset("key"); err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := inc.Get("key"); v != 1 {
+			t.Errorf("Get should return 1 after reset, instead of %d", v)
+		}
+	})
+}
+
+func TestBackendExpiry(t *testing.T) {
+	testDialects(t, func(t *testing.T, b *Backend) {
+		now := time.Now()
+		b.now = func() time.Time { return now }
+		ctx := context.Background()
+
+		if err := b.Set(ctx, "session", 7, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(2 * time.Minute)
+		if _, err := b.Get(ctx, "session"); !errors.Is(err, incrmntr.ErrKeyNotFound) {
+			t.Errorf("Session should be expired, instead of %v", err)
+		}
+		if _, _, err := b.Add(ctx, "session", incrmntr.AddOp{Delta: 1}); !errors.Is(err, incrmntr.ErrConflict) {
+			t.Errorf("Add of expired key should fail with ErrConflict, instead of %v", err)
+		}
+		if created, err := b.Init(ctx, "session", 1, time.Minute); err != nil || !created {
+			t.Errorf("Expired key should be created again, instead of %v, %v", created, err)
+		}
+	})
+}
+
+func TestBackendConcurrentAddSafe(t *testing.T) {
+	testDialects(t, func(t *testing.T, b *Backend) {
+		inc, _ := incrmntr.NewWithBackend(b, 1000000, 1, 1, true)
+		defer inc.Close()
+
+		var wg sync.WaitGroup
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < 25; k++ {
+					if _, err := inc.AddSafe("key"); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		if v, _ := inc.Get("key"); v != 100 {
+			t.Errorf("Value should be 100, instead of %d", v)
+		}
+	})
+}
+
+func TestRolledTo(t *testing.T) {
+	op := incrmntr.AddOp{Delta: 1, Rollover: 3, Initial: 1, Cycle: true}
+	if !rolledTo(op, 1) || rolledTo(op, 2) {
+		t.Error("Only the initial value should be rolled")
+	}
+	op.Cycle = false
+	if rolledTo(op, 1) {
+		t.Error("Add without cycle shouldn't be rolled")
+	}
+}
+
+func TestNewInvalidTable(t *testing.T) {
+	if _, err := New(nil, Postgres, WithTable("counters; DROP TABLE users")); err == nil {
+		t.Error("Invalid table name should be rejected")
+	}
+}
+
+func TestDialectStatement(t *testing.T) {
+	if s := MySQL.statement(MySQL.InsertIgnore, "counters", 3); s != "INSERT IGNORE INTO counters (counter_key, value, expires_at) VALUES (?, ?, ?)" {
+		t.Errorf("MySQL statement is %s", s)
+	}
+	if s := Postgres.statement(selectValue, "counters", 2); s != "SELECT value FROM counters WHERE counter_key = $1 AND (expires_at = 0 OR expires_at > $2)" {
+		t.Errorf("Postgres statement is %s", s)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	for name, dialect := range map[string]Dialect{"returning": SQLite, "for_update": withoutReturning} {
+		dialect := dialect
+		t.Run(name, func(t *testing.T) {
+			incrmntrtest.Run(t, func(t *testing.T) (incrmntr.Backend, func()) {
+				db := openTestDB(t)
+				b, err := New(db, dialect)
+				if err != nil {
+					db.Close()
+					t.Fatal(err)
+				}
+				return b, func() { db.Close() }
+			})
+		})
+	}
+}
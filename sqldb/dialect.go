@@ -0,0 +1,105 @@
+package sqldb
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Dialect is the SQL of a database, in the statements %[1]s is the
+// name of the table and %[2]s, %[3]s, ... are the placeholders of the
+// parameters
+type Dialect struct {
+	// Name is the name of the backend in the spans
+	Name string
+
+	// Placeholder returns the n-th (1-based) parameter placeholder
+	Placeholder func(n int) string
+
+	// InsertIgnore inserts the key, value and expiry if the key doesn't exist
+	InsertIgnore string
+
+	// Upsert inserts or overwrites the key, value and expiry
+	Upsert string
+
+	// Returning reports whether UPDATE ... RETURNING is supported,
+	// without it the add runs as a SELECT locking the row and an UPDATE
+	Returning bool
+
+	// LockRow is appended to the SELECT of the add without RETURNING
+	LockRow string
+}
+
+// Postgres is the dialect of PostgreSQL
+var Postgres = Dialect{
+	Name:         "postgres",
+	Placeholder:  dollar,
+	InsertIgnore: `INSERT INTO %[1]s (counter_key, value, expires_at) VALUES (%[2]s, %[3]s, %[4]s) ON CONFLICT (counter_key) DO NOTHING`,
+	Upsert:       `INSERT INTO %[1]s (counter_key, value, expires_at) VALUES (%[2]s, %[3]s, %[4]s) ON CONFLICT (counter_key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`,
+	Returning:    true,
+}
+
+// SQLite is the dialect of SQLite 3.35 or later
+var SQLite = Dialect{
+	Name:         "sqlite",
+	Placeholder:  dollar,
+	InsertIgnore: Postgres.InsertIgnore,
+	Upsert:       Postgres.Upsert,
+	Returning:    true,
+}
+
+// MySQL is the dialect of MySQL and MariaDB
+var MySQL = Dialect{
+	Name:         "mysql",
+	Placeholder:  question,
+	InsertIgnore: `INSERT IGNORE INTO %[1]s (counter_key, value, expires_at) VALUES (%[2]s, %[3]s, %[4]s)`,
+	Upsert:       `INSERT INTO %[1]s (counter_key, value, expires_at) VALUES (%[2]s, %[3]s, %[4]s) ON DUPLICATE KEY UPDATE value = VALUES(value), expires_at = VALUES(expires_at)`,
+	Returning:    false,
+	LockRow:      " FOR UPDATE",
+}
+
+// the statements common in the dialects
+const (
+	createTable = `CREATE TABLE IF NOT EXISTS %[1]s (
+	counter_key VARCHAR(250) NOT NULL PRIMARY KEY,
+	value BIGINT NOT NULL,
+	expires_at BIGINT NOT NULL DEFAULT 0
+)`
+	selectValue   = `SELECT value FROM %[1]s WHERE counter_key = %[2]s AND (expires_at = 0 OR expires_at > %[3]s)`
+	deleteExpired = `DELETE FROM %[1]s WHERE counter_key = %[2]s AND expires_at <> 0 AND expires_at <= %[3]s`
+	updateValue   = `UPDATE %[1]s SET value = %[2]s, expires_at = %[3]s WHERE counter_key = %[4]s`
+
+	// updateReturning increments the value, or puts it back to the initial
+	// if it passes the threshold (rollover - delta)
+	updateReturning = `UPDATE %[1]s SET
+	value = CASE WHEN value > %[2]s THEN %[3]s ELSE value + %[4]s END,
+	expires_at = %[5]s
+WHERE counter_key = %[6]s AND (expires_at = 0 OR expires_at > %[7]s)
+RETURNING value`
+
+	// updateBatchReturning increments the value by the batch if it doesn't
+	// pass the threshold (rollover - delta), a crossing batch isn't applied
+	updateBatchReturning = `UPDATE %[1]s SET
+	value = value + %[2]s,
+	expires_at = %[3]s
+WHERE counter_key = %[4]s AND (expires_at = 0 OR expires_at > %[5]s) AND value <= %[6]s
+RETURNING value`
+)
+
+func dollar(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func question(n int) string {
+	return "?"
+}
+
+// statement formats the statement with the table and the placeholders
+func (d Dialect) statement(format string, table string, params int) string {
+	args := make([]interface{}, 0, params+1)
+	args = append(args, table)
+	for n := 1; n <= params; n++ {
+		args = append(args, d.Placeholder(n))
+	}
+
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,113 @@
+package incrmntr
+
+import (
+	"context"
+	"time"
+)
+
+// Attribute keys set on the spans
+const (
+	AttrKey          = "incrmntr.key"
+	AttrOperation    = "db.operation"
+	AttrSystem       = "db.system"
+	AttrRetryAttempt = "incrmntr.retry.attempt"
+	AttrRollover     = "incrmntr.rollover"
+)
+
+// Attribute is a key-value pair attached to a span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Tracer starts the spans of the Incrementer, the tracing
+// package adapts it to OpenTelemetry
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a single traced operation
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// SetTracer sets the tracer creating a span per public call
+// and a child span per storage operation of it
+func (i *Incrementer) SetTracer(tracer Tracer) {
+	i.tracer = tracer
+}
+
+type spanKey struct{}
+
+// noopSpan is used without tracer
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(attrs ...Attribute) {}
+func (noopSpan) RecordError(err error)            {}
+func (noopSpan) End()                             {}
+
+// startSpan starts the span of a public call and stores it in the
+// context, so the internal steps can attach attributes to it
+func (i *Incrementer) startSpan(ctx context.Context, name string, key string) (context.Context, Span) {
+	if i.tracer == nil {
+		return ctx, noopSpan{}
+	}
+	ctx, span := i.tracer.Start(ctx, "incrmntr."+name, Attribute{Key: AttrKey, Value: key})
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// spanFromContext returns the span of the public call
+func spanFromContext(ctx context.Context) Span {
+	if span, ok := ctx.Value(spanKey{}).(Span); ok {
+		return span
+	}
+	return noopSpan{}
+}
+
+// endSpan records the error if any and ends the span
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// storageName is the name of the storage in the spans
+func (i *Incrementer) storageName() string {
+	if i.backend != nil {
+		return i.backend.Name()
+	}
+	return "couchbase"
+}
+
+// storage runs a single storage operation in a child span, the timeout
+// of it is the timeout of the incrementer shortened to the deadline
+// of the context
+func (i *Incrementer) storage(ctx context.Context, operation string, key string, fn func(timeout time.Duration) error) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	timeout := i.GetTimeout()
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining < timeout {
+			timeout = remaining
+		}
+	}
+
+	defer func() { i.logStorageError(operation, key, err) }()
+	if i.tracer != nil {
+		var span Span
+		_, span = i.tracer.Start(ctx, i.storageName()+"."+operation,
+			Attribute{Key: AttrKey, Value: key},
+			Attribute{Key: AttrOperation, Value: operation},
+			Attribute{Key: AttrSystem, Value: i.storageName()},
+		)
+		defer func() { endSpan(span, err) }()
+	}
+
+	return fn(timeout)
+}
@@ -0,0 +1,133 @@
+// Package tracing adapts OpenTelemetry to the Tracer of the Incrementer.
+//
+// The gocb v2.0.0 SDK doesn't accept tracers implemented outside of it and
+// its operations can't take a parent span, so the storage operations are
+// traced by the Incrementer as child spans of the public calls instead.
+package tracing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures the Tracer
+type Option func(t *Tracer)
+
+// WithHashedKeys replaces the counter keys in the span attributes
+// with the truncated SHA-256 hash of them
+func WithHashedKeys() Option {
+	return func(t *Tracer) {
+		t.hashKeys = true
+	}
+}
+
+// Tracer implements incrmntr.Tracer with an OpenTelemetry tracer
+type Tracer struct {
+	tracer   trace.Tracer
+	hashKeys bool
+}
+
+// New creates the Tracer, the tracer usually comes from
+// otel.Tracer("github.com/PumpkinSeed/incrmntr")
+func New(tracer trace.Tracer, opts ...Option) *Tracer {
+	t := &Tracer{tracer: tracer}
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
+}
+
+// tracable is implemented by the incrementers supporting tracing
+type tracable interface {
+	SetTracer(tracer incrmntr.Tracer)
+}
+
+// Instrument sets the tracer on the incrementer if it supports tracing,
+// the middlewares wrapping it are walked through
+func (t *Tracer) Instrument(inc incrmntr.Incrmntr) incrmntr.Incrmntr {
+	if tr, ok := incrmntr.Lookup(inc, isTracable); ok {
+		tr.(tracable).SetTracer(t)
+	}
+
+	return inc
+}
+
+// Middleware returns Instrument as a middleware
+func (t *Tracer) Middleware() incrmntr.Middleware {
+	return t.Instrument
+}
+
+func isTracable(inc incrmntr.Incrmntr) bool {
+	_, ok := inc.(tracable)
+	return ok
+}
+
+// Start starts the span, the storage operations are client spans
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...incrmntr.Attribute) (context.Context, incrmntr.Span) {
+	kind := trace.SpanKindInternal
+	kvs := make([]attribute.KeyValue, 0, len(attrs)+1)
+	for _, attr := range attrs {
+		if attr.Key == incrmntr.AttrOperation {
+			kind = trace.SpanKindClient
+		}
+		kvs = append(kvs, t.convert(attr))
+	}
+
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(kvs...))
+
+	return ctx, &otelSpan{span: span, tracer: t}
+}
+
+// convert turns the attribute to the OpenTelemetry one
+func (t *Tracer) convert(attr incrmntr.Attribute) attribute.KeyValue {
+	if attr.Key == incrmntr.AttrKey && t.hashKeys {
+		sum := sha256.Sum256([]byte(fmt.Sprint(attr.Value)))
+		return attribute.String(attr.Key, hex.EncodeToString(sum[:8]))
+	}
+
+	switch v := attr.Value.(type) {
+	case string:
+		return attribute.String(attr.Key, v)
+	case bool:
+		return attribute.Bool(attr.Key, v)
+	case int:
+		return attribute.Int(attr.Key, v)
+	case int64:
+		return attribute.Int64(attr.Key, v)
+	case uint64:
+		return attribute.Int64(attr.Key, int64(v))
+	case float64:
+		return attribute.Float64(attr.Key, v)
+	}
+
+	return attribute.String(attr.Key, fmt.Sprint(attr.Value))
+}
+
+// otelSpan implements incrmntr.Span
+type otelSpan struct {
+	span   trace.Span
+	tracer *Tracer
+}
+
+func (s *otelSpan) SetAttributes(attrs ...incrmntr.Attribute) {
+	for _, attr := range attrs {
+		s.span.SetAttributes(s.tracer.convert(attr))
+	}
+}
+
+func (s *otelSpan) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s *otelSpan) End() {
+	s.span.End()
+}
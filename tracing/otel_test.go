@@ -0,0 +1,99 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/PumpkinSeed/incrmntr/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestTracer(opts ...Option) (*Tracer, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	return New(provider.Tracer("incrmntr"), opts...), recorder
+}
+
+func attributeValue(attrs []attribute.KeyValue, key string) (attribute.Value, bool) {
+	for _, kv := range attrs {
+		if string(kv.Key) == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracerSpans(t *testing.T) {
+	tracer, recorder := newTestTracer()
+
+	ctx, parent := tracer.Start(context.Background(), "incrmntr.AddSafe", incrmntr.Attribute{Key: incrmntr.AttrKey, Value: "orders"})
+	_, child := tracer.Start(ctx, "couchbase.Replace",
+		incrmntr.Attribute{Key: incrmntr.AttrKey, Value: "orders"},
+		incrmntr.Attribute{Key: incrmntr.AttrOperation, Value: "Replace"},
+		incrmntr.Attribute{Key: incrmntr.AttrSystem, Value: "couchbase"},
+	)
+	child.RecordError(errors.New("cas mismatch"))
+	child.End()
+	parent.SetAttributes(incrmntr.Attribute{Key: incrmntr.AttrRetryAttempt, Value: 2}, incrmntr.Attribute{Key: incrmntr.AttrRollover, Value: true})
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Spans should be 2, instead of %d", len(spans))
+	}
+	replace, addSafe := spans[0], spans[1]
+
+	if replace.Parent().SpanID() != addSafe.SpanContext().SpanID() {
+		t.Error("Storage span should be the child of the call span")
+	}
+	if replace.SpanKind() != trace.SpanKindClient {
+		t.Errorf("Storage span should be client span, instead of %s", replace.SpanKind())
+	}
+	if replace.Status().Code != codes.Error {
+		t.Errorf("Storage span status should be error, instead of %v", replace.Status().Code)
+	}
+	if v, ok := attributeValue(replace.Attributes(), "db.system"); !ok || v.AsString() != "couchbase" {
+		t.Errorf("db.system should be couchbase, instead of %v", v.AsString())
+	}
+	if v, ok := attributeValue(addSafe.Attributes(), incrmntr.AttrRetryAttempt); !ok || v.AsInt64() != 2 {
+		t.Errorf("Retry attempt should be 2, instead of %v", v.AsInt64())
+	}
+	if v, ok := attributeValue(addSafe.Attributes(), incrmntr.AttrRollover); !ok || !v.AsBool() {
+		t.Error("Rollover should be true")
+	}
+	if v, _ := attributeValue(addSafe.Attributes(), incrmntr.AttrKey); v.AsString() != "orders" {
+		t.Errorf("Key should be orders, instead of %s", v.AsString())
+	}
+}
+
+func TestTracerHashedKeys(t *testing.T) {
+	tracer, recorder := newTestTracer(WithHashedKeys())
+
+	_, span := tracer.Start(context.Background(), "incrmntr.Get", incrmntr.Attribute{Key: incrmntr.AttrKey, Value: "orders"})
+	span.End()
+
+	v, _ := attributeValue(recorder.Ended()[0].Attributes(), incrmntr.AttrKey)
+	if v.AsString() == "orders" || len(v.AsString()) != 16 {
+		t.Errorf("Key should be hashed, instead of %s", v.AsString())
+	}
+}
+
+func TestTracerIncrementer(t *testing.T) {
+	tracer, recorder := newTestTracer()
+
+	// ---- the nil bucket error recorded on the span of the call
+	inc := tracer.Instrument(&incrmntr.Incrementer{}).(*incrmntr.Incrementer)
+	if _, err := inc.AddSafeContext(context.Background(), "orders"); err == nil {
+		t.Fatal("AddSafe should fail without bucket")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "incrmntr.AddSafe" || spans[0].Status().Code != codes.Error {
+		t.Errorf("AddSafe should end with an error span, instead of %v", spans)
+	}
+}
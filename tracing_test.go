@@ -0,0 +1,88 @@
+package incrmntr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// recordingTracer records the names of the started spans and the
+// name of the parent span of them
+type recordingTracer struct {
+	spans []*recordingSpan
+}
+
+type recordingSpan struct {
+	name   string
+	parent string
+	attrs  []Attribute
+	err    error
+	ended  bool
+}
+
+type recordingParentKey struct{}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	parent, _ := ctx.Value(recordingParentKey{}).(string)
+	span := &recordingSpan{name: name, parent: parent, attrs: attrs}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, recordingParentKey{}, name), span
+}
+
+func (s *recordingSpan) SetAttributes(attrs ...Attribute) { s.attrs = append(s.attrs, attrs...) }
+func (s *recordingSpan) RecordError(err error)            { s.err = err }
+func (s *recordingSpan) End()                             { s.ended = true }
+
+func TestStorageSpan(t *testing.T) {
+	tracer := &recordingTracer{}
+	inc := &Incrementer{timeout: time.Second}
+	inc.SetTracer(tracer)
+
+	ctx, span := inc.startSpan(context.Background(), "AddSafe", "key")
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+
+	var timeout time.Duration
+	failure := errors.New("replace failed")
+	err := inc.storage(ctx, "Replace", "key", func(t time.Duration) error {
+		timeout = t
+		return failure
+	})
+	spanFromContext(ctx).SetAttributes(Attribute{Key: AttrRollover, Value: true})
+	endSpan(span, err)
+
+	if err != failure {
+		t.Errorf("Error should be %v, instead of %v", failure, err)
+	}
+	if timeout <= 0 || timeout > 100*time.Millisecond {
+		t.Errorf("Timeout should be shortened to the deadline, instead of %v", timeout)
+	}
+	if len(tracer.spans) != 2 {
+		t.Fatalf("Spans should be 2, instead of %d", len(tracer.spans))
+	}
+	call, storage := tracer.spans[0], tracer.spans[1]
+	if call.name != "incrmntr.AddSafe" || !call.ended || call.err != failure {
+		t.Errorf("Call span should be the ended incrmntr.AddSafe with error, instead of %+v", call)
+	}
+	if storage.name != "couchbase.Replace" || storage.parent != "incrmntr.AddSafe" || storage.err != failure {
+		t.Errorf("Storage span should be the failed child couchbase.Replace, instead of %+v", storage)
+	}
+	if last := call.attrs[len(call.attrs)-1]; last.Key != AttrRollover {
+		t.Errorf("Rollover should be set on the call span, instead of %v", last)
+	}
+}
+
+func TestStorageCanceled(t *testing.T) {
+	inc := &Incrementer{timeout: time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := inc.storage(ctx, "Get", "key", func(time.Duration) error {
+		t.Error("Storage operation shouldn't run with canceled context")
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Error should be context.Canceled, instead of %v", err)
+	}
+}
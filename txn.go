@@ -0,0 +1,240 @@
+package incrmntr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// ErrTxnConflict returned when the transaction can't lock or write
+// every key of it, none of the mutations applied in that case
+var ErrTxnConflict = errors.New("transaction conflict")
+
+// ErrTxnPartial returned when the commit failed and some of the written
+// keys couldn't be rolled back, they may keep the new values
+var ErrTxnPartial = errors.New("transaction partially applied")
+
+// TxnPartialError is the failed commit with the keys which may keep the
+// new value: their rollback failed or the outcome of their write is unknown
+type TxnPartialError struct {
+	Keys []string
+	Err  error
+}
+
+func (e *TxnPartialError) Error() string {
+	return fmt.Sprintf("%v: keys %s not rolled back: %v", ErrTxnPartial, strings.Join(e.Keys, ", "), e.Err)
+}
+
+// Is reports ErrTxnPartial
+func (e *TxnPartialError) Is(target error) bool {
+	return target == ErrTxnPartial
+}
+
+// Unwrap returns the cause of the failed commit
+func (e *TxnPartialError) Unwrap() error {
+	return e.Err
+}
+
+// txnLockTime is the lock duration for the keys of a transaction,
+// it has to cover the whole commit phase
+const txnLockTime = 1000 * time.Millisecond
+
+// Txn collects counter mutations and applies them all-or-nothing.
+// It uses CAS based optimistic locking: every key get locked, the new
+// values written with the CAS of the lock and the already written ones
+// get rolled back if a later write fails. The gocb v2.0.0 SDK has no
+// distributed transaction support, so this is the only strategy.
+type Txn struct {
+	inc *Incrementer
+	ops map[string]int64
+}
+
+// txnEntry holds the state of a single key during the commit
+type txnEntry struct {
+	key      string
+	delta    int64
+	cas      gocb.Cas
+	old      float64
+	new      float64
+	locked   bool
+	replaced bool
+
+	// unknown is true if the write of the key timed out, it may be done
+	unknown bool
+}
+
+// Txn creates a new empty transaction on the incrementer
+func (i *Incrementer) Txn() *Txn {
+	return &Txn{
+		inc: i,
+		ops: make(map[string]int64),
+	}
+}
+
+// Incr adds delta to the key on commit, mutations on the
+// same key are merged
+func (t *Txn) Incr(key string, delta uint64) *Txn {
+	t.ops[key] += int64(delta)
+	return t
+}
+
+// Decr subtracts delta from the key on commit
+func (t *Txn) Decr(key string, delta uint64) *Txn {
+	t.ops[key] -= int64(delta)
+	return t
+}
+
+// Commit applies every mutation of the transaction or none of them.
+// It returns ErrTxnConflict (wrapped with the cause) if one of the keys
+// locked by someone else or changed during the commit. Keys not exist
+// yet get created with the initial value before the mutation applied.
+// If a written key can't be rolled back, a *TxnPartialError (ErrTxnPartial)
+// returned with the keys which may keep the new value.
+func (t *Txn) Commit() (map[string]NullInt64, error) {
+	if err := t.inc.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.inc.release()
+
+	if t.inc.bucket == nil {
+		return nil, errors.New("error bucket is nil")
+	}
+	if len(t.ops) == 0 {
+		return map[string]NullInt64{}, nil
+	}
+
+	// ---- sort the keys, so concurrent transactions lock in the same order
+	entries := make([]*txnEntry, 0, len(t.ops))
+	for key, delta := range t.ops {
+		entries = append(entries, &txnEntry{key: key, delta: delta})
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].key < entries[b].key
+	})
+
+	// ---- lock every key and calculate the new values
+	for _, e := range entries {
+		if err := t.lock(e); err != nil {
+			t.unlock(entries)
+			return nil, txnConflict(err)
+		}
+	}
+
+	// ---- write the new values, roll back on the first failure
+	for _, e := range entries {
+		res, err := t.inc.bucket.DefaultCollection().Replace(e.key, e.new, &gocb.ReplaceOptions{
+			Cas:     e.cas,
+			Timeout: t.inc.GetTimeout(),
+		})
+		if err != nil {
+			e.unknown = ambiguous(err)
+			failed := t.rollback(entries)
+			t.unlock(entries)
+			if len(failed) > 0 {
+				return nil, &TxnPartialError{Keys: failed, Err: err}
+			}
+			return nil, txnConflict(err)
+		}
+		e.cas = res.Cas()
+		e.locked = false
+		e.replaced = true
+	}
+
+	result := make(map[string]NullInt64, len(entries))
+	for _, e := range entries {
+		result[e.key] = nullInt64From(int64(e.new))
+	}
+
+	return result, nil
+}
+
+// lock initialize the key if needed, locks it and
+// calculates the new value of the entry
+func (t *Txn) lock(e *txnEntry) error {
+	i := t.inc
+	if _, err := i.initKey(context.Background(), e.key); err != nil {
+		return err
+	}
+
+	var current interface{}
+	res, err := i.bucket.DefaultCollection().GetAndLock(e.key, txnLockTime, &gocb.GetAndLockOptions{
+		Timeout: i.GetTimeout(),
+	})
+	if err != nil {
+		return err
+	}
+	e.cas = res.Cas()
+	e.locked = true
+	if err := res.Content(&current); err != nil {
+		return err
+	}
+	value, ok := current.(float64)
+	if !ok {
+		return errors.New("error counter value is not a number")
+	}
+
+	e.old = value
+	e.new = value + float64(e.delta)
+	if i.cycle && e.new > float64(i.rollover) {
+		e.new = float64(i.initial)
+	}
+
+	return nil
+}
+
+// unlock releases the keys still locked by the transaction
+func (t *Txn) unlock(entries []*txnEntry) {
+	for _, e := range entries {
+		if !e.locked {
+			continue
+		}
+		_ = t.inc.bucket.DefaultCollection().Unlock(e.key, e.cas, &gocb.UnlockOptions{
+			Timeout: t.inc.GetTimeout(),
+		})
+		e.locked = false
+	}
+}
+
+// rollback writes back the old value of the already replaced keys,
+// the CAS of the write ensures no one else modified it since. It returns
+// the keys which may keep the new value: the failed rollbacks and the
+// timed out writes, unless the lock of the write is still held
+func (t *Txn) rollback(entries []*txnEntry) []string {
+	var failed []string
+	collection := t.inc.bucket.DefaultCollection()
+	for _, e := range entries {
+		switch {
+		case e.unknown:
+			// ---- the lock still held proves the write wasn't done
+			err := collection.Unlock(e.key, e.cas, &gocb.UnlockOptions{
+				Timeout: t.inc.GetTimeout(),
+			})
+			e.locked = false
+			e.unknown = false
+			if err != nil {
+				failed = append(failed, e.key)
+			}
+		case e.replaced:
+			_, err := collection.Replace(e.key, e.old, &gocb.ReplaceOptions{
+				Cas:     e.cas,
+				Timeout: t.inc.GetTimeout(),
+			})
+			e.replaced = false
+			if err != nil {
+				failed = append(failed, e.key)
+			}
+		}
+	}
+
+	return failed
+}
+
+// txnConflict wraps the cause of the failed commit with ErrTxnConflict
+func txnConflict(err error) error {
+	return fmt.Errorf("%w: %v", ErrTxnConflict, err)
+}
@@ -0,0 +1,103 @@
+package incrmntr
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/rs/xid"
+)
+
+func TestTxn(t *testing.T) {
+	var from = xid.New().String()
+	var to = xid.New().String()
+
+	bucket, closeCluster := getBucket()
+	defer closeCluster(nil)
+
+	inc, err := New(bucket, 999, 1, 1, false)
+	if err != nil {
+		t.Error(err)
+	}
+	incrementer := inc.(*Incrementer)
+
+	for i := 0; i < 10; i++ {
+		if _, err := inc.AddSafe(from); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := incrementer.Txn().Decr(from, 3).Incr(to, 3).Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result[from].Value != 7 {
+		t.Errorf("Value of %s should be 7, instead of %d", from, result[from].Value)
+	}
+	if result[to].Value != 4 {
+		t.Errorf("Value of %s should be 4, instead of %d", to, result[to].Value)
+	}
+
+	val, err := inc.Get(from)
+	if err != nil {
+		t.Error(err)
+	}
+	if val != 7 {
+		t.Errorf("Incrementer value should be 7, instead of %d", val)
+	}
+}
+
+func TestTxnConflict(t *testing.T) {
+	var from = xid.New().String()
+	var to = xid.New().String()
+
+	bucket, closeCluster := getBucket()
+	defer closeCluster(nil)
+
+	inc, err := New(bucket, 999, 1, 1, false)
+	if err != nil {
+		t.Error(err)
+	}
+	incrementer := inc.(*Incrementer)
+
+	if _, err := inc.AddSafe(from); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inc.AddSafe(to); err != nil {
+		t.Fatal(err)
+	}
+
+	// ---- hold the lock of one key, so the commit can't finish
+	res, err := bucket.DefaultCollection().GetAndLock(to, txnLockTime, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.DefaultCollection().Unlock(to, res.Cas(), nil)
+
+	_, err = incrementer.Txn().Decr(from, 1).Incr(to, 1).Commit()
+	if !errors.Is(err, ErrTxnConflict) {
+		t.Errorf("Error should be ErrTxnConflict, instead of %v", err)
+	}
+
+	val, err := inc.Get(from)
+	if err != nil {
+		t.Error(err)
+	}
+	if val != 1 {
+		t.Errorf("Incrementer value should be 1, instead of %d", val)
+	}
+}
+
+func TestTxnPartialError(t *testing.T) {
+	var err error = &TxnPartialError{Keys: []string{"a", "b"}, Err: gocb.ErrCasMismatch}
+	if !errors.Is(err, ErrTxnPartial) || !errors.Is(err, gocb.ErrCasMismatch) {
+		t.Errorf("error should be ErrTxnPartial with the cause, instead of %v", err)
+	}
+	if errors.Is(err, ErrTxnConflict) {
+		t.Error("partial error should not be a clean conflict")
+	}
+	var partial *TxnPartialError
+	if !errors.As(err, &partial) || len(partial.Keys) != 2 {
+		t.Errorf("keys should be reported, instead of %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+package incrmntr
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// DefaultWatchInterval is the polling interval of Watch
+const DefaultWatchInterval = 1 * time.Second
+
+// Change is a value change of a watched counter key
+type Change struct {
+	Key      string
+	Value    int64
+	Previous int64
+
+	// Rollover is true if the counter cycled back since the previous value
+	Rollover bool
+}
+
+// ChangeStream is implemented by the backends able to push the
+// value changes of a key (e.g. DCP), the returned channel closed
+// when the context is done
+type ChangeStream interface {
+	Changes(ctx context.Context, key string) (<-chan int64, error)
+}
+
+// SetChangeStream sets the push based source of Watch,
+// without it Watch polls the key
+func (i *Incrementer) SetChangeStream(stream ChangeStream) {
+	i.stream = stream
+}
+
+// SetWatchInterval sets the polling interval of Watch
+func (i *Incrementer) SetWatchInterval(interval time.Duration) {
+	i.watchInterval = interval
+}
+
+// Watch returns a channel of the value changes of the key, the channel
+// closed when the context is done or the incrementer closed. Without
+// ChangeStream the key polled, a change detected by the CAS of the document
+// on the bucket and by the value on the backends.
+func (i *Incrementer) Watch(ctx context.Context, key string) (<-chan Change, error) {
+	if i.Closed() {
+		return nil, ErrClosed
+	}
+	ctx, cancel := i.untilClosed(ctx)
+
+	changes := make(chan Change)
+	if i.stream != nil {
+		values, err := i.stream.Changes(ctx, key)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		go i.forward(ctx, key, values, changes)
+		return changes, nil
+	}
+
+	var read func(ctx context.Context) (int64, bool)
+	switch {
+	case i.backend != nil:
+		read = i.readBackend(key)
+	case i.bucket != nil:
+		read = i.readBucket(key)
+	default:
+		cancel()
+		return nil, errors.New("error bucket is nil")
+	}
+	values := make(chan int64)
+	go i.poll(ctx, values, read)
+	go i.forward(ctx, key, values, changes)
+
+	return changes, nil
+}
+
+// poll reads the key in every interval and sends the value if
+// the read function returned one
+func (i *Incrementer) poll(ctx context.Context, values chan<- int64, read func(ctx context.Context) (int64, bool)) {
+	defer close(values)
+
+	interval := i.watchInterval
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// ---- missing key and transient errors are retried in the next tick
+		if value, ok := read(ctx); ok {
+			select {
+			case values <- value:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// readBucket returns the read of the document, the value is
+// returned only when the CAS of the document changed
+func (i *Incrementer) readBucket(key string) func(ctx context.Context) (int64, bool) {
+	var lastCas gocb.Cas
+	return func(ctx context.Context) (int64, bool) {
+		res, err := i.bucket.DefaultCollection().Get(key, &gocb.GetOptions{
+			Timeout: i.GetTimeout(),
+		})
+		if err != nil || res.Cas() == lastCas {
+			return 0, false
+		}
+		var v interface{}
+		if err := res.Content(&v); err != nil {
+			return 0, false
+		}
+		value, ok := v.(float64)
+		if !ok {
+			return 0, false
+		}
+		lastCas = res.Cas()
+
+		return int64(value), true
+	}
+}
+
+// readBackend returns the read of the key on the backend, forward
+// drops the values equal to the previous one
+func (i *Incrementer) readBackend(key string) func(ctx context.Context) (int64, bool) {
+	return func(ctx context.Context) (int64, bool) {
+		ctx, cancel := context.WithTimeout(ctx, i.GetTimeout())
+		defer cancel()
+		value, err := i.backend.Get(ctx, key)
+
+		return value, err == nil
+	}
+}
+
+// forward turns the raw values to changes, the first value sent
+// as the baseline with itself as previous, later only the differing ones
+func (i *Incrementer) forward(ctx context.Context, key string, values <-chan int64, changes chan<- Change) {
+	defer close(changes)
+
+	var previous int64
+	var started bool
+	for value := range values {
+		if started && value == previous {
+			continue
+		}
+		change := Change{
+			Key:      key,
+			Value:    value,
+			Previous: previous,
+			Rollover: started && i.cycle && value < previous,
+		}
+		if !started {
+			change.Previous = value
+		}
+		started = true
+		previous = value
+
+		select {
+		case changes <- change:
+		case <-ctx.Done():
+			// ---- drain the source, it stops on the same context
+			for range values {
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package incrmntr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+type testChangeStream struct {
+	values []int64
+}
+
+func (s testChangeStream) Changes(ctx context.Context, key string) (<-chan int64, error) {
+	values := make(chan int64)
+	go func() {
+		defer close(values)
+		for _, v := range s.values {
+			select {
+			case values <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return values, nil
+}
+
+func TestWatchChangeStream(t *testing.T) {
+	inc := &Incrementer{cycle: true}
+	inc.SetChangeStream(testChangeStream{values: []int64{3, 3, 4, 5, 1}})
+
+	changes, err := inc.Watch(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected = []Change{
+		{Key: "key", Value: 3, Previous: 3},
+		{Key: "key", Value: 4, Previous: 3},
+		{Key: "key", Value: 5, Previous: 4},
+		{Key: "key", Value: 1, Previous: 5, Rollover: true},
+	}
+	var got []Change
+	for change := range changes {
+		got = append(got, change)
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("Changes should be %v, instead of %v", expected, got)
+	}
+	for k := range expected {
+		if got[k] != expected[k] {
+			t.Errorf("Change should be %v, instead of %v", expected[k], got[k])
+		}
+	}
+}
+
+func TestWatchPolling(t *testing.T) {
+	var key = xid.New().String()
+
+	bucket, closeCluster := getBucket()
+	defer closeCluster(nil)
+
+	inc, err := New(bucket, 999, 1, 1, false)
+	if err != nil {
+		t.Error(err)
+	}
+	incrementer := inc.(*Incrementer)
+	incrementer.SetWatchInterval(10 * time.Millisecond)
+
+	if _, err := inc.AddSafe(key); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	changes, err := incrementer.Watch(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change := <-changes; change.Value != 1 {
+		t.Errorf("Value should be 1, instead of %d", change.Value)
+	}
+
+	if _, err := inc.AddSafe(key); err != nil {
+		t.Fatal(err)
+	}
+	change := <-changes
+	if change.Value != 2 || change.Previous != 1 {
+		t.Errorf("Change should be 1 -> 2, instead of %d -> %d", change.Previous, change.Value)
+	}
+}
+
+func TestWatchBackend(t *testing.T) {
+	backend := newBatchBackend()
+	inc, _ := NewWithBackend(backend, 3, 1, 1, true)
+	incrementer := inc.(*Incrementer)
+	incrementer.SetWatchInterval(time.Millisecond)
+
+	if _, err := inc.AddSafe("key"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	changes, err := incrementer.Watch(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change := <-changes; change.Value != 1 {
+		t.Errorf("Value should be 1, instead of %d", change.Value)
+	}
+
+	// ---- the polled values are compared to the previous one
+	for _, expected := range []Change{
+		{Key: "key", Value: 2, Previous: 1},
+		{Key: "key", Value: 3, Previous: 2},
+		{Key: "key", Value: 1, Previous: 3, Rollover: true},
+	} {
+		if _, err := inc.AddSafe("key"); err != nil {
+			t.Fatal(err)
+		}
+		if change := <-changes; change != expected {
+			t.Errorf("Change should be %v, instead of %v", expected, change)
+		}
+	}
+}